@@ -0,0 +1,141 @@
+package cqs
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+var (
+	ErrEventApplierNotFound = errors.New("event applier not found")
+	ErrInvalidEventType     = errors.New("invalid event type")
+)
+
+// HydratableEvent is an event that can be filled in by an AggregateRoot, like the ones embedding BasicEvent.
+type HydratableEvent interface {
+	Event
+	Hydrate(id vo.ID, name EventName, at vo.DateTime, aggRootID vo.ID, version EventVersion)
+}
+
+// EventApplier changes the state of an aggregate according to an event.
+type EventApplier func(ev Event) error
+
+// AggregateRoot keeps the bookkeeping of an event sourced aggregate: its version, the events recorded but not
+// committed yet, and the appliers that change its state on each event. It's meant to be embedded.
+type AggregateRoot struct {
+	id       vo.ID
+	version  EventVersion
+	events   []Event
+	appliers map[EventName]EventApplier
+}
+
+// NewAggregateRoot is a constructor.
+func NewAggregateRoot(id vo.ID) AggregateRoot {
+	return AggregateRoot{
+		id:       id,
+		appliers: make(map[EventName]EventApplier),
+	}
+}
+
+// AggregateID returns the ID of the aggregate.
+func (a *AggregateRoot) AggregateID() vo.ID {
+	return a.id
+}
+
+// AggregateVersion returns the version of the last event applied to the aggregate.
+func (a *AggregateRoot) AggregateVersion() EventVersion {
+	return a.version
+}
+
+// ExpectedVersion returns the version of the aggregate before its uncommitted events,
+// which is the one to give to EventStore.Append.
+func (a *AggregateRoot) ExpectedVersion() EventVersion {
+	return a.version - EventVersion(len(a.events))
+}
+
+// On registers the applier of the events with the given name.
+func (a *AggregateRoot) On(name EventName, apply EventApplier) {
+	if a.appliers == nil {
+		a.appliers = make(map[EventName]EventApplier)
+	}
+
+	a.appliers[name] = apply
+}
+
+// OnEvent registers a typed applier of the events with the given name.
+func OnEvent[E Event](a *AggregateRoot, name EventName, apply func(ev E) error) {
+	a.On(name, func(ev Event) error {
+		e, ok := ev.(E)
+		if !ok {
+			return fmt.Errorf("%w: event %s expected %s, got %T", ErrInvalidEventType, name, typeName[E](), ev)
+		}
+
+		return apply(e)
+	})
+}
+
+// Record hydrates the event with a new ID, the current time, the aggregate ID and the next version,
+// applies it and keeps it as uncommitted.
+func (a *AggregateRoot) Record(name EventName, ev HydratableEvent) error {
+	ev.Hydrate(vo.NewID(), name, vo.DateTimeNow(), a.id, a.version+1)
+
+	if err := a.apply(ev); err != nil {
+		return err
+	}
+
+	a.events = append(a.events, ev)
+
+	return nil
+}
+
+// PullEvents returns the uncommitted events and forgets them.
+func (a *AggregateRoot) PullEvents() []Event {
+	events := a.events
+	a.events = nil
+
+	return events
+}
+
+// Restore sets the version of an aggregate whose state has been restored from a snapshot, and forgets
+// its uncommitted events.
+func (a *AggregateRoot) Restore(version EventVersion) {
+	a.version = version
+	a.events = nil
+}
+
+// Replay rebuilds the state of the aggregate applying a stream of already committed events.
+func (a *AggregateRoot) Replay(events ...Event) error {
+	for _, ev := range events {
+		if ev.EventAggregateRootID() != a.id {
+			return fmt.Errorf("%w: event %s belongs to aggregate %s, not %s",
+				ErrEventAggregateMismatch, ev.EventID(), ev.EventAggregateRootID(), a.id)
+		}
+
+		if ev.EventVersion() != a.version+1 {
+			return fmt.Errorf("%w: event %s has version %d, expected %d",
+				ErrEventVersionMismatch, ev.EventID(), ev.EventVersion(), a.version+1)
+		}
+
+		if err := a.apply(ev); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *AggregateRoot) apply(ev Event) error {
+	apply, ok := a.appliers[ev.EventName()]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrEventApplierNotFound, ev.EventName())
+	}
+
+	if err := apply(ev); err != nil {
+		return fmt.Errorf("apply event %s: %w", ev.EventName(), err)
+	}
+
+	a.version = ev.EventVersion()
+
+	return nil
+}
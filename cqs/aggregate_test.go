@@ -0,0 +1,187 @@
+package cqs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+const (
+	accountOpenedName    cqs.EventName = "account_opened"
+	moneyDepositedName   cqs.EventName = "money_deposited"
+	depositCommandName                 = "deposit"
+	errMsgNegativeAmount               = "negative amount"
+)
+
+type accountOpened struct {
+	cqs.BasicEvent
+	Owner string `json:"owner"`
+}
+
+type moneyDeposited struct {
+	cqs.BasicEvent
+	Amount int `json:"amount"`
+}
+
+type account struct {
+	cqs.AggregateRoot
+	owner   string
+	balance int
+}
+
+func newAccount(id vo.ID) *account {
+	a := &account{AggregateRoot: cqs.NewAggregateRoot(id)}
+	cqs.OnEvent(&a.AggregateRoot, accountOpenedName, func(ev *accountOpened) error {
+		a.owner = ev.Owner
+		return nil
+	})
+	cqs.OnEvent(&a.AggregateRoot, moneyDepositedName, func(ev *moneyDeposited) error {
+		a.balance += ev.Amount
+		return nil
+	})
+
+	return a
+}
+
+func (a *account) Open(owner string) error {
+	return a.Record(accountOpenedName, &accountOpened{Owner: owner})
+}
+
+func (a *account) Deposit(amount int) error {
+	if amount < 0 {
+		return errors.New(errMsgNegativeAmount)
+	}
+
+	return a.Record(moneyDepositedName, &moneyDeposited{Amount: amount})
+}
+
+type depositCommand struct {
+	AccountID vo.ID
+	Amount    int
+}
+
+func (depositCommand) CommandName() string {
+	return depositCommandName
+}
+
+func TestAggregateRoot(t *testing.T) {
+	require := require.New(t)
+
+	t.Run(`Given an aggregate,
+	when events are recorded,
+	then they are hydrated, applied and returned by PullEvents`, func(t *testing.T) {
+		id := vo.NewID()
+		acc := newAccount(id)
+
+		require.NoError(acc.Open("john"))
+		require.NoError(acc.Deposit(10))
+		require.NoError(acc.Deposit(5))
+		require.Equal("john", acc.owner)
+		require.Equal(15, acc.balance)
+		require.Equal(cqs.EventVersion(3), acc.AggregateVersion())
+		require.Equal(cqs.EventVersion(0), acc.ExpectedVersion())
+
+		events := acc.PullEvents()
+		require.Len(events, 3)
+
+		for i, ev := range events {
+			require.False(ev.EventID().IsEmpty())
+			require.False(ev.EventAt().IsZero())
+			require.Equal(id, ev.EventAggregateRootID())
+			require.Equal(cqs.EventVersion(i+1), ev.EventVersion())
+		}
+
+		require.Equal(accountOpenedName, events[0].EventName())
+		require.Empty(acc.PullEvents())
+		require.Equal(cqs.EventVersion(3), acc.ExpectedVersion())
+	})
+
+	t.Run(`Given an aggregate with recorded events,
+	when another instance replays them,
+	then it gets the same state and version`, func(t *testing.T) {
+		id := vo.NewID()
+		acc := newAccount(id)
+		require.NoError(acc.Open("john"))
+		require.NoError(acc.Deposit(10))
+
+		replayed := newAccount(id)
+		require.NoError(replayed.Replay(acc.PullEvents()...))
+		require.Equal("john", replayed.owner)
+		require.Equal(10, replayed.balance)
+		require.Equal(cqs.EventVersion(2), replayed.AggregateVersion())
+		require.Empty(replayed.PullEvents())
+	})
+
+	t.Run(`Given an aggregate,
+	when events out of order, of another aggregate or without applier are replayed,
+	then it returns an error`, func(t *testing.T) {
+		id := vo.NewID()
+
+		err := newAccount(id).Replay(newAggregateEvent(id, accountOpenedName, 2))
+		require.ErrorIs(err, cqs.ErrEventVersionMismatch)
+
+		err = newAccount(id).Replay(newAggregateEvent(vo.NewID(), accountOpenedName, 1))
+		require.ErrorIs(err, cqs.ErrEventAggregateMismatch)
+
+		err = newAccount(id).Replay(newAggregateEvent(id, "unknown", 1))
+		require.ErrorIs(err, cqs.ErrEventApplierNotFound)
+
+		err = newAccount(id).Replay(newAggregateEvent(id, accountOpenedName, 1))
+		require.ErrorIs(err, cqs.ErrInvalidEventType)
+	})
+}
+
+func TestAggregateRootCommandHandler(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+
+	t.Run(`Given a command handler that loads, changes and stores an aggregate,
+	when it handles a command,
+	then it returns the pulled events which are appended to the store`, func(t *testing.T) {
+		var store cqs.InMemoryEventStore
+
+		id := vo.NewID()
+		acc := newAccount(id)
+		require.NoError(acc.Open("john"))
+		require.NoError(store.Append(ctx, id, acc.ExpectedVersion(), acc.PullEvents()...))
+
+		handler := cqs.CommandHandlerFunc[depositCommand](func(ctx context.Context, cmd depositCommand) ([]cqs.Event, error) {
+			stream, err := store.Load(ctx, cmd.AccountID, 0)
+			if err != nil {
+				return nil, err
+			}
+
+			acc := newAccount(cmd.AccountID)
+			if err := acc.Replay(stream...); err != nil {
+				return nil, err
+			}
+
+			if err := acc.Deposit(cmd.Amount); err != nil {
+				return nil, err
+			}
+
+			expectedVersion := acc.ExpectedVersion()
+			events := acc.PullEvents()
+
+			return events, store.Append(ctx, acc.AggregateID(), expectedVersion, events...)
+		})
+
+		events, err := handler.Handle(ctx, depositCommand{AccountID: id, Amount: 7})
+		require.NoError(err)
+		require.Len(events, 1)
+		require.Equal(cqs.EventVersion(2), events[0].EventVersion())
+
+		_, err = handler.Handle(ctx, depositCommand{AccountID: id, Amount: -1})
+		require.ErrorContains(err, errMsgNegativeAmount)
+
+		stream, err := store.Load(ctx, id, 0)
+		require.NoError(err)
+		require.Len(stream, 2)
+	})
+}
@@ -0,0 +1,154 @@
+package cqs
+
+import (
+	"context"
+	"errors"
+)
+
+const (
+	defaultAsyncWorkers   = 1
+	defaultAsyncQueueSize = 100
+)
+
+var (
+	ErrEventsBusClosed = errors.New("events bus closed")
+	ErrEventsBusFull   = errors.New("events bus queue full")
+	ErrEventDropped    = errors.New("event dropped")
+)
+
+// BackPressurePolicy defines what Dispatch does when the events queue is full.
+type BackPressurePolicy int
+
+const (
+	// BackPressureBlock waits until there is room in the queue or the context is done.
+	BackPressureBlock BackPressurePolicy = iota
+	// BackPressureDropNewest discards the dispatched event and reports an ErrEventDropped to the error sink.
+	BackPressureDropNewest
+	// BackPressureError returns an ErrEventsBusFull.
+	BackPressureError
+)
+
+// ErrorSink receives the errors that can't be returned to the caller because they happen asynchronously.
+type ErrorSink interface {
+	HandleError(ctx context.Context, ev Event, err error)
+}
+
+// ErrorSinkFunc is a function that implements ErrorSink interface.
+type ErrorSinkFunc func(ctx context.Context, ev Event, err error)
+
+// HandleError is the ErrorSink interface implementation.
+func (f ErrorSinkFunc) HandleError(ctx context.Context, ev Event, err error) {
+	f(ctx, ev, err)
+}
+
+var _ ErrorSink = noopErrorSink{}
+
+type noopErrorSink struct{}
+
+func (noopErrorSink) HandleError(context.Context, Event, error) {}
+
+// AsyncEventsBusOpt is the common type of functions that set options on AsyncEventsBus construction.
+type AsyncEventsBusOpt func(bus *AsyncEventsBus)
+
+// AsyncEventsBusWorkersOpt sets the number of workers that process the events. The default is 1.
+func AsyncEventsBusWorkersOpt(workers int) AsyncEventsBusOpt {
+	return func(bus *AsyncEventsBus) {
+		if workers > 0 {
+			bus.workers = workers
+		}
+	}
+}
+
+// AsyncEventsBusQueueSizeOpt sets the size of the events queue. The default is 100.
+func AsyncEventsBusQueueSizeOpt(size int) AsyncEventsBusOpt {
+	return func(bus *AsyncEventsBus) {
+		if size >= 0 {
+			bus.queueSize = size
+		}
+	}
+}
+
+// AsyncEventsBusBackPressureOpt sets the policy applied when the queue is full. The default is BackPressureBlock.
+func AsyncEventsBusBackPressureOpt(policy BackPressurePolicy) AsyncEventsBusOpt {
+	return func(bus *AsyncEventsBus) {
+		bus.policy = policy
+	}
+}
+
+// AsyncEventsBusErrorSinkOpt sets the sink that receives the handler errors. By default, they are discarded.
+func AsyncEventsBusErrorSinkOpt(sink ErrorSink) AsyncEventsBusOpt {
+	return func(bus *AsyncEventsBus) {
+		if sink != nil {
+			bus.sink = sink
+		}
+	}
+}
+
+// AsyncEventsBusDelegateOpt sets the bus that holds the subscriptions and calls the handlers.
+// The default is a ConcurrentEventsBus.
+func AsyncEventsBusDelegateOpt(delegate EventsBus) AsyncEventsBusOpt {
+	return func(bus *AsyncEventsBus) {
+		if delegate != nil {
+			bus.delegate = delegate
+		}
+	}
+}
+
+var _ EventsBus = &AsyncEventsBus{}
+
+// AsyncEventsBus is an EventsBus that enqueues the dispatched events and handles them in a pool of workers,
+// so the caller doesn't wait for the handlers. Handler errors are reported to the error sink.
+type AsyncEventsBus struct {
+	delegate  EventsBus
+	sink      ErrorSink
+	policy    BackPressurePolicy
+	workers   int
+	queueSize int
+
+	queues *eventQueues
+}
+
+// NewAsyncEventsBus is a constructor. It starts the workers, which run until Shutdown is called.
+func NewAsyncEventsBus(opts ...AsyncEventsBusOpt) *AsyncEventsBus {
+	bus := &AsyncEventsBus{
+		delegate:  &ConcurrentEventsBus{},
+		sink:      noopErrorSink{},
+		policy:    BackPressureBlock,
+		workers:   defaultAsyncWorkers,
+		queueSize: defaultAsyncQueueSize,
+	}
+	for _, opt := range opts {
+		opt(bus)
+	}
+
+	bus.queues = newEventQueues(1, bus.queueSize, bus.policy, bus.sink)
+
+	for i := 0; i < bus.workers; i++ {
+		bus.queues.consume(0, bus.handle)
+	}
+
+	return bus
+}
+
+// Subscribe links a specific event with its handler.
+func (bus *AsyncEventsBus) Subscribe(name EventName, handler EventHandler) error {
+	return bus.delegate.Subscribe(name, handler)
+}
+
+// Dispatch enqueues the event and returns without waiting for its handlers.
+// The handlers receive a context with the values of ctx but not its cancellation.
+// When the bus is shut down while Dispatch waits for room in the queue, it returns an ErrEventsBusClosed.
+func (bus *AsyncEventsBus) Dispatch(ctx context.Context, ev Event) error {
+	return bus.queues.send(ctx, 0, ev)
+}
+
+// Shutdown stops accepting events and waits until the queued ones are handled or the context is done.
+func (bus *AsyncEventsBus) Shutdown(ctx context.Context) error {
+	return bus.queues.shutdown(ctx)
+}
+
+func (bus *AsyncEventsBus) handle(qe queuedEvent) {
+	if err := bus.delegate.Dispatch(qe.ctx, qe.ev); err != nil {
+		bus.sink.HandleError(qe.ctx, qe.ev, err)
+	}
+}
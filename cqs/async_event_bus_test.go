@@ -0,0 +1,214 @@
+package cqs_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+func TestAsyncEventsBusDispatch(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+	eventName := cqs.EventName("foo")
+	eventID := vo.NewID()
+	ev := &EventMock{
+		EventNameFunc: func() cqs.EventName { return eventName },
+		EventIDFunc:   func() vo.ID { return eventID },
+	}
+
+	t.Run(`Given an async events bus with several workers,
+	when events are dispatched and the bus is shut down,
+	then every event is handled before Shutdown returns`, func(t *testing.T) {
+		bus := cqs.NewAsyncEventsBus(cqs.AsyncEventsBusWorkersOpt(4), cqs.AsyncEventsBusQueueSizeOpt(10))
+		evHandlerMock := &EventHandlerMock{
+			HandleFunc: func(context.Context, cqs.Event) error { return nil },
+		}
+		require.NoError(bus.Subscribe(eventName, evHandlerMock))
+
+		const events = 50
+		for i := 0; i < events; i++ {
+			require.NoError(bus.Dispatch(ctx, ev))
+		}
+
+		require.NoError(bus.Shutdown(ctx))
+		require.Len(evHandlerMock.HandleCalls(), events)
+		require.ErrorIs(bus.Dispatch(ctx, ev), cqs.ErrEventsBusClosed)
+		require.NoError(bus.Shutdown(ctx))
+	})
+
+	t.Run(`Given an async events bus with a handler that returns error,
+	when an event is dispatched,
+	then the error is reported to the error sink`, func(t *testing.T) {
+		expectedErr := errors.New("event handler error")
+		errs := make(chan error, 1)
+		bus := cqs.NewAsyncEventsBus(cqs.AsyncEventsBusErrorSinkOpt(cqs.ErrorSinkFunc(func(_ context.Context, _ cqs.Event, err error) {
+			errs <- err
+		})))
+		require.NoError(bus.Subscribe(eventName, &EventHandlerMock{
+			HandleFunc: func(context.Context, cqs.Event) error { return expectedErr },
+		}))
+
+		require.NoError(bus.Dispatch(ctx, ev))
+		require.ErrorContains(<-errs, expectedErr.Error())
+		require.NoError(bus.Shutdown(ctx))
+	})
+
+	t.Run(`Given an async events bus,
+	when the dispatch context is canceled,
+	then the handler still receives a live context with the same values`, func(t *testing.T) {
+		type ctxKey struct{}
+
+		handlerCtx := make(chan context.Context, 1)
+		bus := cqs.NewAsyncEventsBus()
+		require.NoError(bus.Subscribe(eventName, &EventHandlerMock{
+			HandleFunc: func(ctx context.Context, _ cqs.Event) error {
+				handlerCtx <- ctx
+				return nil
+			},
+		}))
+
+		dispatchCtx, cancel := context.WithCancel(context.WithValue(ctx, ctxKey{}, "value"))
+		require.NoError(bus.Dispatch(dispatchCtx, ev))
+		cancel()
+
+		hCtx := <-handlerCtx
+		require.NoError(hCtx.Err())
+		require.Equal("value", hCtx.Value(ctxKey{}))
+		require.NoError(bus.Shutdown(ctx))
+	})
+}
+
+func TestAsyncEventsBusBackPressure(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+	eventName := cqs.EventName("foo")
+	eventID := vo.NewID()
+	ev := &EventMock{
+		EventNameFunc: func() cqs.EventName { return eventName },
+		EventIDFunc:   func() vo.ID { return eventID },
+	}
+
+	// fullBus returns a bus with one busy worker and a full queue of one event.
+	fullBus := func(opts ...cqs.AsyncEventsBusOpt) (*cqs.AsyncEventsBus, func()) {
+		var once sync.Once
+
+		started := make(chan struct{}, 2)
+		release := make(chan struct{})
+		opts = append(opts, cqs.AsyncEventsBusWorkersOpt(1), cqs.AsyncEventsBusQueueSizeOpt(1))
+		bus := cqs.NewAsyncEventsBus(opts...)
+		require.NoError(bus.Subscribe(eventName, &EventHandlerMock{
+			HandleFunc: func(context.Context, cqs.Event) error {
+				started <- struct{}{}
+				<-release
+
+				return nil
+			},
+		}))
+
+		require.NoError(bus.Dispatch(ctx, ev))
+		<-started
+		require.NoError(bus.Dispatch(ctx, ev))
+
+		return bus, func() { once.Do(func() { close(release) }) }
+	}
+
+	t.Run(`Given an async events bus with the error back-pressure policy and a full queue,
+	when Dispatch is called,
+	then it returns an ErrEventsBusFull`, func(t *testing.T) {
+		bus, release := fullBus(cqs.AsyncEventsBusBackPressureOpt(cqs.BackPressureError))
+
+		require.ErrorIs(bus.Dispatch(ctx, ev), cqs.ErrEventsBusFull)
+
+		release()
+		require.NoError(bus.Shutdown(ctx))
+	})
+
+	t.Run(`Given an async events bus with the drop-newest back-pressure policy and a full queue,
+	when Dispatch is called,
+	then the event is dropped and reported to the error sink`, func(t *testing.T) {
+		var sinkErrs []error
+
+		bus, release := fullBus(
+			cqs.AsyncEventsBusBackPressureOpt(cqs.BackPressureDropNewest),
+			cqs.AsyncEventsBusErrorSinkOpt(cqs.ErrorSinkFunc(func(_ context.Context, _ cqs.Event, err error) {
+				sinkErrs = append(sinkErrs, err)
+			})),
+		)
+
+		require.NoError(bus.Dispatch(ctx, ev))
+
+		release()
+		require.NoError(bus.Shutdown(ctx))
+		require.Len(sinkErrs, 1)
+		require.ErrorIs(sinkErrs[0], cqs.ErrEventDropped)
+	})
+
+	t.Run(`Given an async events bus with the block back-pressure policy and a full queue,
+	when Dispatch is called,
+	then it waits until the context is done`, func(t *testing.T) {
+		bus, release := fullBus()
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		require.ErrorIs(bus.Dispatch(timeoutCtx, ev), context.DeadlineExceeded)
+
+		release()
+		require.NoError(bus.Shutdown(ctx))
+	})
+
+	t.Run(`Given an async events bus with a busy worker,
+	when Shutdown is called with a context that expires,
+	then it returns the context error`, func(t *testing.T) {
+		bus, release := fullBus()
+		defer release()
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		require.ErrorIs(bus.Shutdown(timeoutCtx), context.DeadlineExceeded)
+	})
+
+	t.Run(`Given an async events bus with the block back-pressure policy and a handler that dispatches into the full queue,
+	when Shutdown is called,
+	then the blocked Dispatch returns an ErrEventsBusClosed and the queued events are handled`, func(t *testing.T) {
+		var calls int
+
+		blocked := make(chan struct{})
+		dispatchErr := make(chan error, 1)
+		bus := cqs.NewAsyncEventsBus(cqs.AsyncEventsBusWorkersOpt(1), cqs.AsyncEventsBusQueueSizeOpt(1))
+
+		require.NoError(bus.Subscribe(eventName, cqs.EventHandlerFunc(func(ctx context.Context, ev cqs.Event) error {
+			calls++
+			if calls > 1 {
+				return nil
+			}
+
+			require.NoError(bus.Dispatch(ctx, ev))
+			close(blocked)
+			dispatchErr <- bus.Dispatch(ctx, ev)
+
+			return nil
+		})))
+
+		require.NoError(bus.Dispatch(ctx, ev))
+		<-blocked
+		time.Sleep(10 * time.Millisecond)
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+
+		require.NoError(bus.Shutdown(timeoutCtx))
+		require.ErrorIs(<-dispatchErr, cqs.ErrEventsBusClosed)
+		require.Equal(2, calls)
+	})
+}
@@ -0,0 +1,628 @@
+package cqs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+const (
+	cloudEventsSpecVersion  = "1.0"
+	cloudEventsContentType  = "application/json"
+	cloudEventsHeaderPrefix = "ce-"
+	cloudEventsContentTypeH = "content-type"
+
+	cloudEventsEventVersionExt  = "eventversion"
+	cloudEventsSchemaVersionExt = "schemaversion"
+	cloudEventsCorrelationExt   = "correlationid"
+	cloudEventsCausationExt     = "causationid"
+	cloudEventsUserExt          = "userid"
+	cloudEventsTenantExt        = "tenantid"
+)
+
+var (
+	ErrEmptyCloudEventsSource       = errors.New("empty cloud events source")
+	ErrInvalidCloudEvent            = errors.New("invalid cloud event")
+	ErrUnsupportedCloudEventsFormat = errors.New("unsupported cloud events format")
+)
+
+var basicEventType = reflect.TypeOf(BasicEvent{})
+
+// cloudEventsAttributes are the context attributes defined by the specification, which can't be used as extensions.
+var cloudEventsAttributes = map[string]bool{
+	"specversion": true, "id": true, "source": true, "type": true, "subject": true, "time": true,
+	"datacontenttype": true, "dataschema": true, "data": true, "data_base64": true,
+}
+
+// CloudEvent is an event in the CloudEvents 1.0 format. In structured mode it's encoded as a JSON object with the
+// attributes, the extensions and the data as members.
+type CloudEvent struct {
+	SpecVersion     string
+	ID              string
+	Source          string
+	Type            string
+	Subject         string
+	Time            time.Time
+	DataContentType string
+	DataSchema      string
+	Extensions      map[string]string
+	Data            json.RawMessage
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (ce CloudEvent) MarshalJSON() ([]byte, error) {
+	members := make(map[string]any, len(ce.Extensions)+9)
+	for name, value := range ce.Extensions {
+		members[name] = value
+	}
+
+	members["specversion"] = ce.SpecVersion
+	members["id"] = ce.ID
+	members["source"] = ce.Source
+	members["type"] = ce.Type
+
+	optional := map[string]string{
+		"subject":         ce.Subject,
+		"datacontenttype": ce.DataContentType,
+		"dataschema":      ce.DataSchema,
+	}
+	for name, value := range optional {
+		if value != "" {
+			members[name] = value
+		}
+	}
+
+	if !ce.Time.IsZero() {
+		members["time"] = ce.Time.Format(time.RFC3339Nano)
+	}
+
+	if len(ce.Data) > 0 {
+		members["data"] = ce.Data
+	}
+
+	return json.Marshal(members)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. The extensions that aren't strings keep their JSON
+// representation, and the data encoded in base64 is decoded.
+func (ce *CloudEvent) UnmarshalJSON(data []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+
+	*ce = CloudEvent{}
+
+	for name, raw := range members {
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			value = string(raw)
+		}
+
+		switch name {
+		case "specversion":
+			ce.SpecVersion = value
+		case "id":
+			ce.ID = value
+		case "source":
+			ce.Source = value
+		case "type":
+			ce.Type = value
+		case "subject":
+			ce.Subject = value
+		case "datacontenttype":
+			ce.DataContentType = value
+		case "dataschema":
+			ce.DataSchema = value
+		case "time":
+			t, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return fmt.Errorf("%w: time: %s", ErrInvalidCloudEvent, err)
+			}
+
+			ce.Time = t
+		case "data":
+			ce.Data = raw
+		case "data_base64":
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return fmt.Errorf("%w: data_base64: %s", ErrInvalidCloudEvent, err)
+			}
+
+			ce.Data = decoded
+		default:
+			if ce.Extensions == nil {
+				ce.Extensions = make(map[string]string)
+			}
+
+			ce.Extensions[name] = value
+		}
+	}
+
+	return nil
+}
+
+// Validate checks the required attributes.
+func (ce CloudEvent) Validate() error {
+	switch {
+	case ce.SpecVersion != cloudEventsSpecVersion:
+		return fmt.Errorf("%w: unsupported specversion %q", ErrInvalidCloudEvent, ce.SpecVersion)
+	case ce.ID == "":
+		return fmt.Errorf("%w: empty id", ErrInvalidCloudEvent)
+	case ce.Source == "":
+		return fmt.Errorf("%w: empty source", ErrInvalidCloudEvent)
+	case ce.Type == "":
+		return fmt.Errorf("%w: empty type", ErrInvalidCloudEvent)
+	}
+
+	return nil
+}
+
+// CloudEventsCodecOpt is the common type of functions that set options on CloudEventsCodec construction.
+type CloudEventsCodecOpt func(c *CloudEventsCodec)
+
+// CloudEventsTypePrefixOpt sets the prefix of the type attribute, like "com.example.", which is followed by the
+// event name. By default, the type is the event name.
+func CloudEventsTypePrefixOpt(prefix string) CloudEventsCodecOpt {
+	return func(c *CloudEventsCodec) {
+		c.typePrefix = prefix
+	}
+}
+
+// CloudEventsDataSchemaOpt sets the function that returns the URI of the dataschema attribute of each event type.
+// By default, the attribute isn't set.
+func CloudEventsDataSchemaOpt(f func(name EventName, schema SchemaVersion) string) CloudEventsCodecOpt {
+	return func(c *CloudEventsCodec) {
+		if f != nil {
+			c.dataSchema = f
+		}
+	}
+}
+
+var _ EventCodec = &CloudEventsCodec{}
+
+// CloudEventsCodec encodes the events in the CloudEvents 1.0 format, and decodes them into the types registered in
+// an EventRegistry. The event ID is the id attribute, the name the type, the time the time, the aggregate ID the
+// subject, and the payload of the event's own fields, without its embedded BasicEvent, the data. The event version,
+// the schema version and the metadata are extensions: eventversion, schemaversion, correlationid, causationid,
+// userid, tenantid, and an extension for each header. The header names are lowercased and lose the characters
+// other than letters and digits, so "X-Request-Id" is the xrequestid extension. The headers left without name, or
+// named after an attribute or one of the extensions above, are skipped, and when two headers get the same name,
+// the one that already had it wins. The extensions decoded that aren't known are kept as headers.
+//
+// Marshal and Unmarshal use the structured mode, and EncodeBinary and DecodeBinary the binary mode.
+type CloudEventsCodec struct {
+	registry   *EventRegistry
+	source     string
+	typePrefix string
+	dataSchema func(name EventName, schema SchemaVersion) string
+}
+
+// NewCloudEventsCodec is a constructor. The source identifies the system that produces the events.
+func NewCloudEventsCodec(registry *EventRegistry, source string, opts ...CloudEventsCodecOpt) (*CloudEventsCodec, error) {
+	if source == "" {
+		return nil, ErrEmptyCloudEventsSource
+	}
+
+	c := &CloudEventsCodec{
+		registry: registry,
+		source:   source,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+// CloudEvent returns the event in the CloudEvents format.
+func (c *CloudEventsCodec) CloudEvent(ev Event) (CloudEvent, error) {
+	env, err := c.registry.Envelope(ev)
+	if err != nil {
+		return CloudEvent{}, err
+	}
+
+	ce := CloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              env.ID,
+		Source:          c.source,
+		Type:            c.typePrefix + string(env.Name),
+		Subject:         env.AggregateRootID,
+		Time:            time.Time(env.At),
+		DataContentType: cloudEventsContentType,
+		Extensions: map[string]string{
+			cloudEventsEventVersionExt:  strconv.Itoa(int(env.Version)),
+			cloudEventsSchemaVersionExt: strconv.Itoa(int(env.SchemaVersion)),
+		},
+	}
+
+	if ce.Data, err = cloudEventsData(ev, env.Payload); err != nil {
+		return CloudEvent{}, err
+	}
+
+	if c.dataSchema != nil {
+		ce.DataSchema = c.dataSchema(env.Name, env.SchemaVersion)
+	}
+
+	if env.Metadata != nil {
+		setCloudEventsMetadata(ce.Extensions, *env.Metadata)
+	}
+
+	return ce, nil
+}
+
+// Event decodes the CloudEvent into its registered type.
+func (c *CloudEventsCodec) Event(ce CloudEvent) (Event, error) {
+	if err := ce.Validate(); err != nil {
+		return nil, err
+	}
+
+	if ce.DataContentType != "" && !strings.HasPrefix(ce.DataContentType, cloudEventsContentType) {
+		return nil, fmt.Errorf("%w: datacontenttype %s", ErrUnsupportedCloudEventsFormat, ce.DataContentType)
+	}
+
+	if !strings.HasPrefix(ce.Type, c.typePrefix) {
+		return nil, fmt.Errorf("%w: type %s without prefix %s", ErrInvalidCloudEvent, ce.Type, c.typePrefix)
+	}
+
+	env := EventEnvelope{
+		ID:              ce.ID,
+		Name:            EventName(strings.TrimPrefix(ce.Type, c.typePrefix)),
+		AggregateRootID: ce.Subject,
+		At:              vo.NewDateTime(ce.Time),
+		Payload:         ce.Data,
+	}
+
+	extensions := make(map[string]string, len(ce.Extensions))
+	for name, value := range ce.Extensions {
+		extensions[name] = value
+	}
+
+	version, err := popCloudEventsInt(extensions, cloudEventsEventVersionExt)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := popCloudEventsInt(extensions, cloudEventsSchemaVersionExt)
+	if err != nil {
+		return nil, err
+	}
+
+	env.Version = EventVersion(version)
+	env.SchemaVersion = SchemaVersion(schema)
+
+	md, err := cloudEventsMetadata(extensions)
+	if err != nil {
+		return nil, err
+	}
+
+	if !md.IsEmpty() {
+		env.Metadata = &md
+	}
+
+	return c.registry.Open(env)
+}
+
+// Marshal is the EventCodec interface implementation. It uses the structured mode.
+func (c *CloudEventsCodec) Marshal(ev Event) ([]byte, error) {
+	ce, err := c.CloudEvent(ev)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(ce)
+}
+
+// Unmarshal is the EventCodec interface implementation. It uses the structured mode.
+func (c *CloudEventsCodec) Unmarshal(data []byte) (Event, error) {
+	var ce CloudEvent
+	if err := json.Unmarshal(data, &ce); err != nil {
+		return nil, fmt.Errorf("unmarshal cloud event: %w", err)
+	}
+
+	return c.Event(ce)
+}
+
+// EncodeBinary encodes the event in binary mode: the attributes and extensions are returned as headers prefixed
+// by "ce-", the data content type as the "content-type" header, and the data as the body.
+func (c *CloudEventsCodec) EncodeBinary(ev Event) (map[string]string, []byte, error) {
+	ce, err := c.CloudEvent(ev)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	headers := make(map[string]string, len(ce.Extensions)+8)
+	for name, value := range ce.Extensions {
+		headers[cloudEventsHeaderPrefix+name] = value
+	}
+
+	headers[cloudEventsHeaderPrefix+"specversion"] = ce.SpecVersion
+	headers[cloudEventsHeaderPrefix+"id"] = ce.ID
+	headers[cloudEventsHeaderPrefix+"source"] = ce.Source
+	headers[cloudEventsHeaderPrefix+"type"] = ce.Type
+	headers[cloudEventsContentTypeH] = ce.DataContentType
+
+	if !ce.Time.IsZero() {
+		headers[cloudEventsHeaderPrefix+"time"] = ce.Time.Format(time.RFC3339Nano)
+	}
+
+	if ce.Subject != "" {
+		headers[cloudEventsHeaderPrefix+"subject"] = ce.Subject
+	}
+
+	if ce.DataSchema != "" {
+		headers[cloudEventsHeaderPrefix+"dataschema"] = ce.DataSchema
+	}
+
+	return headers, ce.Data, nil
+}
+
+// DecodeBinary decodes an event encoded in binary mode. The header names are case-insensitive.
+func (c *CloudEventsCodec) DecodeBinary(headers map[string]string, body []byte) (Event, error) {
+	ce := CloudEvent{Data: body}
+
+	for key, value := range headers {
+		key = strings.ToLower(key)
+		if key == cloudEventsContentTypeH {
+			ce.DataContentType = value
+
+			continue
+		}
+
+		if !strings.HasPrefix(key, cloudEventsHeaderPrefix) {
+			continue
+		}
+
+		switch name := strings.TrimPrefix(key, cloudEventsHeaderPrefix); name {
+		case "specversion":
+			ce.SpecVersion = value
+		case "id":
+			ce.ID = value
+		case "source":
+			ce.Source = value
+		case "type":
+			ce.Type = value
+		case "subject":
+			ce.Subject = value
+		case "dataschema":
+			ce.DataSchema = value
+		case "time":
+			t, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: time: %s", ErrInvalidCloudEvent, err)
+			}
+
+			ce.Time = t
+		default:
+			if ce.Extensions == nil {
+				ce.Extensions = make(map[string]string)
+			}
+
+			ce.Extensions[name] = value
+		}
+	}
+
+	return c.Event(ce)
+}
+
+// cloudEventsData returns the payload members of the event's own fields, without the ones of its embedded
+// BasicEvent, which are attributes and extensions. They are told apart encoding the event with two different
+// BasicEvents: the members of the BasicEvent change, and the ones of the own fields don't, even if they have the same
+// name. The payload of the events that don't embed a BasicEvent is the data.
+func cloudEventsData(ev Event, payload json.RawMessage) (json.RawMessage, error) {
+	variants, ok := withOtherBasicEvents(ev)
+	if !ok {
+		return payload, nil
+	}
+
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &members); err != nil {
+		return payload, nil //nolint:nilerr // the payload isn't an object, so it has no fields to leave out.
+	}
+
+	variantMembers := make([]map[string]json.RawMessage, len(variants))
+
+	for i, variant := range variants {
+		encoded, err := json.Marshal(variant)
+		if err != nil {
+			return nil, fmt.Errorf("marshal event %s data: %w", ev.EventName(), err)
+		}
+
+		if err := json.Unmarshal(encoded, &variantMembers[i]); err != nil {
+			return nil, fmt.Errorf("unmarshal event %s data: %w", ev.EventName(), err)
+		}
+	}
+
+	own := make(map[string]json.RawMessage, len(members))
+
+	for name, value := range members {
+		first, ok := variantMembers[0][name]
+		if !ok {
+			continue
+		}
+
+		if second, ok := variantMembers[1][name]; ok && bytes.Equal(first, second) {
+			own[name] = value
+		}
+	}
+
+	data, err := json.Marshal(own)
+	if err != nil {
+		return nil, fmt.Errorf("marshal event %s data: %w", ev.EventName(), err)
+	}
+
+	return data, nil
+}
+
+// withOtherBasicEvents returns two copies of the event, which must be a struct or a pointer to a struct that
+// embeds a BasicEvent, each with a BasicEvent whose fields differ from the other's.
+func withOtherBasicEvents(ev Event) ([]any, bool) {
+	v := reflect.ValueOf(ev)
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil, false
+		}
+
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return nil, false
+	}
+
+	field, ok := v.Type().FieldByName(basicEventType.Name())
+	if !ok || !field.Anonymous || len(field.Index) != 1 {
+		return nil, false
+	}
+
+	at := time.Unix(0, 0)
+	bases := []BasicEvent{
+		{Name: "first", At: vo.NewDateTime(at), Version: 1},
+		{ID: vo.NewID(), Name: "second", At: vo.NewDateTime(at.Add(time.Second)), Version: 2, AggregateRootID: vo.NewID(),
+			Meta: &EventMetadata{UserID: "second"}},
+	}
+	variants := make([]any, len(bases))
+
+	for i := range bases {
+		variant := reflect.New(v.Type()).Elem()
+		variant.Set(v)
+
+		switch field.Type {
+		case basicEventType:
+			variant.Field(field.Index[0]).Set(reflect.ValueOf(bases[i]))
+		case reflect.PointerTo(basicEventType):
+			variant.Field(field.Index[0]).Set(reflect.ValueOf(&bases[i]))
+		default:
+			return nil, false
+		}
+
+		variants[i] = variant.Addr().Interface()
+	}
+
+	return variants, true
+}
+
+func setCloudEventsMetadata(extensions map[string]string, md EventMetadata) {
+	headers := make([]string, 0, len(md.Headers))
+	for header := range md.Headers {
+		headers = append(headers, header)
+	}
+
+	sort.Strings(headers)
+
+	for _, header := range headers {
+		name := cloudEventsExtensionName(header)
+		if !isCloudEventsExtensionName(name) || isCloudEventsKnownExtension(name) {
+			continue
+		}
+
+		if _, ok := extensions[name]; ok && header != name {
+			continue
+		}
+
+		extensions[name] = md.Headers[header]
+	}
+
+	optional := map[string]string{
+		cloudEventsCorrelationExt: idString(md.CorrelationID),
+		cloudEventsCausationExt:   idString(md.CausationID),
+		cloudEventsUserExt:        md.UserID,
+		cloudEventsTenantExt:      md.TenantID,
+	}
+	for name, value := range optional {
+		if value != "" {
+			extensions[name] = value
+		}
+	}
+}
+
+// cloudEventsMetadata returns the metadata of the extensions, once the versions are removed.
+func cloudEventsMetadata(extensions map[string]string) (EventMetadata, error) {
+	var (
+		md  EventMetadata
+		err error
+	)
+
+	if md.CorrelationID, err = parseOptionalID(extensions[cloudEventsCorrelationExt]); err != nil {
+		return EventMetadata{}, fmt.Errorf("%w: %s: %s", ErrInvalidCloudEvent, cloudEventsCorrelationExt, err)
+	}
+
+	if md.CausationID, err = parseOptionalID(extensions[cloudEventsCausationExt]); err != nil {
+		return EventMetadata{}, fmt.Errorf("%w: %s: %s", ErrInvalidCloudEvent, cloudEventsCausationExt, err)
+	}
+
+	md.UserID = extensions[cloudEventsUserExt]
+	md.TenantID = extensions[cloudEventsTenantExt]
+
+	for name, value := range extensions {
+		if !isCloudEventsKnownExtension(name) {
+			md = md.WithHeader(name, value)
+		}
+	}
+
+	return md, nil
+}
+
+func popCloudEventsInt(extensions map[string]string, name string) (int, error) {
+	value, ok := extensions[name]
+	if !ok {
+		return 0, nil
+	}
+
+	delete(extensions, name)
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s: %s", ErrInvalidCloudEvent, name, err)
+	}
+
+	return n, nil
+}
+
+func isCloudEventsKnownExtension(name string) bool {
+	switch name {
+	case cloudEventsEventVersionExt, cloudEventsSchemaVersionExt, cloudEventsCorrelationExt, cloudEventsCausationExt,
+		cloudEventsUserExt, cloudEventsTenantExt:
+		return true
+	}
+
+	return false
+}
+
+// cloudEventsExtensionName returns the header name in lowercase, without the characters other than letters and digits.
+func cloudEventsExtensionName(header string) string {
+	var b strings.Builder
+
+	for _, r := range strings.ToLower(header) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// isCloudEventsExtensionName reports whether the name is made of lowercase letters and digits, and isn't an
+// attribute of the specification.
+func isCloudEventsExtensionName(name string) bool {
+	if name == "" || cloudEventsAttributes[name] {
+		return false
+	}
+
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,206 @@
+package cqs_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+const productRenamedName cqs.EventName = "product_renamed"
+
+type productRenamed struct {
+	cqs.BasicEvent
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+func TestCloudEventsCodec(t *testing.T) {
+	require := require.New(t)
+
+	newCodec := func(t *testing.T) *cqs.CloudEventsCodec {
+		t.Helper()
+
+		c, err := cqs.NewCloudEventsCodec(newAccountEventRegistry(t), "/accounts",
+			cqs.CloudEventsTypePrefixOpt("com.example."),
+			cqs.CloudEventsDataSchemaOpt(func(name cqs.EventName, schema cqs.SchemaVersion) string {
+				return "https://example.com/schemas/" + string(name) + ".json"
+			}),
+		)
+		require.NoError(err)
+
+		return c
+	}
+
+	newDeposit := func() *moneyDepositedV2 {
+		ev := &moneyDepositedV2{Amount: 10, Currency: "EUR"}
+		ev.Hydrate(vo.NewID(), moneyDepositedName, vo.DateTimeNow(), vo.NewID(), 3)
+		ev.SetEventMetadata(cqs.EventMetadata{CorrelationID: vo.NewID(), UserID: "john"}.WithHeader("region", "eu"))
+
+		return ev
+	}
+
+	t.Run(`Given an empty source,
+	when a codec is created,
+	then it returns an error`, func(t *testing.T) {
+		_, err := cqs.NewCloudEventsCodec(newAccountEventRegistry(t), "")
+		require.ErrorIs(err, cqs.ErrEmptyCloudEventsSource)
+	})
+
+	t.Run(`Given a registered event,
+	when it's marshaled in structured mode,
+	then its fields are mapped to the CloudEvents attributes and it's decoded back into its type`, func(t *testing.T) {
+		c := newCodec(t)
+		ev := newDeposit()
+
+		data, err := c.Marshal(ev)
+		require.NoError(err)
+
+		var members map[string]any
+		require.NoError(json.Unmarshal(data, &members))
+		require.Equal("1.0", members["specversion"])
+		require.Equal(ev.EventID().String(), members["id"])
+		require.Equal("/accounts", members["source"])
+		require.Equal("com.example.money_deposited", members["type"])
+		require.Equal(ev.EventAggregateRootID().String(), members["subject"])
+		require.Equal("application/json", members["datacontenttype"])
+		require.Equal("https://example.com/schemas/money_deposited.json", members["dataschema"])
+		require.Equal("3", members["eventversion"])
+		require.Equal("2", members["schemaversion"])
+		require.Equal(ev.EventMetadata().CorrelationID.String(), members["correlationid"])
+		require.Equal("john", members["userid"])
+		require.Equal("eu", members["region"])
+		require.NotEmpty(members["time"])
+		require.Contains(members["data"], "currency")
+
+		decoded, err := c.Unmarshal(data)
+		require.NoError(err)
+		require.Equal(ev, decoded)
+	})
+
+	t.Run(`Given a registered event,
+	when it's encoded in binary mode,
+	then the attributes are headers, the data is the body and it's decoded back into its type`, func(t *testing.T) {
+		c := newCodec(t)
+		ev := newDeposit()
+
+		headers, body, err := c.EncodeBinary(ev)
+		require.NoError(err)
+		require.Equal("1.0", headers["ce-specversion"])
+		require.Equal(ev.EventID().String(), headers["ce-id"])
+		require.Equal("com.example.money_deposited", headers["ce-type"])
+		require.Equal(ev.EventAggregateRootID().String(), headers["ce-subject"])
+		require.Equal("3", headers["ce-eventversion"])
+		require.Equal("application/json", headers["content-type"])
+		require.JSONEq(`{"amount":10,"currency":"EUR"}`, string(body))
+
+		canonical := make(map[string]string, len(headers))
+		for key, value := range headers {
+			canonical["C"+key[1:]] = value
+		}
+
+		decoded, err := c.DecodeBinary(canonical, body)
+		require.NoError(err)
+		require.Equal(ev, decoded)
+	})
+
+	t.Run(`Given a CloudEvent produced by another system,
+	when it's unmarshaled,
+	then the missing versions default and the unknown extensions are kept as headers`, func(t *testing.T) {
+		c := newCodec(t)
+		id, aggregateID := vo.NewID(), vo.NewID()
+
+		decoded, err := c.Unmarshal([]byte(`{
+			"specversion": "1.0",
+			"id": "` + id.String() + `",
+			"source": "/other",
+			"type": "com.example.money_deposited",
+			"subject": "` + aggregateID.String() + `",
+			"time": "2024-01-01T00:00:00Z",
+			"traceparent": "00-abc-01",
+			"data": {"amount": 5}
+		}`))
+		require.NoError(err)
+
+		deposit, ok := decoded.(*moneyDeposited)
+		require.True(ok)
+		require.Equal(5, deposit.Amount)
+		require.Equal(id, deposit.EventID())
+		require.Equal(aggregateID, deposit.EventAggregateRootID())
+		require.Equal(map[string]string{"traceparent": "00-abc-01"}, deposit.EventMetadata().Headers)
+	})
+
+	t.Run(`Given invalid CloudEvents,
+	when they're unmarshaled,
+	then it returns an error`, func(t *testing.T) {
+		c := newCodec(t)
+
+		for _, data := range []string{
+			`{"specversion": "0.3", "id": "1", "source": "/", "type": "com.example.money_deposited"}`,
+			`{"specversion": "1.0", "source": "/", "type": "com.example.money_deposited"}`,
+			`{"specversion": "1.0", "id": "1", "source": "/", "type": "money_deposited"}`,
+			`{"specversion": "1.0", "id": "1", "source": "/", "type": "com.example.money_deposited", "eventversion": "x"}`,
+		} {
+			_, err := c.Unmarshal([]byte(data))
+			require.ErrorIs(err, cqs.ErrInvalidCloudEvent, data)
+		}
+
+		_, err := c.Unmarshal([]byte(`{"specversion": "1.0", "id": "1", "source": "/", "type": "com.example.foo"}`))
+		require.ErrorIs(err, cqs.ErrEventTypeNotRegistered)
+	})
+
+	t.Run(`Given an event with headers that aren't valid extension names,
+	when it's marshaled,
+	then they are normalized, and skipped when they are left without name or clash with a known one`, func(t *testing.T) {
+		c := newCodec(t)
+		ev := newDeposit()
+		ev.SetEventMetadata(ev.EventMetadata().
+			WithHeader("X-Request-Id", "1").
+			WithHeader("trace-id", "2").
+			WithHeader("traceid", "3").
+			WithHeader("User-ID", "mary").
+			WithHeader("Type", "other").
+			WithHeader("--", "4"))
+
+		data, err := c.Marshal(ev)
+		require.NoError(err)
+
+		var members map[string]any
+		require.NoError(json.Unmarshal(data, &members))
+		require.Equal("1", members["xrequestid"])
+		require.Equal("3", members["traceid"])
+		require.Equal("john", members["userid"])
+		require.Equal("com.example.money_deposited", members["type"])
+
+		decoded, err := c.Unmarshal(data)
+		require.NoError(err)
+		require.Equal(map[string]string{"region": "eu", "xrequestid": "1", "traceid": "3"}, decoded.(*moneyDepositedV2).EventMetadata().Headers)
+	})
+
+	t.Run(`Given an event with its own fields named like the ones of BasicEvent,
+	when it's marshaled,
+	then they are kept in the data and decoded back`, func(t *testing.T) {
+		r := cqs.NewEventRegistry()
+		require.NoError(cqs.RegisterEvent[productRenamed](r, productRenamedName, 1))
+
+		c, err := cqs.NewCloudEventsCodec(r, "/products")
+		require.NoError(err)
+
+		ev := &productRenamed{Name: "chair", Version: "v2"}
+		ev.Hydrate(vo.NewID(), productRenamedName, vo.DateTimeNow(), vo.NewID(), 4)
+
+		data, err := c.Marshal(ev)
+		require.NoError(err)
+
+		var members map[string]json.RawMessage
+		require.NoError(json.Unmarshal(data, &members))
+		require.JSONEq(`{"name":"chair","version":"v2"}`, string(members["data"]))
+
+		decoded, err := c.Unmarshal(data)
+		require.NoError(err)
+		require.Equal(ev, decoded)
+	})
+}
@@ -0,0 +1,137 @@
+package cqs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var (
+	ErrEmptyCommand                    = errors.New("empty command")
+	ErrEmptyCommandName                = errors.New("empty command name")
+	ErrCommandHandlerAlreadyRegistered = errors.New("command handler already registered")
+	ErrCommandHandlerNotFound          = errors.New("command handler not found")
+	ErrInvalidCommandType              = errors.New("invalid command type")
+)
+
+// CommandBusOpt is the common type of functions that set options on CommandBus construction.
+type CommandBusOpt func(bus *CommandBus)
+
+// CommandBusMiddlewareOpt sets the middlewares applied to every handler registered in the bus.
+func CommandBusMiddlewareOpt(middlewares ...CommandHandlerMiddleware[Command]) CommandBusOpt {
+	return func(bus *CommandBus) {
+		bus.middlewares = append(bus.middlewares, middlewares...)
+	}
+}
+
+// CommandBusEventsBusOpt sets the events bus where the events returned by the handlers are dispatched to.
+func CommandBusEventsBusOpt(eventsBus EventsBus) CommandBusOpt {
+	return func(bus *CommandBus) {
+		bus.eventsBus = eventsBus
+	}
+}
+
+var _ CommandHandler[Command] = &CommandBus{}
+
+// CommandBus routes each command to the handler registered for its name.
+// It's safe to register handlers and dispatch commands concurrently.
+type CommandBus struct {
+	mu          sync.RWMutex
+	handlers    map[string]CommandHandler[Command]
+	middlewares []CommandHandlerMiddleware[Command]
+	eventsBus   EventsBus
+}
+
+// NewCommandBus is a constructor.
+func NewCommandBus(opts ...CommandBusOpt) *CommandBus {
+	bus := &CommandBus{
+		handlers: make(map[string]CommandHandler[Command]),
+	}
+	for _, opt := range opts {
+		opt(bus)
+	}
+
+	return bus
+}
+
+// Register links a command name with its handler. The bus middlewares are applied to the handler.
+func (bus *CommandBus) Register(name string, handler CommandHandler[Command]) error {
+	if name == "" {
+		return ErrEmptyCommandName
+	}
+
+	if handler == nil {
+		return ErrEmptyCommandHandler
+	}
+
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	if bus.handlers == nil {
+		bus.handlers = make(map[string]CommandHandler[Command])
+	}
+
+	if _, ok := bus.handlers[name]; ok {
+		return fmt.Errorf("%w: %s", ErrCommandHandlerAlreadyRegistered, name)
+	}
+
+	bus.handlers[name] = CommandHandlerMultiMiddleware(bus.middlewares...)(handler)
+
+	return nil
+}
+
+// Dispatch calls the handler registered for the command and returns the events it produced.
+// When an events bus is attached, the events are dispatched to it before returning.
+func (bus *CommandBus) Dispatch(ctx context.Context, cmd Command) ([]Event, error) {
+	if cmd == nil {
+		return nil, ErrEmptyCommand
+	}
+
+	bus.mu.RLock()
+	handler, ok := bus.handlers[cmd.CommandName()]
+	bus.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrCommandHandlerNotFound, cmd.CommandName())
+	}
+
+	cmdCtx, mark := withDispatchMark(ctx, cmd.CommandName())
+
+	events, err := handler.Handle(cmdCtx, cmd)
+	if err != nil {
+		return events, err
+	}
+
+	multierror := NewMultiError()
+	mark.dispatch(ctx, bus.eventsBus, events, multierror)
+
+	return events, multierror.ErrResult()
+}
+
+// Handle is the CommandHandler interface implementation, so the bus can be used wherever a handler is expected.
+func (bus *CommandBus) Handle(ctx context.Context, cmd Command) ([]Event, error) {
+	return bus.Dispatch(ctx, cmd)
+}
+
+// RegisterCommandHandler links a command name with a typed command handler.
+func RegisterCommandHandler[C Command](bus *CommandBus, name string, handler CommandHandler[C]) error {
+	if handler == nil {
+		return ErrEmptyCommandHandler
+	}
+
+	return bus.Register(name, CommandHandlerFunc[Command](func(ctx context.Context, cmd Command) ([]Event, error) {
+		c, ok := cmd.(C)
+		if !ok {
+			return nil, fmt.Errorf("%w: expected %s, got %T", ErrInvalidCommandType, typeName[C](), cmd)
+		}
+
+		return handler.Handle(ctx, c)
+	}))
+}
+
+// typeName returns the name of T, even when T is an interface type.
+func typeName[T any]() string {
+	return reflect.TypeOf((*T)(nil)).Elem().String()
+}
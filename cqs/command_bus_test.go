@@ -0,0 +1,232 @@
+package cqs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+type helloCommand struct {
+	Name string
+}
+
+func (helloCommand) CommandName() string {
+	return "hello_command"
+}
+
+func TestCommandBusRegister(t *testing.T) {
+	require := require.New(t)
+
+	t.Run(`Given a command bus,
+	when Register is called with an empty command name,
+	then it returns an ErrEmptyCommandName`, func(t *testing.T) {
+		bus := cqs.NewCommandBus()
+
+		err := bus.Register("", &CommandHandlerMock[cqs.Command]{})
+		require.ErrorIs(err, cqs.ErrEmptyCommandName)
+	})
+
+	t.Run(`Given a command bus,
+	when Register is called with an empty command handler,
+	then it returns an ErrEmptyCommandHandler`, func(t *testing.T) {
+		bus := cqs.NewCommandBus()
+
+		err := bus.Register("foo", nil)
+		require.ErrorIs(err, cqs.ErrEmptyCommandHandler)
+	})
+
+	t.Run(`Given a command bus with a registered handler,
+	when Register is called with the same command name,
+	then it returns an ErrCommandHandlerAlreadyRegistered`, func(t *testing.T) {
+		bus := cqs.NewCommandBus()
+
+		err := bus.Register("foo", &CommandHandlerMock[cqs.Command]{})
+		require.NoError(err)
+
+		err = bus.Register("foo", &CommandHandlerMock[cqs.Command]{})
+		require.ErrorIs(err, cqs.ErrCommandHandlerAlreadyRegistered)
+	})
+}
+
+func TestCommandBusDispatch(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+	eventName := cqs.EventName("foo_happened")
+	eventID := vo.NewID()
+	cmd := &CommandMock{
+		CommandNameFunc: func() string { return "foo" },
+	}
+
+	t.Run(`Given a command bus,
+	when Dispatch is called with a command without handler,
+	then it returns an ErrCommandHandlerNotFound`, func(t *testing.T) {
+		bus := cqs.NewCommandBus()
+
+		_, err := bus.Dispatch(ctx, cmd)
+		require.ErrorIs(err, cqs.ErrCommandHandlerNotFound)
+	})
+
+	t.Run(`Given a command bus,
+	when Dispatch is called with a nil command,
+	then it returns an ErrEmptyCommand`, func(t *testing.T) {
+		bus := cqs.NewCommandBus()
+
+		_, err := bus.Dispatch(ctx, nil)
+		require.ErrorIs(err, cqs.ErrEmptyCommand)
+	})
+
+	t.Run(`Given a command bus with a handler that returns error,
+	when Dispatch is called,
+	then it returns the error`, func(t *testing.T) {
+		expectedErr := errors.New("command handler error")
+		bus := cqs.NewCommandBus()
+
+		err := bus.Register("foo", &CommandHandlerMock[cqs.Command]{
+			HandleFunc: func(context.Context, cqs.Command) ([]cqs.Event, error) {
+				return nil, expectedErr
+			},
+		})
+		require.NoError(err)
+
+		_, err = bus.Dispatch(ctx, cmd)
+		require.ErrorIs(err, expectedErr)
+	})
+
+	t.Run(`Given a command bus with middlewares and a registered handler,
+	when Dispatch is called,
+	then the middlewares wrap the handler and the events are returned`, func(t *testing.T) {
+		var mwExecutionOrder []int
+
+		mw := func(count int) cqs.CommandHandlerMiddleware[cqs.Command] {
+			return func(h cqs.CommandHandler[cqs.Command]) cqs.CommandHandler[cqs.Command] {
+				return cqs.CommandHandlerFunc[cqs.Command](func(ctx context.Context, cmd cqs.Command) ([]cqs.Event, error) {
+					mwExecutionOrder = append(mwExecutionOrder, count)
+					return h.Handle(ctx, cmd)
+				})
+			}
+		}
+		ev := &EventMock{
+			EventNameFunc: func() cqs.EventName { return eventName },
+			EventIDFunc:   func() vo.ID { return eventID },
+		}
+		cmdHandler := &CommandHandlerMock[cqs.Command]{
+			HandleFunc: func(context.Context, cqs.Command) ([]cqs.Event, error) {
+				return []cqs.Event{ev}, nil
+			},
+		}
+		bus := cqs.NewCommandBus(cqs.CommandBusMiddlewareOpt(mw(1), mw(2)))
+
+		err := bus.Register("foo", cmdHandler)
+		require.NoError(err)
+
+		events, err := bus.Dispatch(ctx, cmd)
+		require.NoError(err)
+		require.Equal([]cqs.Event{ev}, events)
+		require.Equal([]int{2, 1}, mwExecutionOrder)
+		require.Len(cmdHandler.HandleCalls(), 1)
+		require.Equal(cmd, cmdHandler.HandleCalls()[0].Cmd)
+	})
+
+	t.Run(`Given a command bus with an attached events bus,
+	when Dispatch is called,
+	then the resulting events are dispatched to the events bus`, func(t *testing.T) {
+		expectedErr := errors.New("event handler error")
+		ev := &EventMock{
+			EventNameFunc: func() cqs.EventName { return eventName },
+			EventIDFunc:   func() vo.ID { return eventID },
+		}
+		evHandler := &EventHandlerMock{
+			HandleFunc: func(context.Context, cqs.Event) error {
+				return expectedErr
+			},
+		}
+		eventsBus := &cqs.BasicEventsBus{}
+		require.NoError(eventsBus.Subscribe(eventName, evHandler))
+
+		bus := cqs.NewCommandBus(cqs.CommandBusEventsBusOpt(eventsBus))
+		err := bus.Register("foo", &CommandHandlerMock[cqs.Command]{
+			HandleFunc: func(context.Context, cqs.Command) ([]cqs.Event, error) {
+				return []cqs.Event{ev}, nil
+			},
+		})
+		require.NoError(err)
+
+		events, err := bus.Dispatch(ctx, cmd)
+		require.ErrorContains(err, expectedErr.Error())
+		require.Equal([]cqs.Event{ev}, events)
+		require.Len(evHandler.HandleCalls(), 1)
+		require.Equal(ev, evHandler.HandleCalls()[0].Event)
+	})
+
+	t.Run(`Given a command bus used as the command handler of an EventCommandHandler,
+	when the event is handled,
+	then the command is dispatched through the bus`, func(t *testing.T) {
+		cmdHandler := &CommandHandlerMock[cqs.Command]{
+			HandleFunc: func(context.Context, cqs.Command) ([]cqs.Event, error) {
+				return nil, nil
+			},
+		}
+		bus := cqs.NewCommandBus()
+		require.NoError(bus.Register("foo", cmdHandler))
+
+		eventCH, err := cqs.NewEventCommandHandler(func(cqs.Event) (cqs.Command, error) { return cmd, nil }, bus)
+		require.NoError(err)
+
+		require.NoError(eventCH.Handle(ctx, &EventMock{}))
+		require.Len(cmdHandler.HandleCalls(), 1)
+	})
+}
+
+func TestRegisterCommandHandler(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+
+	t.Run(`Given a command bus with a typed command handler,
+	when Dispatch is called with the typed command,
+	then the typed handler receives it`, func(t *testing.T) {
+		cmdHandler := &CommandHandlerMock[helloCommand]{
+			HandleFunc: func(context.Context, helloCommand) ([]cqs.Event, error) {
+				return nil, nil
+			},
+		}
+		bus := cqs.NewCommandBus()
+
+		err := cqs.RegisterCommandHandler[helloCommand](bus, helloCommand{}.CommandName(), cmdHandler)
+		require.NoError(err)
+
+		_, err = bus.Dispatch(ctx, helloCommand{Name: "john"})
+		require.NoError(err)
+		require.Len(cmdHandler.HandleCalls(), 1)
+		require.Equal(helloCommand{Name: "john"}, cmdHandler.HandleCalls()[0].Cmd)
+	})
+
+	t.Run(`Given a command bus with a typed command handler,
+	when Dispatch is called with a command of another type with the same name,
+	then it returns an ErrInvalidCommandType`, func(t *testing.T) {
+		bus := cqs.NewCommandBus()
+
+		err := cqs.RegisterCommandHandler[helloCommand](bus, "foo", &CommandHandlerMock[helloCommand]{})
+		require.NoError(err)
+
+		_, err = bus.Dispatch(ctx, &CommandMock{
+			CommandNameFunc: func() string { return "foo" },
+		})
+		require.ErrorIs(err, cqs.ErrInvalidCommandType)
+	})
+
+	t.Run(`Given a command bus,
+	when RegisterCommandHandler is called with an empty handler,
+	then it returns an ErrEmptyCommandHandler`, func(t *testing.T) {
+		bus := cqs.NewCommandBus()
+
+		err := cqs.RegisterCommandHandler[helloCommand](bus, "foo", nil)
+		require.ErrorIs(err, cqs.ErrEmptyCommandHandler)
+	})
+}
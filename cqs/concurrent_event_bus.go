@@ -0,0 +1,491 @@
+package cqs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidEventPattern         = errors.New("invalid event pattern")
+	ErrEmptyEventPredicate         = errors.New("empty event predicate")
+	ErrEventHandlerDependencyCycle = errors.New("event handler dependency cycle")
+)
+
+// SubscriptionOpt is the common type of functions that set options on the subscriptions of a ConcurrentEventsBus.
+type SubscriptionOpt func(s *subscription)
+
+// SubscriptionNameOpt names the handler, so other subscriptions can run after it and its errors identify it.
+func SubscriptionNameOpt(name string) SubscriptionOpt {
+	return func(s *subscription) {
+		s.handlerName = name
+	}
+}
+
+// SubscriptionPriorityOpt sets the priority of the handler. The handlers with higher priority run first.
+// The default is 0.
+func SubscriptionPriorityOpt(priority int) SubscriptionOpt {
+	return func(s *subscription) {
+		s.priority = priority
+	}
+}
+
+// SubscriptionAfterOpt makes the handler run after the handlers with the given names that handle the same event,
+// whatever their priority.
+func SubscriptionAfterOpt(names ...string) SubscriptionOpt {
+	return func(s *subscription) {
+		s.after = append(s.after, names...)
+	}
+}
+
+// ConcurrentEventsBusOpt is the common type of functions that set options on ConcurrentEventsBus construction.
+type ConcurrentEventsBusOpt func(bus *ConcurrentEventsBus)
+
+// ConcurrentEventsBusFanOutOpt makes Dispatch run the handlers of an event concurrently, at most limit at once,
+// or all of them if limit is 0. The handlers still start after the ones they depend on have finished.
+// By default, they run one after another.
+func ConcurrentEventsBusFanOutOpt(limit int) ConcurrentEventsBusOpt {
+	return func(bus *ConcurrentEventsBus) {
+		if limit >= 0 {
+			bus.fanOut = true
+			bus.limit = limit
+		}
+	}
+}
+
+// ConcurrentEventsBusHandlerTimeoutOpt sets the timeout of the context given to each handler.
+// The handlers must honor the context. By default, there is no timeout.
+func ConcurrentEventsBusHandlerTimeoutOpt(timeout time.Duration) ConcurrentEventsBusOpt {
+	return func(bus *ConcurrentEventsBus) {
+		if timeout > 0 {
+			bus.timeout = timeout
+		}
+	}
+}
+
+var _ EventsBus = &ConcurrentEventsBus{}
+
+// ConcurrentEventsBus is an EventsBus that is safe for subscribing, unsubscribing and dispatching concurrently.
+// Besides the exact event names, the handlers can be subscribed by pattern, predicate or to every event.
+// Its zero value is ready to use, and runs the handlers one after another without timeout.
+type ConcurrentEventsBus struct {
+	mu             sync.RWMutex
+	seq            uint64
+	handlersByName map[EventName][]*subscription
+	// matchers are the subscriptions by pattern, predicate or to every event, in subscription order.
+	matchers []*subscription
+
+	fanOut  bool
+	limit   int
+	timeout time.Duration
+}
+
+// NewConcurrentEventsBus is a constructor.
+func NewConcurrentEventsBus(opts ...ConcurrentEventsBusOpt) *ConcurrentEventsBus {
+	bus := &ConcurrentEventsBus{}
+	for _, opt := range opts {
+		opt(bus)
+	}
+
+	return bus
+}
+
+type subscription struct {
+	id      uint64
+	name    EventName
+	match   func(ev Event) bool
+	handler EventHandler
+
+	handlerName string
+	priority    int
+	after       []string
+}
+
+// Subscription is the handle of a handler subscribed to a ConcurrentEventsBus.
+type Subscription struct {
+	bus  *ConcurrentEventsBus
+	sub  *subscription
+	once sync.Once
+}
+
+// Unsubscribe removes the handler from the bus. Dispatches already in progress may still call it.
+// It's safe to call it more than once.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		s.bus.remove(s.sub)
+	})
+}
+
+// Subscribe links a specific event with its handler.
+func (bus *ConcurrentEventsBus) Subscribe(name EventName, handler EventHandler) error {
+	_, err := bus.Attach(name, handler)
+
+	return err
+}
+
+// Attach links a specific event with its handler and returns the subscription handle.
+func (bus *ConcurrentEventsBus) Attach(name EventName, handler EventHandler, opts ...SubscriptionOpt) (*Subscription, error) {
+	if name == "" {
+		return nil, ErrEmptyEventName
+	}
+
+	return bus.attach(&subscription{name: name, handler: handler}, opts)
+}
+
+// AttachPattern links the events whose name matches the glob pattern, like "order.*", with the handler.
+// The pattern syntax is the one of path.Match.
+func (bus *ConcurrentEventsBus) AttachPattern(pattern string, handler EventHandler, opts ...SubscriptionOpt) (*Subscription, error) {
+	if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidEventPattern, pattern)
+	}
+
+	return bus.attach(&subscription{
+		match: func(ev Event) bool {
+			matched, _ := path.Match(pattern, string(ev.EventName()))
+
+			return matched
+		},
+		handler: handler,
+	}, opts)
+}
+
+// AttachAll links every event with the handler.
+func (bus *ConcurrentEventsBus) AttachAll(handler EventHandler, opts ...SubscriptionOpt) (*Subscription, error) {
+	return bus.attach(&subscription{
+		match:   func(Event) bool { return true },
+		handler: handler,
+	}, opts)
+}
+
+// AttachFunc links the events the predicate returns true for with the handler.
+func (bus *ConcurrentEventsBus) AttachFunc(predicate func(ev Event) bool, handler EventHandler, opts ...SubscriptionOpt) (*Subscription, error) {
+	if predicate == nil {
+		return nil, ErrEmptyEventPredicate
+	}
+
+	return bus.attach(&subscription{match: predicate, handler: handler}, opts)
+}
+
+func (bus *ConcurrentEventsBus) attach(sub *subscription, opts []SubscriptionOpt) (*Subscription, error) {
+	if sub.handler == nil {
+		return nil, ErrEmptyEventHandler
+	}
+
+	for _, opt := range opts {
+		opt(sub)
+	}
+
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	if err := bus.checkDependencies(sub); err != nil {
+		return nil, err
+	}
+
+	bus.seq++
+	sub.id = bus.seq
+
+	// The slices are never modified in place, so Dispatch can iterate them without holding the lock.
+	if sub.match != nil {
+		bus.matchers = appendSubscription(bus.matchers, sub)
+
+		return &Subscription{bus: bus, sub: sub}, nil
+	}
+
+	if bus.handlersByName == nil {
+		bus.handlersByName = make(map[EventName][]*subscription)
+	}
+
+	bus.handlersByName[sub.name] = appendSubscription(bus.handlersByName[sub.name], sub)
+
+	return &Subscription{bus: bus, sub: sub}, nil
+}
+
+// Dispatch receives an event and calls its handlers. They run after the handlers they depend on, then by priority,
+// then in subscription order whether they are subscribed by name, pattern, predicate or to every event.
+// The errors are returned in that order, whether the handlers run concurrently or not.
+// Retries must be handled by the caller.
+func (bus *ConcurrentEventsBus) Dispatch(ctx context.Context, ev Event) error {
+	subs := bus.subscriptions(ev)
+	errs := make([]error, len(subs))
+
+	if bus.fanOut {
+		bus.dispatchFanOut(ctx, ev, subs, errs)
+	} else {
+		for i, s := range subs {
+			errs[i] = bus.handle(ctx, ev, s)
+		}
+	}
+
+	multierror := NewMultiError()
+
+	for i, err := range errs {
+		if err != nil {
+			multierror.Add(newEventsBusError(ev, subs[i].identity(), err))
+		}
+	}
+
+	return multierror.ErrResult()
+}
+
+// dispatchFanOut runs each handler in its own goroutine once the handlers it depends on, which are before it, have
+// finished, and sets its error.
+func (bus *ConcurrentEventsBus) dispatchFanOut(ctx context.Context, ev Event, subs []*subscription, errs []error) {
+	var (
+		wg    sync.WaitGroup
+		slots chan struct{}
+	)
+
+	if bus.limit > 0 {
+		slots = make(chan struct{}, bus.limit)
+	}
+
+	done := make([]chan struct{}, len(subs))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	wg.Add(len(subs))
+
+	for i, s := range subs {
+		go func(i int, s *subscription) {
+			defer wg.Done()
+			defer close(done[i])
+
+			for j := 0; j < i; j++ {
+				if dependsOn(s, subs[j]) {
+					<-done[j]
+				}
+			}
+
+			if slots != nil {
+				slots <- struct{}{}
+				defer func() { <-slots }()
+			}
+
+			errs[i] = bus.handle(ctx, ev, s)
+		}(i, s)
+	}
+
+	wg.Wait()
+}
+
+// handle calls the handler with the timeout, if any.
+func (bus *ConcurrentEventsBus) handle(ctx context.Context, ev Event, s *subscription) error {
+	if bus.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, bus.timeout)
+		defer cancel()
+	}
+
+	return s.handler.Handle(ctx, ev)
+}
+
+// identity returns the name the handler is subscribed with, or its own name.
+func (s *subscription) identity() string {
+	if s.handlerName != "" {
+		return s.handlerName
+	}
+
+	return handlerName(s.handler)
+}
+
+// subscriptions returns the subscriptions of the event, in the order they run.
+func (bus *ConcurrentEventsBus) subscriptions(ev Event) []*subscription {
+	bus.mu.RLock()
+	byName := bus.handlersByName[ev.EventName()]
+	matchers := bus.matchers
+	bus.mu.RUnlock()
+
+	if len(matchers) == 0 {
+		return orderSubscriptions(byName)
+	}
+
+	// Both slices are sorted by id, so they are merged.
+	subs := make([]*subscription, 0, len(byName)+len(matchers))
+	i := 0
+
+	for _, m := range matchers {
+		if !m.match(ev) {
+			continue
+		}
+
+		for ; i < len(byName) && byName[i].id < m.id; i++ {
+			subs = append(subs, byName[i])
+		}
+
+		subs = append(subs, m)
+	}
+
+	return orderSubscriptions(append(subs, byName[i:]...))
+}
+
+// checkDependencies returns an error if the handler depends on a handler that runs after it, directly or not.
+// It must be called with the lock held.
+func (bus *ConcurrentEventsBus) checkDependencies(sub *subscription) error {
+	if sub.handlerName == "" || len(sub.after) == 0 {
+		return nil
+	}
+
+	// dependents links each handler name with the names of the handlers that run after it.
+	dependents := make(map[string][]string)
+	addDependents := func(subs []*subscription) {
+		for _, s := range subs {
+			if s.handlerName == "" {
+				continue
+			}
+
+			for _, dep := range s.after {
+				dependents[dep] = append(dependents[dep], s.handlerName)
+			}
+		}
+	}
+
+	for _, subs := range bus.handlersByName {
+		addDependents(subs)
+	}
+
+	addDependents(bus.matchers)
+
+	deps := make(map[string]bool, len(sub.after))
+	for _, dep := range sub.after {
+		deps[dep] = true
+	}
+
+	visited := make(map[string]bool)
+	pending := []string{sub.handlerName}
+
+	for len(pending) > 0 {
+		name := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		if deps[name] {
+			return fmt.Errorf("%w: %s runs after %s", ErrEventHandlerDependencyCycle, name, sub.handlerName)
+		}
+
+		if visited[name] {
+			continue
+		}
+
+		visited[name] = true
+		pending = append(pending, dependents[name]...)
+	}
+
+	return nil
+}
+
+// orderSubscriptions sorts the subscriptions, given in subscription order, so each one runs after its dependencies,
+// then by priority.
+func orderSubscriptions(subs []*subscription) []*subscription {
+	sorted := true
+
+	for _, s := range subs {
+		if s.priority != 0 || len(s.after) > 0 {
+			sorted = false
+
+			break
+		}
+	}
+
+	if sorted {
+		return subs
+	}
+
+	remaining := append([]*subscription(nil), subs...)
+	ordered := make([]*subscription, 0, len(subs))
+
+	for len(remaining) > 0 {
+		next := -1
+
+		for i, s := range remaining {
+			if !subscriptionReady(s, remaining) {
+				continue
+			}
+
+			if next == -1 || s.priority > remaining[next].priority {
+				next = i
+			}
+		}
+
+		// The dependencies are checked on subscription, so there are no cycles.
+		if next == -1 {
+			next = 0
+		}
+
+		ordered = append(ordered, remaining[next])
+		remaining = append(remaining[:next], remaining[next+1:]...)
+	}
+
+	return ordered
+}
+
+// dependsOn reports whether the subscription runs after the other one.
+func dependsOn(sub, other *subscription) bool {
+	if other.handlerName == "" {
+		return false
+	}
+
+	for _, dep := range sub.after {
+		if dep == other.handlerName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// subscriptionReady reports whether none of the remaining subscriptions is a dependency of the subscription.
+func subscriptionReady(sub *subscription, remaining []*subscription) bool {
+	for _, s := range remaining {
+		if s != sub && dependsOn(sub, s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (bus *ConcurrentEventsBus) remove(sub *subscription) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	if sub.match != nil {
+		bus.matchers = removeSubscription(bus.matchers, sub)
+
+		return
+	}
+
+	subs := removeSubscription(bus.handlersByName[sub.name], sub)
+	if len(subs) == 0 {
+		delete(bus.handlersByName, sub.name)
+
+		return
+	}
+
+	bus.handlersByName[sub.name] = subs
+}
+
+// appendSubscription returns a copy of the subscriptions with the new one.
+func appendSubscription(subs []*subscription, sub *subscription) []*subscription {
+	newSubs := make([]*subscription, len(subs), len(subs)+1)
+	copy(newSubs, subs)
+
+	return append(newSubs, sub)
+}
+
+// removeSubscription returns a copy of the subscriptions without the given one.
+func removeSubscription(subs []*subscription, sub *subscription) []*subscription {
+	newSubs := make([]*subscription, 0, len(subs))
+
+	for _, s := range subs {
+		if s.id != sub.id {
+			newSubs = append(newSubs, s)
+		}
+	}
+
+	return newSubs
+}
@@ -0,0 +1,392 @@
+package cqs_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+func TestConcurrentEventsBusUnsubscribe(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+	eventName := cqs.EventName("foo")
+	eventID := vo.NewID()
+	ev := &EventMock{
+		EventNameFunc: func() cqs.EventName { return eventName },
+		EventIDFunc:   func() vo.ID { return eventID },
+	}
+
+	t.Run(`Given a concurrent events bus with two subscribed handlers,
+	when one of them is unsubscribed,
+	then only the other one is called on Dispatch`, func(t *testing.T) {
+		var bus cqs.ConcurrentEventsBus
+
+		evHandlerMock1 := &EventHandlerMock{
+			HandleFunc: func(context.Context, cqs.Event) error { return nil },
+		}
+		evHandlerMock2 := &EventHandlerMock{
+			HandleFunc: func(context.Context, cqs.Event) error { return nil },
+		}
+
+		sub1, err := bus.Attach(eventName, evHandlerMock1)
+		require.NoError(err)
+
+		_, err = bus.Attach(eventName, evHandlerMock2)
+		require.NoError(err)
+
+		sub1.Unsubscribe()
+		sub1.Unsubscribe()
+
+		require.NoError(bus.Dispatch(ctx, ev))
+		require.Empty(evHandlerMock1.HandleCalls())
+		require.Len(evHandlerMock2.HandleCalls(), 1)
+	})
+
+	t.Run(`Given a concurrent events bus,
+	when Attach is called with an empty event name or handler,
+	then it returns an error`, func(t *testing.T) {
+		var bus cqs.ConcurrentEventsBus
+
+		_, err := bus.Attach("", &EventHandlerMock{})
+		require.ErrorIs(err, cqs.ErrEmptyEventName)
+
+		_, err = bus.Attach(eventName, nil)
+		require.ErrorIs(err, cqs.ErrEmptyEventHandler)
+	})
+}
+
+func TestConcurrentEventsBusMatchers(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+
+	t.Run(`Given a concurrent events bus with handlers subscribed by name, pattern, predicate and to every event,
+	when events are dispatched,
+	then the matching handlers are called in subscription order`, func(t *testing.T) {
+		var (
+			bus   cqs.ConcurrentEventsBus
+			calls []string
+		)
+
+		handler := func(id string) cqs.EventHandler {
+			return cqs.EventHandlerFunc(func(_ context.Context, ev cqs.Event) error {
+				calls = append(calls, id+":"+string(ev.EventName()))
+
+				return nil
+			})
+		}
+
+		_, err := bus.AttachPattern("order.*", handler("pattern"))
+		require.NoError(err)
+		_, err = bus.Attach("order.placed", handler("name"))
+		require.NoError(err)
+		all, err := bus.AttachAll(handler("all"))
+		require.NoError(err)
+		_, err = bus.AttachFunc(func(ev cqs.Event) bool { return ev.EventAggregateRootID().IsEmpty() }, handler("func"))
+		require.NoError(err)
+		_, err = bus.Attach("order.placed", handler("name2"))
+		require.NoError(err)
+
+		require.NoError(bus.Dispatch(ctx, newBasicEvent("order.placed")))
+		require.NoError(bus.Dispatch(ctx, newBasicEvent("payment.charged")))
+
+		var ev cqs.BasicEvent
+		ev.Hydrate(vo.NewID(), "order.shipped", vo.DateTimeNow(), vo.ID{}, 1)
+		require.NoError(bus.Dispatch(ctx, ev))
+
+		require.Equal([]string{
+			"pattern:order.placed", "name:order.placed", "all:order.placed", "name2:order.placed",
+			"all:payment.charged",
+			"pattern:order.shipped", "all:order.shipped", "func:order.shipped",
+		}, calls)
+
+		all.Unsubscribe()
+		calls = nil
+
+		require.NoError(bus.Dispatch(ctx, newBasicEvent("payment.charged")))
+		require.Empty(calls)
+	})
+
+	t.Run(`Given a concurrent events bus,
+	when a handler is subscribed with an invalid pattern or an empty predicate,
+	then it returns an error`, func(t *testing.T) {
+		var bus cqs.ConcurrentEventsBus
+
+		_, err := bus.AttachPattern("order.[", &EventHandlerMock{})
+		require.ErrorIs(err, cqs.ErrInvalidEventPattern)
+
+		_, err = bus.AttachPattern("", &EventHandlerMock{})
+		require.ErrorIs(err, cqs.ErrInvalidEventPattern)
+
+		_, err = bus.AttachFunc(nil, &EventHandlerMock{})
+		require.ErrorIs(err, cqs.ErrEmptyEventPredicate)
+
+		_, err = bus.AttachAll(nil)
+		require.ErrorIs(err, cqs.ErrEmptyEventHandler)
+	})
+}
+
+func TestConcurrentEventsBusOrdering(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+
+	t.Run(`Given a concurrent events bus with handlers with priorities and dependencies,
+	when an event is dispatched,
+	then they run after their dependencies, then by priority, then in subscription order`, func(t *testing.T) {
+		var (
+			bus   cqs.ConcurrentEventsBus
+			calls []string
+		)
+
+		handler := func(id string) cqs.EventHandler {
+			return cqs.EventHandlerFunc(func(context.Context, cqs.Event) error {
+				calls = append(calls, id)
+
+				return nil
+			})
+		}
+
+		_, err := bus.Attach("foo", handler("notify"), cqs.SubscriptionNameOpt("notify"),
+			cqs.SubscriptionAfterOpt("cache", "audit"), cqs.SubscriptionPriorityOpt(100))
+		require.NoError(err)
+		_, err = bus.Attach("foo", handler("plain"))
+		require.NoError(err)
+		_, err = bus.AttachAll(handler("audit"), cqs.SubscriptionNameOpt("audit"), cqs.SubscriptionPriorityOpt(-1))
+		require.NoError(err)
+		_, err = bus.Attach("foo", handler("cache"), cqs.SubscriptionNameOpt("cache"), cqs.SubscriptionPriorityOpt(10))
+		require.NoError(err)
+		_, err = bus.Attach("foo", handler("metrics"), cqs.SubscriptionPriorityOpt(10))
+		require.NoError(err)
+
+		require.NoError(bus.Dispatch(ctx, newBasicEvent("foo")))
+		require.Equal([]string{"cache", "metrics", "plain", "audit", "notify"}, calls)
+
+		calls = nil
+
+		_, err = bus.Attach("bar", handler("notify"), cqs.SubscriptionNameOpt("notify"), cqs.SubscriptionAfterOpt("cache"))
+		require.NoError(err)
+		require.NoError(bus.Dispatch(ctx, newBasicEvent("bar")))
+		require.Equal([]string{"notify", "audit"}, calls)
+	})
+
+	t.Run(`Given a concurrent events bus with handlers that depend on others,
+	when a subscription closes a dependency cycle,
+	then it returns an error`, func(t *testing.T) {
+		var bus cqs.ConcurrentEventsBus
+
+		handler := &EventHandlerMock{}
+
+		_, err := bus.Attach("foo", handler, cqs.SubscriptionNameOpt("a"), cqs.SubscriptionAfterOpt("a"))
+		require.ErrorIs(err, cqs.ErrEventHandlerDependencyCycle)
+
+		_, err = bus.Attach("foo", handler, cqs.SubscriptionNameOpt("b"), cqs.SubscriptionAfterOpt("a"))
+		require.NoError(err)
+		sub, err := bus.Attach("bar", handler, cqs.SubscriptionNameOpt("c"), cqs.SubscriptionAfterOpt("b"))
+		require.NoError(err)
+
+		_, err = bus.AttachAll(handler, cqs.SubscriptionNameOpt("a"), cqs.SubscriptionAfterOpt("c"))
+		require.ErrorIs(err, cqs.ErrEventHandlerDependencyCycle)
+
+		sub.Unsubscribe()
+
+		_, err = bus.AttachAll(handler, cqs.SubscriptionNameOpt("a"), cqs.SubscriptionAfterOpt("c"))
+		require.NoError(err)
+	})
+}
+
+func TestConcurrentEventsBusFanOut(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+
+	t.Run(`Given a fan-out concurrent events bus with a concurrency limit,
+	when an event is dispatched,
+	then its handlers run concurrently up to the limit`, func(t *testing.T) {
+		const limit = 2
+
+		bus := cqs.NewConcurrentEventsBus(cqs.ConcurrentEventsBusFanOutOpt(limit))
+
+		var (
+			mu               sync.Mutex
+			running, maxSeen int
+			started          = make(chan struct{}, 4)
+			release          = make(chan struct{})
+		)
+
+		handler := cqs.EventHandlerFunc(func(context.Context, cqs.Event) error {
+			mu.Lock()
+			running++
+			if running > maxSeen {
+				maxSeen = running
+			}
+			mu.Unlock()
+
+			started <- struct{}{}
+			<-release
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+
+			return nil
+		})
+
+		for i := 0; i < 4; i++ {
+			require.NoError(bus.Subscribe("foo", handler))
+		}
+
+		done := make(chan error)
+
+		go func() { done <- bus.Dispatch(ctx, newBasicEvent("foo")) }()
+
+		for i := 0; i < limit; i++ {
+			<-started
+		}
+
+		select {
+		case <-started:
+			t.Fatal("more handlers than the limit are running")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		close(release)
+		require.NoError(<-done)
+		require.Equal(limit, maxSeen)
+	})
+
+	t.Run(`Given a fan-out concurrent events bus with a handler timeout,
+	when some handlers fail or time out,
+	then the errors identify the handlers in order and the dependencies run first`, func(t *testing.T) {
+		bus := cqs.NewConcurrentEventsBus(cqs.ConcurrentEventsBusFanOutOpt(0),
+			cqs.ConcurrentEventsBusHandlerTimeoutOpt(10*time.Millisecond))
+
+		cacheDone := make(chan struct{})
+
+		_, err := bus.Attach("foo", cqs.EventHandlerFunc(func(ctx context.Context, _ cqs.Event) error {
+			<-ctx.Done()
+
+			return ctx.Err()
+		}), cqs.SubscriptionNameOpt("slow"))
+		require.NoError(err)
+		_, err = bus.Attach("foo", cqs.EventHandlerFunc(func(context.Context, cqs.Event) error {
+			select {
+			case <-cacheDone:
+				return nil
+			default:
+				return errors.New("cache not invalidated")
+			}
+		}), cqs.SubscriptionNameOpt("notify"), cqs.SubscriptionAfterOpt("cache"))
+		require.NoError(err)
+		_, err = bus.Attach("foo", cqs.EventHandlerFunc(func(context.Context, cqs.Event) error {
+			time.Sleep(5 * time.Millisecond)
+			close(cacheDone)
+
+			return errors.New("cache failed")
+		}), cqs.SubscriptionNameOpt("cache"))
+		require.NoError(err)
+
+		err = bus.Dispatch(ctx, newBasicEvent("foo"))
+		require.ErrorContains(err, "handler slow: context deadline exceeded")
+		require.ErrorContains(err, "handler cache: cache failed")
+		require.NotContains(err.Error(), "cache not invalidated")
+		require.Less(strings.Index(err.Error(), "slow"), strings.Index(err.Error(), "handler cache"))
+	})
+
+	t.Run(`Given a named handler subscribed with another name,
+	when it fails,
+	then the error identifies it by the subscription name`, func(t *testing.T) {
+		bus := cqs.NewConcurrentEventsBus()
+		handler := namedEventHandler{
+			name: "projector",
+			EventHandler: cqs.EventHandlerFunc(func(context.Context, cqs.Event) error {
+				return errors.New("projection failed")
+			}),
+		}
+
+		_, err := bus.Attach("foo", handler, cqs.SubscriptionNameOpt("balance"))
+		require.NoError(err)
+		_, err = bus.AttachAll(handler)
+		require.NoError(err)
+
+		err = bus.Dispatch(ctx, newBasicEvent("foo"))
+
+		var busErr cqs.EventsBusError
+		require.ErrorAs(err, &busErr)
+		require.Equal("balance", busErr.Handler)
+		require.Equal(1, busErr.Attempt)
+		require.ErrorContains(err, "handler projector: projection failed")
+	})
+}
+
+func TestConcurrentEventsBusConcurrency(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+	eventName := cqs.EventName("foo")
+	eventID := vo.NewID()
+	ev := &EventMock{
+		EventNameFunc: func() cqs.EventName { return eventName },
+		EventIDFunc:   func() vo.ID { return eventID },
+	}
+
+	t.Run(`Given a concurrent events bus,
+	when handlers are subscribed, unsubscribed and events dispatched at the same time,
+	then there are no data races`, func(t *testing.T) {
+		var (
+			bus cqs.ConcurrentEventsBus
+			wg  sync.WaitGroup
+		)
+
+		const goroutines = 20
+
+		for i := 0; i < goroutines; i++ {
+			wg.Add(2)
+
+			go func() {
+				defer wg.Done()
+
+				sub, err := bus.Attach(eventName, &EventHandlerMock{
+					HandleFunc: func(context.Context, cqs.Event) error {
+						// Subscribing from a handler must not deadlock.
+						return bus.Subscribe("bar", &EventHandlerMock{})
+					},
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				sub.Unsubscribe()
+
+				all, err := bus.AttachAll(&EventHandlerMock{HandleFunc: func(context.Context, cqs.Event) error { return nil }})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				all.Unsubscribe()
+			}()
+
+			go func() {
+				defer wg.Done()
+
+				if err := bus.Dispatch(ctx, ev); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+
+		wg.Wait()
+		require.NoError(bus.Dispatch(ctx, ev))
+	})
+}
@@ -0,0 +1,53 @@
+package cqs
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type sqlTxKey struct{}
+
+// ContextWithSQLTx returns a copy of the context that carries the transaction.
+func ContextWithSQLTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return context.WithValue(ctx, sqlTxKey{}, tx)
+}
+
+// SQLTxFromContext returns the transaction carried by the context, if any.
+func SQLTxFromContext(ctx context.Context) (*sql.Tx, bool) {
+	tx, ok := ctx.Value(sqlTxKey{}).(*sql.Tx)
+
+	return tx, ok && tx != nil
+}
+
+var _ context.Context = detachedContext{}
+
+// detachedContext keeps the values of its parent but neither its deadline nor its cancellation,
+// so the work that outlives the caller still sees the request scoped values.
+type detachedContext struct {
+	parent context.Context
+}
+
+func withoutCancel(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+
+	return detachedContext{parent: ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key any) any {
+	return c.parent.Value(key)
+}
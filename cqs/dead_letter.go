@@ -0,0 +1,451 @@
+package cqs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+const (
+	deadLetterFilePerm    = 0o600
+	deadLetterMaxLineSize = 64 << 20
+)
+
+var ErrDeadLetterHandlerNotFound = errors.New("dead letter handler not found")
+
+// DeadLetter is an event whose handler kept failing.
+type DeadLetter struct {
+	ID          vo.ID
+	Event       Event
+	HandlerName string
+	// Errors is the error chain, from the outermost error to the root cause.
+	Errors   []string
+	Attempts int
+	At       vo.DateTime
+}
+
+// DeadLetterQueue stores the dead letters until they are replayed or discarded.
+type DeadLetterQueue interface {
+	Push(ctx context.Context, dl DeadLetter) error
+	List(ctx context.Context) ([]DeadLetter, error)
+	Remove(ctx context.Context, ids ...vo.ID) error
+}
+
+// DeadLetterEventHandlerOpt is the common type of functions that set options on the dead letter event handler.
+type DeadLetterEventHandlerOpt func(h *deadLetterEventHandler)
+
+// DeadLetterHandlerNameOpt sets the name that identifies the handler in its dead letters.
+// The default is the name of the handler, or its type if it isn't Named.
+func DeadLetterHandlerNameOpt(name string) DeadLetterEventHandlerOpt {
+	return func(h *deadLetterEventHandler) {
+		h.name = name
+	}
+}
+
+var (
+	_ EventHandler = deadLetterEventHandler{}
+	_ Named        = deadLetterEventHandler{}
+)
+
+type deadLetterEventHandler struct {
+	handler EventHandler
+	dlq     DeadLetterQueue
+	name    string
+}
+
+// NewDeadLetterEventHandler decorates the event handler to push the events it fails to handle to the queue.
+// Once the dead letter is stored the failure is not returned, so it's usually the outermost decorator,
+// wrapping the retries.
+func NewDeadLetterEventHandler(h EventHandler, dlq DeadLetterQueue, opts ...DeadLetterEventHandlerOpt) EventHandler {
+	dh := deadLetterEventHandler{
+		handler: h,
+		dlq:     dlq,
+		name:    handlerName(h),
+	}
+	for _, opt := range opts {
+		opt(&dh)
+	}
+
+	return dh
+}
+
+// Handle is the EventHandler interface implementation.
+func (h deadLetterEventHandler) Handle(ctx context.Context, ev Event) error {
+	err := h.handler.Handle(ctx, ev)
+	if err == nil {
+		return nil
+	}
+
+	attempts := 1
+
+	var retryErr RetryError
+	if errors.As(err, &retryErr) {
+		attempts = retryErr.Attempts
+	}
+
+	dl := DeadLetter{
+		ID:          vo.NewID(),
+		Event:       ev,
+		HandlerName: h.name,
+		Errors:      errorChain(err),
+		Attempts:    attempts,
+		At:          vo.DateTimeNow(),
+	}
+
+	if pushErr := h.dlq.Push(ctx, dl); pushErr != nil {
+		return fmt.Errorf("push dead letter: %s: %w", pushErr, err)
+	}
+
+	return nil
+}
+
+// HandlerName is the Named interface implementation.
+func (h deadLetterEventHandler) HandlerName() string {
+	return h.name
+}
+
+func errorChain(err error) []string {
+	var chain []string
+
+	for ; err != nil; err = errors.Unwrap(err) {
+		chain = append(chain, err.Error())
+	}
+
+	return chain
+}
+
+// ReplayDeadLetters delivers the dead letters accepted by the filter to the handler that failed, the one with
+// the same name among the given handlers, and removes from the queue the ones handled without errors.
+// A nil filter accepts all of them. The dead letter decorators of the handlers are skipped, so the dead letters that
+// fail again stay in the queue.
+func ReplayDeadLetters(ctx context.Context, dlq DeadLetterQueue, filter func(DeadLetter) bool, handlers ...EventHandler) error {
+	byName := make(map[string]EventHandler, len(handlers))
+
+	for _, h := range handlers {
+		if dh, ok := h.(deadLetterEventHandler); ok {
+			byName[dh.name] = dh.handler
+
+			continue
+		}
+
+		byName[handlerName(h)] = h
+	}
+
+	dls, err := dlq.List(ctx)
+	if err != nil {
+		return fmt.Errorf("list dead letters: %w", err)
+	}
+
+	multierror := NewMultiError()
+	replayed := make([]vo.ID, 0, len(dls))
+
+	for _, dl := range dls {
+		if filter != nil && !filter(dl) {
+			continue
+		}
+
+		h, ok := byName[dl.HandlerName]
+		if !ok {
+			multierror.Add(fmt.Errorf("replay dead letter %s: %w: %s", dl.ID, ErrDeadLetterHandlerNotFound, dl.HandlerName))
+
+			continue
+		}
+
+		if err := h.Handle(ctx, dl.Event); err != nil {
+			multierror.Add(fmt.Errorf("replay dead letter %s: %w", dl.ID, err))
+
+			continue
+		}
+
+		replayed = append(replayed, dl.ID)
+	}
+
+	if len(replayed) > 0 {
+		if err := dlq.Remove(ctx, replayed...); err != nil {
+			multierror.Add(fmt.Errorf("remove dead letters: %w", err))
+		}
+	}
+
+	return multierror.ErrResult()
+}
+
+var _ DeadLetterQueue = &InMemoryDeadLetterQueue{}
+
+// InMemoryDeadLetterQueue is a concurrent-safe DeadLetterQueue that keeps the dead letters in memory.
+type InMemoryDeadLetterQueue struct {
+	mu  sync.Mutex
+	dls []DeadLetter
+}
+
+// Push is the DeadLetterQueue interface implementation.
+func (q *InMemoryDeadLetterQueue) Push(_ context.Context, dl DeadLetter) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.dls = append(q.dls, dl)
+
+	return nil
+}
+
+// List is the DeadLetterQueue interface implementation.
+func (q *InMemoryDeadLetterQueue) List(context.Context) ([]DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	dls := make([]DeadLetter, len(q.dls))
+	copy(dls, q.dls)
+
+	return dls, nil
+}
+
+// Remove is the DeadLetterQueue interface implementation.
+func (q *InMemoryDeadLetterQueue) Remove(_ context.Context, ids ...vo.ID) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.dls = withoutDeadLetters(q.dls, ids)
+
+	return nil
+}
+
+func withoutDeadLetters(dls []DeadLetter, ids []vo.ID) []DeadLetter {
+	removed := make(map[vo.ID]struct{}, len(ids))
+	for _, id := range ids {
+		removed[id] = struct{}{}
+	}
+
+	kept := make([]DeadLetter, 0, len(dls))
+
+	for _, dl := range dls {
+		if _, ok := removed[dl.ID]; !ok {
+			kept = append(kept, dl)
+		}
+	}
+
+	return kept
+}
+
+var _ DeadLetterQueue = &FileDeadLetterQueue{}
+
+// FileDeadLetterQueue is a DeadLetterQueue that stores a dead letter per line, as JSON, in a file.
+// It's safe for concurrent use within a process, but the file must not be shared between processes.
+// The lines that can't be decoded are skipped by List and kept by Remove, and Corrupted reports them.
+type FileDeadLetterQueue struct {
+	mu    sync.Mutex
+	path  string
+	codec EventCodec
+}
+
+type deadLetterRecord struct {
+	ID          string          `json:"id"`
+	HandlerName string          `json:"handler_name"`
+	Errors      []string        `json:"errors"`
+	Attempts    int             `json:"attempts"`
+	At          vo.DateTime     `json:"at"`
+	Event       json.RawMessage `json:"event"`
+}
+
+// NewFileDeadLetterQueue is a constructor. The file is created on the first push.
+// The codec must decode the concrete event types, like an EventRegistry, so the replayed events are the original ones.
+func NewFileDeadLetterQueue(path string, codec EventCodec) (*FileDeadLetterQueue, error) {
+	if codec == nil {
+		return nil, ErrEmptyEventCodec
+	}
+
+	return &FileDeadLetterQueue{
+		path:  path,
+		codec: codec,
+	}, nil
+}
+
+// Push is the DeadLetterQueue interface implementation.
+func (q *FileDeadLetterQueue) Push(_ context.Context, dl DeadLetter) error {
+	line, err := q.encode(dl)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	f, err := os.OpenFile(q.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, deadLetterFilePerm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(line); err != nil {
+		_ = f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+// List is the DeadLetterQueue interface implementation.
+func (q *FileDeadLetterQueue) List(context.Context) ([]DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	lines, err := q.readLines()
+	if err != nil {
+		return nil, err
+	}
+
+	dls := make([]DeadLetter, 0, len(lines))
+
+	for _, line := range lines {
+		if dl, err := q.decode(line); err == nil {
+			dls = append(dls, dl)
+		}
+	}
+
+	return dls, nil
+}
+
+// Corrupted returns an error for each line that can't be decoded, with its line number.
+func (q *FileDeadLetterQueue) Corrupted(context.Context) ([]error, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	lines, err := q.readLines()
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+
+	for i, line := range lines {
+		if _, err := q.decode(line); err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", i+1, err))
+		}
+	}
+
+	return errs, nil
+}
+
+// Remove is the DeadLetterQueue interface implementation. It rewrites the file without the removed dead letters.
+func (q *FileDeadLetterQueue) Remove(_ context.Context, ids ...vo.ID) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	lines, err := q.readLines()
+	if err != nil || lines == nil {
+		// Without file there is nothing to remove.
+		return err
+	}
+
+	removed := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		removed[id.String()] = struct{}{}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+
+	for _, line := range lines {
+		var r deadLetterRecord
+		if json.Unmarshal(line, &r) == nil {
+			if _, ok := removed[r.ID]; ok {
+				continue
+			}
+		}
+
+		_, _ = w.Write(append(line, '\n'))
+	}
+
+	if err := w.Flush(); err != nil {
+		_ = tmp.Close()
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), q.path)
+}
+
+func (q *FileDeadLetterQueue) encode(dl DeadLetter) ([]byte, error) {
+	ev, err := q.codec.Marshal(dl.Event)
+	if err != nil {
+		return nil, fmt.Errorf("marshal event: %w", err)
+	}
+
+	line, err := json.Marshal(deadLetterRecord{
+		ID:          dl.ID.String(),
+		HandlerName: dl.HandlerName,
+		Errors:      dl.Errors,
+		Attempts:    dl.Attempts,
+		At:          dl.At,
+		Event:       ev,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal dead letter: %w", err)
+	}
+
+	return append(line, '\n'), nil
+}
+
+func (q *FileDeadLetterQueue) decode(line []byte) (DeadLetter, error) {
+	var r deadLetterRecord
+	if err := json.Unmarshal(line, &r); err != nil {
+		return DeadLetter{}, fmt.Errorf("unmarshal dead letter: %w", err)
+	}
+
+	id, err := vo.ParseID(r.ID)
+	if err != nil {
+		return DeadLetter{}, fmt.Errorf("parse dead letter id %s: %w", r.ID, err)
+	}
+
+	ev, err := q.codec.Unmarshal(r.Event)
+	if err != nil {
+		return DeadLetter{}, fmt.Errorf("unmarshal event of dead letter %s: %w", r.ID, err)
+	}
+
+	return DeadLetter{
+		ID:          id,
+		Event:       ev,
+		HandlerName: r.HandlerName,
+		Errors:      r.Errors,
+		Attempts:    r.Attempts,
+		At:          r.At,
+	}, nil
+}
+
+// readLines returns the lines of the file, or nil if it doesn't exist.
+func (q *FileDeadLetterQueue) readLines() ([][]byte, error) {
+	f, err := os.Open(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := [][]byte{}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, deadLetterMaxLineSize)
+
+	for scanner.Scan() {
+		lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+	}
+
+	return lines, scanner.Err()
+}
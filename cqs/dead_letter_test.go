@@ -0,0 +1,309 @@
+package cqs_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+func newBasicEvent(name cqs.EventName) cqs.BasicEvent {
+	var ev cqs.BasicEvent
+
+	ev.Hydrate(vo.NewID(), name, vo.DateTimeNow(), vo.NewID(), 1)
+
+	return ev
+}
+
+func TestDeadLetterEventHandler(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+	ev := newBasicEvent("foo")
+	handlerErr := errors.New("event handler error")
+
+	t.Run(`Given a dead letter event handler wrapping a retried handler that always fails,
+	when Handle is called,
+	then it pushes a dead letter with the attempts and the error chain and returns no error`, func(t *testing.T) {
+		var dlq cqs.InMemoryDeadLetterQueue
+
+		evHandlerMock := &EventHandlerMock{
+			HandleFunc: func(context.Context, cqs.Event) error { return handlerErr },
+		}
+		policy := cqs.NewRetryPolicy(cqs.RetrySleeperOpt(cqs.SleeperFunc(func(context.Context, time.Duration) error {
+			return nil
+		})))
+		h := cqs.NewDeadLetterEventHandler(cqs.NewRetryEventHandler(evHandlerMock, policy), &dlq, cqs.DeadLetterHandlerNameOpt("projection"))
+
+		require.NoError(h.Handle(ctx, ev))
+
+		dls, err := dlq.List(ctx)
+		require.NoError(err)
+		require.Len(dls, 1)
+		require.Equal(ev, dls[0].Event)
+		require.Equal("projection", dls[0].HandlerName)
+		require.Equal(3, dls[0].Attempts)
+		require.Equal([]string{"3 attempts: event handler error", "event handler error"}, dls[0].Errors)
+		require.False(dls[0].ID.IsEmpty())
+		require.False(dls[0].At.IsZero())
+	})
+
+	t.Run(`Given a dead letter event handler without name,
+	when the handler fails,
+	then the dead letter is identified by the handler type`, func(t *testing.T) {
+		var dlq cqs.InMemoryDeadLetterQueue
+
+		h := cqs.NewDeadLetterEventHandler(&EventHandlerMock{
+			HandleFunc: func(context.Context, cqs.Event) error { return handlerErr },
+		}, &dlq)
+
+		require.NoError(h.Handle(ctx, ev))
+
+		dls, err := dlq.List(ctx)
+		require.NoError(err)
+		require.Len(dls, 1)
+		require.Equal("*cqs_test.EventHandlerMock", dls[0].HandlerName)
+		require.Equal(1, dls[0].Attempts)
+	})
+
+	t.Run(`Given a dead letter event handler whose handler succeeds,
+	when Handle is called,
+	then nothing is pushed`, func(t *testing.T) {
+		var dlq cqs.InMemoryDeadLetterQueue
+
+		h := cqs.NewDeadLetterEventHandler(&EventHandlerMock{
+			HandleFunc: func(context.Context, cqs.Event) error { return nil },
+		}, &dlq)
+
+		require.NoError(h.Handle(ctx, ev))
+
+		dls, err := dlq.List(ctx)
+		require.NoError(err)
+		require.Empty(dls)
+	})
+
+	t.Run(`Given a dead letter event handler with a queue that fails,
+	when the handler fails,
+	then it returns the handler error`, func(t *testing.T) {
+		dlq, err := cqs.NewFileDeadLetterQueue(filepath.Join(t.TempDir(), "missing", "dlq.jsonl"), cqs.BasicEventCodec{})
+		require.NoError(err)
+
+		h := cqs.NewDeadLetterEventHandler(&EventHandlerMock{
+			HandleFunc: func(context.Context, cqs.Event) error { return handlerErr },
+		}, dlq)
+
+		require.ErrorIs(h.Handle(ctx, ev), handlerErr)
+	})
+}
+
+func TestFileDeadLetterQueue(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+
+	t.Run(`Given no codec,
+	when a file dead letter queue is created,
+	then it returns an ErrEmptyEventCodec`, func(t *testing.T) {
+		_, err := cqs.NewFileDeadLetterQueue(filepath.Join(t.TempDir(), "dlq.jsonl"), nil)
+		require.ErrorIs(err, cqs.ErrEmptyEventCodec)
+	})
+
+	t.Run(`Given a file dead letter queue,
+	when dead letters are pushed and removed,
+	then List returns the remaining ones`, func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dlq.jsonl")
+		dlq, err := cqs.NewFileDeadLetterQueue(path, cqs.BasicEventCodec{})
+		require.NoError(err)
+
+		dls, err := dlq.List(ctx)
+		require.NoError(err)
+		require.Empty(dls)
+
+		dl1 := cqs.DeadLetter{
+			ID:          vo.NewID(),
+			Event:       newBasicEvent("foo"),
+			HandlerName: "projection",
+			Errors:      []string{"boom"},
+			Attempts:    3,
+			At:          vo.DateTimeNow(),
+		}
+		dl2 := dl1
+		dl2.ID = vo.NewID()
+		dl2.Event = newBasicEvent("bar")
+
+		require.NoError(dlq.Push(ctx, dl1))
+		require.NoError(dlq.Push(ctx, dl2))
+
+		reopened, err := cqs.NewFileDeadLetterQueue(path, cqs.BasicEventCodec{})
+		require.NoError(err)
+
+		dls, err = reopened.List(ctx)
+		require.NoError(err)
+		require.Len(dls, 2)
+		require.Equal(dl1.ID, dls[0].ID)
+		require.Equal(dl1.Event, dls[0].Event)
+		require.Equal(dl1.Errors, dls[0].Errors)
+		require.Equal(dl1.Attempts, dls[0].Attempts)
+		require.True(dl1.At.Equal(dls[0].At))
+		require.Equal(dl2.Event, dls[1].Event)
+
+		require.NoError(dlq.Remove(ctx, dl1.ID))
+
+		dls, err = dlq.List(ctx)
+		require.NoError(err)
+		require.Len(dls, 1)
+		require.Equal(dl2.ID, dls[0].ID)
+	})
+
+	t.Run(`Given a file dead letter queue with a corrupt line,
+	when it's listed and a dead letter is removed,
+	then the corrupt line is skipped, reported and kept`, func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dlq.jsonl")
+		dlq, err := cqs.NewFileDeadLetterQueue(path, cqs.BasicEventCodec{})
+		require.NoError(err)
+
+		require.NoError(dlq.Remove(ctx, vo.NewID()))
+
+		_, err = os.Stat(path)
+		require.ErrorIs(err, os.ErrNotExist)
+
+		dl1 := cqs.DeadLetter{ID: vo.NewID(), Event: newBasicEvent("foo"), HandlerName: "projection"}
+		dl2 := cqs.DeadLetter{ID: vo.NewID(), Event: newBasicEvent("bar"), HandlerName: "projection"}
+
+		require.NoError(dlq.Push(ctx, dl1))
+
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+		require.NoError(err)
+		_, err = f.WriteString("{\"id\": \"trunc\n")
+		require.NoError(err)
+		require.NoError(f.Close())
+
+		require.NoError(dlq.Push(ctx, dl2))
+
+		dls, err := dlq.List(ctx)
+		require.NoError(err)
+		require.Len(dls, 2)
+
+		require.NoError(dlq.Remove(ctx, dl1.ID))
+
+		dls, err = dlq.List(ctx)
+		require.NoError(err)
+		require.Len(dls, 1)
+		require.Equal(dl2.ID, dls[0].ID)
+
+		corrupted, err := dlq.Corrupted(ctx)
+		require.NoError(err)
+		require.Len(corrupted, 1)
+		require.ErrorContains(corrupted[0], "line 1: unmarshal dead letter")
+	})
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+
+	t.Run(`Given a dead letter queue and the handlers,
+	when ReplayDeadLetters is called with a filter,
+	then each selected dead letter is delivered to its handler and only the successful ones removed`, func(t *testing.T) {
+		var dlq cqs.InMemoryDeadLetterQueue
+
+		okEvent := newBasicEvent("ok")
+		failingEvent := newBasicEvent("failing")
+		skippedEvent := newBasicEvent("skipped")
+		orphanEvent := newBasicEvent("orphan")
+
+		require.NoError(dlq.Push(ctx, cqs.DeadLetter{ID: vo.NewID(), Event: okEvent, HandlerName: "ok"}))
+		require.NoError(dlq.Push(ctx, cqs.DeadLetter{ID: vo.NewID(), Event: failingEvent, HandlerName: "failing"}))
+		require.NoError(dlq.Push(ctx, cqs.DeadLetter{ID: vo.NewID(), Event: skippedEvent, HandlerName: "ok"}))
+		require.NoError(dlq.Push(ctx, cqs.DeadLetter{ID: vo.NewID(), Event: orphanEvent, HandlerName: "unknown"}))
+
+		okHandler := &EventHandlerMock{
+			HandleFunc: func(context.Context, cqs.Event) error { return nil },
+		}
+		failingHandler := &EventHandlerMock{
+			HandleFunc: func(context.Context, cqs.Event) error { return errors.New("still failing") },
+		}
+
+		err := cqs.ReplayDeadLetters(ctx, &dlq, func(dl cqs.DeadLetter) bool {
+			return dl.Event.EventName() != "skipped"
+		}, namedEventHandler{name: "ok", EventHandler: okHandler}, namedEventHandler{name: "failing", EventHandler: failingHandler})
+		require.ErrorContains(err, "still failing")
+		require.ErrorIs(err, cqs.ErrDeadLetterHandlerNotFound)
+		require.Len(okHandler.HandleCalls(), 1)
+		require.Equal(okEvent, okHandler.HandleCalls()[0].Event)
+		require.Len(failingHandler.HandleCalls(), 1)
+
+		dls, err := dlq.List(ctx)
+		require.NoError(err)
+		require.Len(dls, 3)
+		require.Equal(failingEvent, dls[0].Event)
+		require.Equal(skippedEvent, dls[1].Event)
+		require.Equal(orphanEvent, dls[2].Event)
+	})
+
+	t.Run(`Given a file dead letter queue with an event registry,
+	when a concrete event is dead lettered and replayed,
+	then the handler receives the event with all its fields`, func(t *testing.T) {
+		dlq, err := cqs.NewFileDeadLetterQueue(filepath.Join(t.TempDir(), "dlq.jsonl"), newAccountEventRegistry(t))
+		require.NoError(err)
+
+		ev := &moneyDeposited{Amount: 10}
+		ev.Hydrate(vo.NewID(), moneyDepositedName, vo.DateTimeNow(), vo.NewID(), 2)
+
+		var (
+			fail    = true
+			handled []cqs.Event
+			audited int
+		)
+
+		projection := cqs.NewDeadLetterEventHandler(
+			cqs.EventHandlerFunc(func(_ context.Context, ev cqs.Event) error {
+				if fail {
+					return errors.New("projection failed")
+				}
+
+				handled = append(handled, ev)
+
+				return nil
+			}), dlq, cqs.DeadLetterHandlerNameOpt("projection"))
+
+		bus := &cqs.BasicEventsBus{}
+		require.NoError(bus.Subscribe(moneyDepositedName, projection))
+		require.NoError(bus.Subscribe(moneyDepositedName, cqs.EventHandlerFunc(func(context.Context, cqs.Event) error {
+			audited++
+			return nil
+		})))
+
+		require.NoError(bus.Dispatch(ctx, ev))
+
+		require.Error(cqs.ReplayDeadLetters(ctx, dlq, nil, projection))
+
+		dls, err := dlq.List(ctx)
+		require.NoError(err)
+		require.Len(dls, 1)
+
+		fail = false
+		require.NoError(cqs.ReplayDeadLetters(ctx, dlq, nil, projection))
+		require.Len(handled, 1)
+		require.Equal(1, audited)
+
+		replayed, ok := handled[0].(*moneyDeposited)
+		require.True(ok)
+		require.Equal(10, replayed.Amount)
+		require.Equal(ev.EventID(), replayed.EventID())
+		require.Equal(ev.EventAggregateRootID(), replayed.EventAggregateRootID())
+		require.Equal(ev.EventVersion(), replayed.EventVersion())
+
+		dls, err = dlq.List(ctx)
+		require.NoError(err)
+		require.Empty(dls)
+	})
+}
@@ -1,6 +1,9 @@
 package cqs
 
-import "strings"
+import (
+	"errors"
+	"strings"
+)
 
 var _ error = &MultiError{}
 
@@ -44,7 +47,34 @@ func (e MultiError) ErrResult() error {
 	return &e
 }
 
+// Errors returns a copy of the errors added, in order.
+func (e MultiError) Errors() []error {
+	return append([]error(nil), e.errors...)
+}
+
 // Add adds a new error.
 func (e *MultiError) Add(err error) {
 	e.errors = append(e.errors, err)
 }
+
+// Is reports whether any of the errors matches the target, so errors.Is looks into all of them.
+func (e MultiError) Is(target error) bool {
+	for _, err := range e.errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// As finds the first of the errors that matches the target, so errors.As looks into all of them.
+func (e MultiError) As(target any) bool {
+	for _, err := range e.errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -20,7 +20,7 @@ type EventVersion int
 // Payload is self-described.
 type Payload interface{}
 
-var _ Event = &BasicEvent{}
+var _ MetadataEvent = &BasicEvent{}
 
 // BasicEvent is the minimal domain event struct.
 type BasicEvent struct {
@@ -29,6 +29,8 @@ type BasicEvent struct {
 	At              vo.DateTime  `json:"at,omitempty"`
 	Version         EventVersion `json:"version"`
 	AggregateRootID vo.ID        `json:"aggregate_root_id,omitempty"`
+	// Meta is the optional metadata of the event.
+	Meta *EventMetadata `json:"meta,omitempty"`
 }
 
 func (b BasicEvent) EventID() vo.ID {
@@ -51,6 +53,26 @@ func (b BasicEvent) EventAggregateRootID() vo.ID {
 	return b.AggregateRootID
 }
 
+// EventMetadata returns the metadata of the event, or an empty one if it has none.
+func (b BasicEvent) EventMetadata() EventMetadata {
+	if b.Meta == nil {
+		return EventMetadata{}
+	}
+
+	return *b.Meta
+}
+
+// SetEventMetadata sets the metadata of the event. An empty metadata removes it.
+func (b *BasicEvent) SetEventMetadata(md EventMetadata) {
+	if md.IsEmpty() {
+		b.Meta = nil
+
+		return
+	}
+
+	b.Meta = &md
+}
+
 // Hydrate hydrates the instance.
 func (b *BasicEvent) Hydrate(id vo.ID, name EventName, at vo.DateTime, aggRootID vo.ID, version EventVersion) {
 	b.ID = id
@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
+
+	"github.com/lucianogarciaz/kit/vo"
 )
 
-const errMsgBus = "dispatch event: %w"
+const errMsgBus = "dispatch event %s: handler %s: %s"
 
 var (
 	ErrEmptyEventName          = errors.New("empty event name")
@@ -15,18 +18,77 @@ var (
 	ErrEmptyCommandHandler     = errors.New("empty command handler")
 )
 
-// EventsBusError is self-described.
+// EventsBusError is returned for each handler that fails to handle an event. The buses join them in a MultiError,
+// so use errors.As to get the first one, or EventsBusErrors to get all of them.
 type EventsBusError struct {
-	error
+	EventName EventName
+	EventID   vo.ID
+	// Handler is the name of the handler, or its type if it isn't Named.
+	Handler string
+	// Attempt is the number of times the handler was called, as reported by a RetryError, or 1.
+	Attempt int
+	Err     error
+}
+
+// Error implements the Error interface.
+func (e EventsBusError) Error() string {
+	return fmt.Sprintf(errMsgBus, e.EventName, e.Handler, e.Err)
 }
 
 // Unwrap returns the underlying error.
 func (e EventsBusError) Unwrap() error {
-	return errors.Unwrap(e.error)
+	return e.Err
+}
+
+// EventsBusErrors returns every EventsBusError in the error, looking into the MultiErrors it wraps.
+func EventsBusErrors(err error) []EventsBusError {
+	var multierror *MultiError
+	if errors.As(err, &multierror) {
+		var busErrs []EventsBusError
+		for _, err := range multierror.errors {
+			busErrs = append(busErrs, EventsBusErrors(err)...)
+		}
+
+		return busErrs
+	}
+
+	var busErr EventsBusError
+	if errors.As(err, &busErr) {
+		return []EventsBusError{busErr}
+	}
+
+	return nil
+}
+
+func newEventsBusError(ev Event, handler string, err error) error {
+	attempt := 1
+
+	var retryErr RetryError
+	if errors.As(err, &retryErr) {
+		attempt = retryErr.Attempts
+	}
+
+	return EventsBusError{
+		EventName: ev.EventName(),
+		EventID:   ev.EventID(),
+		Handler:   handler,
+		Attempt:   attempt,
+		Err:       err,
+	}
+}
+
+// Named is implemented by the handlers that have a name, which identifies them in the errors.
+type Named interface {
+	HandlerName() string
 }
 
-func newEventsBusError(err error) error {
-	return EventsBusError{fmt.Errorf(errMsgBus, err)}
+// handlerName returns the name of the handler, or its type if it isn't Named.
+func handlerName(h EventHandler) string {
+	if n, ok := h.(Named); ok {
+		return n.HandlerName()
+	}
+
+	return fmt.Sprintf("%T", h)
 }
 
 // EventHandler is self-explanatory.
@@ -34,6 +96,14 @@ type EventHandler interface {
 	Handle(ctx context.Context, event Event) error
 }
 
+// EventHandlerFunc is a function that implements EventHandler interface.
+type EventHandlerFunc func(ctx context.Context, event Event) error
+
+// Handle is the EventHandler interface implementation.
+func (f EventHandlerFunc) Handle(ctx context.Context, event Event) error {
+	return f(ctx, event)
+}
+
 // An EventsBus is the piece that relates events and its handlers.
 type EventsBus interface {
 	Subscribe(name EventName, handler EventHandler) error
@@ -82,7 +152,7 @@ func (bus BasicEventsBus) Dispatch(ctx context.Context, ev Event) error {
 
 	for _, h := range hs {
 		if err := h.Handle(ctx, ev); err != nil {
-			multierror.Add(newEventsBusError(err))
+			multierror.Add(newEventsBusError(ev, handlerName(h), err))
 		}
 	}
 
@@ -127,3 +197,117 @@ func NewEventCommandHandler(f EventToCommandFunc, ch CommandHandler[Command]) (E
 		eventToCommandFunc: f,
 	}, nil
 }
+
+// EventToCommandsFunc is a function to convert each event to the commands it triggers.
+type EventToCommandsFunc func(ev Event) ([]Command, error)
+
+// EventCommandsHandlerOpt is the common type of functions that set options on EventCommandsHandler construction.
+type EventCommandsHandlerOpt func(h *EventCommandsHandler)
+
+// EventCommandsHandlerConcurrentOpt makes the handler execute the commands concurrently.
+// By default, they are executed sequentially, in order.
+func EventCommandsHandlerConcurrentOpt() EventCommandsHandlerOpt {
+	return func(h *EventCommandsHandler) {
+		h.concurrent = true
+	}
+}
+
+// EventCommandsHandlerEventsBusOpt sets the events bus where the events returned by the commands are dispatched to.
+// It's not needed when the command handler is a CommandBus with an events bus attached, which already dispatches them.
+func EventCommandsHandlerEventsBusOpt(eventsBus EventsBus) EventCommandsHandlerOpt {
+	return func(h *EventCommandsHandler) {
+		h.eventsBus = eventsBus
+	}
+}
+
+var _ EventHandler = &EventCommandsHandler{}
+
+// EventCommandsHandler is a type for linking an event to the several commands it triggers.
+// Every command is executed even if some of them fail, and the failures are returned in a MultiError.
+type EventCommandsHandler struct {
+	commandHandler      CommandHandler[Command]
+	eventToCommandsFunc EventToCommandsFunc
+	concurrent          bool
+	eventsBus           EventsBus
+}
+
+// NewEventCommandsHandler is a constructor.
+func NewEventCommandsHandler(f EventToCommandsFunc, ch CommandHandler[Command], opts ...EventCommandsHandlerOpt) (EventCommandsHandler, error) {
+	if f == nil {
+		return EventCommandsHandler{}, ErrEmptyEventToCommandFunc
+	}
+
+	if ch == nil {
+		return EventCommandsHandler{}, ErrEmptyCommandHandler
+	}
+
+	h := EventCommandsHandler{
+		commandHandler:      ch,
+		eventToCommandsFunc: f,
+	}
+	for _, opt := range opts {
+		opt(&h)
+	}
+
+	return h, nil
+}
+
+// Handle calls the underlying commandHandler with each command. The events returned by each command are dispatched
+// to the events bus, if any: right after the command when they are executed sequentially, or in the order of the
+// commands once all of them are done when they are executed concurrently.
+func (h EventCommandsHandler) Handle(ctx context.Context, ev Event) error {
+	cmds, err := h.eventToCommandsFunc(ev)
+	if err != nil {
+		return err
+	}
+
+	multierror := NewMultiError()
+
+	if !h.concurrent {
+		for _, cmd := range cmds {
+			cmdCtx, mark := withDispatchMark(ctx, cmd.CommandName())
+			events, err := h.commandHandler.Handle(cmdCtx, cmd)
+			h.dispatch(ctx, cmd, events, mark, err, multierror)
+		}
+
+		return multierror.ErrResult()
+	}
+
+	events := make([][]Event, len(cmds))
+	marks := make([]*dispatchMark, len(cmds))
+	errs := make([]error, len(cmds))
+
+	var wg sync.WaitGroup
+
+	wg.Add(len(cmds))
+
+	for i := range cmds {
+		go func(i int) {
+			defer wg.Done()
+
+			var cmdCtx context.Context
+
+			cmdCtx, marks[i] = withDispatchMark(ctx, cmds[i].CommandName())
+			events[i], errs[i] = h.commandHandler.Handle(cmdCtx, cmds[i])
+		}(i)
+	}
+
+	wg.Wait()
+
+	for i, cmd := range cmds {
+		h.dispatch(ctx, cmd, events[i], marks[i], errs[i], multierror)
+	}
+
+	return multierror.ErrResult()
+}
+
+// dispatch adds the error of the command to the multierror, or dispatches its events unless they are replayed.
+func (h EventCommandsHandler) dispatch(ctx context.Context, cmd Command, events []Event, mark *dispatchMark, err error, multierror *MultiError) {
+	if err != nil {
+		multierror.Add(fmt.Errorf("command %s: %w", cmd.CommandName(), err))
+
+		return
+	}
+
+	mark.dispatch(ctx, h.eventsBus, events, multierror)
+}
@@ -3,14 +3,32 @@ package cqs_test
 import (
 	"context"
 	"errors"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
 	"github.com/lucianogarciaz/kit/cqs"
+	"github.com/lucianogarciaz/kit/vo"
 )
 
+var eventsBuses = map[string]func() cqs.EventsBus{
+	"BasicEventsBus":      func() cqs.EventsBus { return &cqs.BasicEventsBus{} },
+	"ConcurrentEventsBus": func() cqs.EventsBus { return &cqs.ConcurrentEventsBus{} },
+}
+
 func TestEventBusSubscribe(t *testing.T) {
+	for busName, newBus := range eventsBuses {
+		t.Run(busName, func(t *testing.T) {
+			testEventBusSubscribe(t, newBus)
+		})
+	}
+}
+
+func testEventBusSubscribe(t *testing.T, newBus func() cqs.EventsBus) {
+	t.Helper()
+
 	require := require.New(t)
 
 	eventName := cqs.EventName("foo")
@@ -18,7 +36,7 @@ func TestEventBusSubscribe(t *testing.T) {
 	t.Run(`Given an event bus,
 	when Subscribe is called with an empty event name,
 	then it returns an ErrEmptyEventName`, func(t *testing.T) {
-		bus := cqs.BasicEventsBus{}
+		bus := newBus()
 
 		err := bus.Subscribe("", nil)
 		require.ErrorIs(err, cqs.ErrEmptyEventName)
@@ -27,7 +45,7 @@ func TestEventBusSubscribe(t *testing.T) {
 	t.Run(`Given an event bus,
 	when Subscribe is called with an empty event handler,
 	then it returns an ErrEmptyEventHandler`, func(t *testing.T) {
-		bus := cqs.BasicEventsBus{}
+		bus := newBus()
 
 		err := bus.Subscribe(eventName, nil)
 		require.ErrorIs(err, cqs.ErrEmptyEventHandler)
@@ -36,7 +54,7 @@ func TestEventBusSubscribe(t *testing.T) {
 	t.Run(`Given an event bus and an event handler,
 	when Subscribe is called,
 	then it subscribed correctly`, func(t *testing.T) {
-		bus := cqs.BasicEventsBus{}
+		bus := newBus()
 		evHandlerMock1 := &EventHandlerMock{
 			HandleFunc: nil,
 		}
@@ -48,7 +66,7 @@ func TestEventBusSubscribe(t *testing.T) {
 	t.Run(`Given an event bus and an event handler different from the subscribed,
 	when Subscribe is called with the same event name,
 	then it subscribed correctly`, func(t *testing.T) {
-		bus := cqs.BasicEventsBus{}
+		bus := newBus()
 		evHandlerMock1 := &EventHandlerMock{
 			HandleFunc: nil,
 		}
@@ -66,18 +84,39 @@ func TestEventBusSubscribe(t *testing.T) {
 }
 
 func TestEventBusDispatch(t *testing.T) {
+	for busName, newBus := range eventsBuses {
+		t.Run(busName, func(t *testing.T) {
+			testEventBusDispatch(t, newBus)
+		})
+	}
+}
+
+type namedEventHandler struct {
+	cqs.EventHandler
+	name string
+}
+
+func (h namedEventHandler) HandlerName() string {
+	return h.name
+}
+
+func testEventBusDispatch(t *testing.T, newBus func() cqs.EventsBus) {
+	t.Helper()
+
 	require := require.New(t)
 
 	ctx := context.Background()
 	eventName := cqs.EventName("foo")
+	eventID := vo.NewID()
 
 	t.Run(`Given an event bus,
 	when Dispatch is called without corresponding handler,
 	then it does nothing`, func(t *testing.T) {
-		bus := cqs.BasicEventsBus{}
+		bus := newBus()
 
 		ev := &EventMock{
 			EventNameFunc: func() cqs.EventName { return eventName },
+			EventIDFunc:   func() vo.ID { return eventID },
 		}
 
 		err := bus.Dispatch(ctx, ev)
@@ -88,7 +127,7 @@ func TestEventBusDispatch(t *testing.T) {
 	when Dispatch is called,
 	then it returns error`, func(t *testing.T) {
 		expectedError := errors.New("event handler error")
-		bus := cqs.BasicEventsBus{}
+		bus := newBus()
 		evHandlerMock1 := &EventHandlerMock{
 			HandleFunc: func(context.Context, cqs.Event) error {
 				return expectedError
@@ -100,16 +139,68 @@ func TestEventBusDispatch(t *testing.T) {
 
 		ev := &EventMock{
 			EventNameFunc: func() cqs.EventName { return eventName },
+			EventIDFunc:   func() vo.ID { return eventID },
 		}
 
 		err = bus.Dispatch(ctx, ev)
 		require.ErrorContains(err, expectedError.Error())
 	})
 
+	t.Run(`Given an event bus and a named handler that fails after its retries,
+	when Dispatch is called,
+	then the error identifies the event, the handler and the attempts`, func(t *testing.T) {
+		expectedError := errors.New("event handler error")
+		bus := newBus()
+		policy := cqs.NewRetryPolicy(
+			cqs.RetryMaxAttemptsOpt(3),
+			cqs.RetrySleeperOpt(cqs.SleeperFunc(func(context.Context, time.Duration) error { return nil })),
+		)
+		handler := cqs.NewRetryEventHandler(namedEventHandler{
+			name: "projector",
+			EventHandler: cqs.EventHandlerFunc(func(context.Context, cqs.Event) error {
+				return expectedError
+			}),
+		}, policy)
+
+		require.NoError(bus.Subscribe(eventName, handler))
+		require.NoError(bus.Subscribe(eventName, &EventHandlerMock{
+			HandleFunc: func(context.Context, cqs.Event) error {
+				return expectedError
+			},
+		}))
+
+		ev := &EventMock{
+			EventNameFunc: func() cqs.EventName { return eventName },
+			EventIDFunc:   func() vo.ID { return eventID },
+		}
+
+		err := bus.Dispatch(ctx, ev)
+		require.ErrorIs(err, expectedError)
+
+		var busErr cqs.EventsBusError
+		require.ErrorAs(err, &busErr)
+		require.Equal(eventName, busErr.EventName)
+		require.Equal(eventID, busErr.EventID)
+		require.Equal("projector", busErr.Handler)
+		require.Equal(3, busErr.Attempt)
+		require.ErrorContains(err, "dispatch event foo: handler projector: 3 attempts: event handler error")
+		require.ErrorContains(err, "dispatch event foo: handler *cqs_test.EventHandlerMock: event handler error")
+
+		var multierror *cqs.MultiError
+		require.ErrorAs(err, &multierror)
+		require.Len(multierror.Errors(), 2)
+
+		busErrs := cqs.EventsBusErrors(err)
+		require.Len(busErrs, 2)
+		require.Equal("projector", busErrs[0].Handler)
+		require.Equal("*cqs_test.EventHandlerMock", busErrs[1].Handler)
+		require.Equal(1, busErrs[1].Attempt)
+	})
+
 	t.Run(`Given an event bus and an event handler,
 	when Dispatch is called
 	then it returns no error and the event handlers are called`, func(t *testing.T) {
-		bus := cqs.BasicEventsBus{}
+		bus := newBus()
 		evHandlerMock1 := &EventHandlerMock{
 			HandleFunc: func(context.Context, cqs.Event) error {
 				return nil
@@ -130,6 +221,7 @@ func TestEventBusDispatch(t *testing.T) {
 
 		ev := &EventMock{
 			EventNameFunc: func() cqs.EventName { return eventName },
+			EventIDFunc:   func() vo.ID { return eventID },
 		}
 
 		err = bus.Dispatch(ctx, ev)
@@ -219,3 +311,113 @@ func TestEventCommandHandlerHandle(t *testing.T) {
 		require.Equal(cmdHandlerMock.HandleCalls()[0].Cmd, cmdMock)
 	})
 }
+
+func TestNewEventCommandsHandler(t *testing.T) {
+	require := require.New(t)
+
+	t.Run(`Given an event commands handler with an empty eventToCommandsFunc or command handler,
+	when it's created,
+	then it returns error`, func(t *testing.T) {
+		_, err := cqs.NewEventCommandsHandler(nil, &CommandHandlerMock[cqs.Command]{})
+		require.ErrorIs(err, cqs.ErrEmptyEventToCommandFunc)
+
+		_, err = cqs.NewEventCommandsHandler(func(cqs.Event) ([]cqs.Command, error) { return nil, nil }, nil)
+		require.ErrorIs(err, cqs.ErrEmptyCommandHandler)
+	})
+}
+
+func TestEventCommandsHandlerHandle(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+	errCommand := errors.New("command failed")
+
+	newCommand := func(name string) cqs.Command {
+		return &CommandMock{CommandNameFunc: func() string { return name }}
+	}
+
+	t.Run(`Given an EventCommandsHandler with an eventToCommandsFunc that returns error,
+	when it's called,
+	then it returns the error`, func(t *testing.T) {
+		expectedError := errors.New("eventToCommandsError")
+		cmdHandlerMock := &CommandHandlerMock[cqs.Command]{}
+		h, err := cqs.NewEventCommandsHandler(func(cqs.Event) ([]cqs.Command, error) { return nil, expectedError }, cmdHandlerMock)
+		require.NoError(err)
+
+		require.ErrorIs(h.Handle(ctx, newBasicEvent("foo")), expectedError)
+		require.Empty(cmdHandlerMock.HandleCalls())
+	})
+
+	t.Run(`Given a sequential EventCommandsHandler with an events bus,
+	when some commands fail,
+	then every command is executed in order, the failures are aggregated and the events are dispatched`, func(t *testing.T) {
+		cmds := []cqs.Command{newCommand("foo"), newCommand("bar"), newCommand("baz")}
+		produced := newBasicEvent("produced")
+		cmdHandlerMock := &CommandHandlerMock[cqs.Command]{
+			HandleFunc: func(_ context.Context, cmd cqs.Command) ([]cqs.Event, error) {
+				if cmd.CommandName() == "bar" {
+					return nil, errCommand
+				}
+
+				return []cqs.Event{produced}, nil
+			},
+		}
+		evHandlerMock := &EventHandlerMock{HandleFunc: func(context.Context, cqs.Event) error { return nil }}
+		bus := &cqs.BasicEventsBus{}
+		require.NoError(bus.Subscribe("produced", evHandlerMock))
+
+		h, err := cqs.NewEventCommandsHandler(func(cqs.Event) ([]cqs.Command, error) { return cmds, nil }, cmdHandlerMock,
+			cqs.EventCommandsHandlerEventsBusOpt(bus))
+		require.NoError(err)
+
+		err = h.Handle(ctx, newBasicEvent("foo"))
+		require.ErrorContains(err, "command bar: command failed")
+
+		calls := cmdHandlerMock.HandleCalls()
+		require.Len(calls, 3)
+
+		for i, call := range calls {
+			require.Equal(cmds[i], call.Cmd)
+		}
+
+		require.Len(evHandlerMock.HandleCalls(), 2)
+	})
+
+	t.Run(`Given a concurrent EventCommandsHandler,
+	when it's called,
+	then the commands are executed concurrently`, func(t *testing.T) {
+		cmds := []cqs.Command{newCommand("foo"), newCommand("bar"), newCommand("baz")}
+
+		var started sync.WaitGroup
+
+		started.Add(len(cmds))
+
+		cmdHandlerMock := &CommandHandlerMock[cqs.Command]{
+			HandleFunc: func(_ context.Context, cmd cqs.Command) ([]cqs.Event, error) {
+				started.Done()
+				started.Wait()
+
+				if cmd.CommandName() != "bar" {
+					return nil, errCommand
+				}
+
+				return nil, nil
+			},
+		}
+
+		h, err := cqs.NewEventCommandsHandler(func(cqs.Event) ([]cqs.Command, error) { return cmds, nil }, cmdHandlerMock,
+			cqs.EventCommandsHandlerConcurrentOpt())
+		require.NoError(err)
+
+		done := make(chan error)
+
+		go func() { done <- h.Handle(ctx, newBasicEvent("foo")) }()
+
+		select {
+		case err := <-done:
+			require.EqualError(err, "multi error: command foo: command failed; command baz: command failed")
+		case <-time.After(time.Second):
+			t.Fatal("the commands weren't executed concurrently")
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package cqs
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var ErrEmptyEventCodec = errors.New("empty event codec")
+
+// EventCodec encodes and decodes events to persist or transport them.
+type EventCodec interface {
+	Marshal(ev Event) ([]byte, error)
+	Unmarshal(data []byte) (Event, error)
+}
+
+var _ EventCodec = BasicEventCodec{}
+
+// BasicEventCodec encodes the events as JSON and decodes them as BasicEvent, so the fields of the
+// concrete event types are lost when decoding. Use an EventRegistry to keep them.
+type BasicEventCodec struct{}
+
+// Marshal is the EventCodec interface implementation.
+func (BasicEventCodec) Marshal(ev Event) ([]byte, error) {
+	return json.Marshal(ev)
+}
+
+// Unmarshal is the EventCodec interface implementation.
+func (BasicEventCodec) Unmarshal(data []byte) (Event, error) {
+	var ev BasicEvent
+
+	if err := json.Unmarshal(data, &ev); err != nil {
+		return nil, err
+	}
+
+	return ev, nil
+}
@@ -0,0 +1,150 @@
+package cqs
+
+import (
+	"context"
+
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+// EventMetadata traces where an event comes from. Every field is optional.
+type EventMetadata struct {
+	// CorrelationID identifies the whole flow of commands and events the event belongs to.
+	CorrelationID vo.ID `json:"correlation_id"`
+	// CausationID identifies the message that caused the event.
+	CausationID vo.ID             `json:"causation_id"`
+	UserID      string            `json:"user_id,omitempty"`
+	TenantID    string            `json:"tenant_id,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+// IsEmpty reports whether no field is set.
+func (m EventMetadata) IsEmpty() bool {
+	return m.CorrelationID.IsEmpty() && m.CausationID.IsEmpty() && m.UserID == "" && m.TenantID == "" &&
+		len(m.Headers) == 0
+}
+
+// WithHeader returns a copy of the metadata with the header set.
+func (m EventMetadata) WithHeader(key, value string) EventMetadata {
+	headers := make(map[string]string, len(m.Headers)+1)
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+
+	headers[key] = value
+	m.Headers = headers
+
+	return m
+}
+
+// merge returns a copy of the metadata with the fields set in other, which take precedence.
+func (m EventMetadata) merge(other EventMetadata) EventMetadata {
+	if !other.CorrelationID.IsEmpty() {
+		m.CorrelationID = other.CorrelationID
+	}
+
+	if !other.CausationID.IsEmpty() {
+		m.CausationID = other.CausationID
+	}
+
+	if other.UserID != "" {
+		m.UserID = other.UserID
+	}
+
+	if other.TenantID != "" {
+		m.TenantID = other.TenantID
+	}
+
+	for k, v := range other.Headers {
+		m = m.WithHeader(k, v)
+	}
+
+	return m
+}
+
+// MetadataEvent is an event that carries metadata. The events that embed BasicEvent implement it through a pointer.
+type MetadataEvent interface {
+	Event
+	EventMetadata() EventMetadata
+	SetEventMetadata(md EventMetadata)
+}
+
+// EventMetadataOf returns the metadata of the event, or an empty one if it carries none.
+func EventMetadataOf(ev Event) EventMetadata {
+	if m, ok := ev.(interface{ EventMetadata() EventMetadata }); ok {
+		return m.EventMetadata()
+	}
+
+	return EventMetadata{}
+}
+
+type eventMetadataKey struct{}
+
+// ContextWithEventMetadata returns a copy of the context that carries the metadata.
+func ContextWithEventMetadata(ctx context.Context, md EventMetadata) context.Context {
+	return context.WithValue(ctx, eventMetadataKey{}, md)
+}
+
+// EventMetadataFromContext returns the metadata carried by the context, if any.
+func EventMetadataFromContext(ctx context.Context) (EventMetadata, bool) {
+	md, ok := ctx.Value(eventMetadataKey{}).(EventMetadata)
+
+	return md, ok
+}
+
+// EventMetadataCommandHandlerMiddleware sets the metadata carried by the context on the events returned by the
+// handler that implement MetadataEvent. When the context has no correlation ID a new one is started, so every event
+// of a flow shares it. The metadata already set on the events takes precedence.
+func EventMetadataCommandHandlerMiddleware[C Command]() CommandHandlerMiddleware[C] {
+	return func(h CommandHandler[C]) CommandHandler[C] {
+		return CommandHandlerFunc[C](func(ctx context.Context, cmd C) ([]Event, error) {
+			md, _ := EventMetadataFromContext(ctx)
+			if md.CorrelationID.IsEmpty() {
+				md.CorrelationID = vo.NewID()
+				ctx = ContextWithEventMetadata(ctx, md)
+			}
+
+			events, err := h.Handle(ctx, cmd)
+
+			for _, ev := range events {
+				if mev, ok := ev.(MetadataEvent); ok {
+					mev.SetEventMetadata(md.merge(mev.EventMetadata()))
+				}
+			}
+
+			return events, err
+		})
+	}
+}
+
+var (
+	_ EventHandler = &causationEventHandler{}
+	_ Named        = &causationEventHandler{}
+)
+
+type causationEventHandler struct {
+	handler EventHandler
+}
+
+// NewCausationEventHandler returns an EventHandler that calls the handler with the metadata of the event in the
+// context, caused by the event. So the commands the handler dispatches, through EventMetadataCommandHandlerMiddleware,
+// produce events with the same correlation ID and the handled event as their cause.
+func NewCausationEventHandler(h EventHandler) EventHandler {
+	return causationEventHandler{handler: h}
+}
+
+// Handle is the EventHandler interface implementation.
+func (h causationEventHandler) Handle(ctx context.Context, ev Event) error {
+	md := EventMetadataOf(ev)
+	if md.CorrelationID.IsEmpty() {
+		md.CorrelationID = ev.EventID()
+	}
+
+	md.CausationID = ev.EventID()
+
+	return h.handler.Handle(ContextWithEventMetadata(ctx, md), ev)
+}
+
+// HandlerName is the Named interface implementation.
+func (h causationEventHandler) HandlerName() string {
+	return handlerName(h.handler)
+}
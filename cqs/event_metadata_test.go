@@ -0,0 +1,133 @@
+package cqs_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+func TestEventMetadataCommandHandlerMiddleware(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+
+	depositHandler := cqs.CommandHandlerFunc[depositCommand](func(_ context.Context, cmd depositCommand) ([]cqs.Event, error) {
+		acc := newAccount(cmd.AccountID)
+		if err := acc.Deposit(cmd.Amount); err != nil {
+			return nil, err
+		}
+
+		return acc.PullEvents(), nil
+	})
+	h := cqs.EventMetadataCommandHandlerMiddleware[depositCommand]()(depositHandler)
+
+	t.Run(`Given a context without metadata,
+	when a command is handled,
+	then its events start a new correlation`, func(t *testing.T) {
+		events, err := h.Handle(ctx, depositCommand{AccountID: vo.NewID(), Amount: 1})
+		require.NoError(err)
+		require.Len(events, 1)
+
+		md := cqs.EventMetadataOf(events[0])
+		require.False(md.CorrelationID.IsEmpty())
+		require.True(md.CausationID.IsEmpty())
+	})
+
+	t.Run(`Given a context with metadata,
+	when a command is handled,
+	then its events carry the metadata`, func(t *testing.T) {
+		md := cqs.EventMetadata{
+			CorrelationID: vo.NewID(),
+			CausationID:   vo.NewID(),
+			UserID:        "john",
+			TenantID:      "acme",
+		}.WithHeader("source", "api")
+
+		events, err := h.Handle(cqs.ContextWithEventMetadata(ctx, md), depositCommand{AccountID: vo.NewID(), Amount: 1})
+		require.NoError(err)
+		require.Len(events, 1)
+		require.Equal(md, cqs.EventMetadataOf(events[0]))
+	})
+
+	t.Run(`Given an event handler that dispatches a command,
+	when it's wrapped by a causation event handler,
+	then the events of the command are caused by the handled event and share its correlation`, func(t *testing.T) {
+		bus := cqs.NewCommandBus(cqs.CommandBusMiddlewareOpt(cqs.EventMetadataCommandHandlerMiddleware[cqs.Command]()))
+		require.NoError(cqs.RegisterCommandHandler[depositCommand](bus, depositCommandName, depositHandler))
+
+		var produced []cqs.Event
+
+		h := cqs.NewCausationEventHandler(cqs.EventHandlerFunc(func(ctx context.Context, ev cqs.Event) error {
+			events, err := bus.Dispatch(ctx, depositCommand{AccountID: ev.EventAggregateRootID(), Amount: 1})
+			produced = events
+
+			return err
+		}))
+
+		cause := &accountOpened{}
+		cause.Hydrate(vo.NewID(), accountOpenedName, vo.DateTimeNow(), vo.NewID(), 1)
+		cause.SetEventMetadata(cqs.EventMetadata{CorrelationID: vo.NewID(), UserID: "john"})
+
+		require.NoError(h.Handle(ctx, cause))
+		require.Len(produced, 1)
+
+		md := cqs.EventMetadataOf(produced[0])
+		require.Equal(cause.EventMetadata().CorrelationID, md.CorrelationID)
+		require.Equal(cause.EventID(), md.CausationID)
+		require.Equal("john", md.UserID)
+	})
+}
+
+func TestEventMetadataSerialization(t *testing.T) {
+	require := require.New(t)
+
+	md := cqs.EventMetadata{CorrelationID: vo.NewID(), CausationID: vo.NewID(), TenantID: "acme"}.
+		WithHeader("source", "api")
+
+	t.Run(`Given an event with metadata,
+	when it's marshaled and unmarshaled by an event registry,
+	then the metadata is preserved`, func(t *testing.T) {
+		r := newAccountEventRegistry(t)
+
+		ev := &moneyDeposited{Amount: 1}
+		ev.Hydrate(vo.NewID(), moneyDepositedName, vo.DateTimeNow(), vo.NewID(), 1)
+		ev.SetEventMetadata(md)
+
+		env, err := r.Envelope(ev)
+		require.NoError(err)
+		require.Equal(&md, env.Metadata)
+
+		data, err := r.Marshal(ev)
+		require.NoError(err)
+
+		decoded, err := r.Unmarshal(data)
+		require.NoError(err)
+		require.Equal(md, cqs.EventMetadataOf(decoded))
+	})
+
+	t.Run(`Given an event with metadata,
+	when it's marshaled and unmarshaled by the basic event codec,
+	then the metadata is preserved`, func(t *testing.T) {
+		ev := newBasicEvent("foo")
+		ev.SetEventMetadata(md)
+
+		data, err := cqs.BasicEventCodec{}.Marshal(ev)
+		require.NoError(err)
+
+		decoded, err := cqs.BasicEventCodec{}.Unmarshal(data)
+		require.NoError(err)
+		require.Equal(md, cqs.EventMetadataOf(decoded))
+	})
+
+	t.Run(`Given an event without metadata,
+	when it's marshaled,
+	then no metadata is written`, func(t *testing.T) {
+		data, err := cqs.BasicEventCodec{}.Marshal(newBasicEvent("foo"))
+		require.NoError(err)
+		require.NotContains(string(data), "meta")
+	})
+}
@@ -0,0 +1,130 @@
+package cqs
+
+import (
+	"context"
+	"sync"
+)
+
+type queuedEvent struct {
+	ctx context.Context
+	ev  Event
+}
+
+// eventQueues are the queues of the asynchronous buses, with their back-pressure policy and their shutdown.
+// The lock isn't held while a sender waits for room, so the queues aren't closed until every sender has finished.
+type eventQueues struct {
+	queues []chan queuedEvent
+	policy BackPressurePolicy
+	sink   ErrorSink
+
+	closing   chan struct{}
+	mu        sync.RWMutex
+	closed    bool
+	closeOnce sync.Once
+	senders   sync.WaitGroup
+	consumers sync.WaitGroup
+}
+
+func newEventQueues(count, size int, policy BackPressurePolicy, sink ErrorSink) *eventQueues {
+	q := &eventQueues{
+		queues:  make([]chan queuedEvent, count),
+		policy:  policy,
+		sink:    sink,
+		closing: make(chan struct{}),
+	}
+	for i := range q.queues {
+		q.queues[i] = make(chan queuedEvent, size)
+	}
+
+	return q
+}
+
+// consume starts a goroutine that calls handle with the events of the ith queue until it's closed.
+func (q *eventQueues) consume(i int, handle func(qe queuedEvent)) {
+	q.consumers.Add(1)
+
+	go func() {
+		defer q.consumers.Done()
+
+		for qe := range q.queues[i] {
+			handle(qe)
+		}
+	}()
+}
+
+// send enqueues the event in the ith queue applying the back-pressure policy when it's full.
+// The event keeps the values of ctx but not its cancellation.
+// When the queues are shut down while it waits for room, it returns an ErrEventsBusClosed.
+func (q *eventQueues) send(ctx context.Context, i int, ev Event) error {
+	q.mu.RLock()
+	if q.closed {
+		q.mu.RUnlock()
+
+		return ErrEventsBusClosed
+	}
+
+	q.senders.Add(1)
+	q.mu.RUnlock()
+
+	defer q.senders.Done()
+
+	qe := queuedEvent{ctx: withoutCancel(ctx), ev: ev}
+	queue := q.queues[i]
+
+	switch q.policy {
+	case BackPressureDropNewest:
+		select {
+		case queue <- qe:
+		default:
+			q.sink.HandleError(qe.ctx, qe.ev, ErrEventDropped)
+		}
+
+		return nil
+	case BackPressureError:
+		select {
+		case queue <- qe:
+			return nil
+		default:
+			return ErrEventsBusFull
+		}
+	default:
+		select {
+		case queue <- qe:
+			return nil
+		case <-q.closing:
+			return ErrEventsBusClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// shutdown stops accepting events and waits until the queued ones are consumed or the context is done.
+func (q *eventQueues) shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+
+	go func() {
+		q.closeOnce.Do(func() {
+			q.mu.Lock()
+			q.closed = true
+			close(q.closing)
+			q.mu.Unlock()
+
+			q.senders.Wait()
+
+			for _, queue := range q.queues {
+				close(queue)
+			}
+		})
+
+		q.consumers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
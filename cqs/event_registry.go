@@ -0,0 +1,269 @@
+package cqs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+const defaultSchemaVersion SchemaVersion = 1
+
+var (
+	ErrEmptyEvent                 = errors.New("empty event")
+	ErrEmptyEventFactory          = errors.New("empty event factory")
+	ErrInvalidSchemaVersion       = errors.New("invalid schema version")
+	ErrEventTypeAlreadyRegistered = errors.New("event type already registered")
+	ErrEventTypeNotRegistered     = errors.New("event type not registered")
+)
+
+// SchemaVersion is the revision of the payload of an event type.
+// It's not the EventVersion, which is the position of the event in its aggregate stream.
+type SchemaVersion int
+
+// SchemaVersioned is implemented by the events whose payload has several revisions.
+// The events that don't implement it have the schema version 1.
+type SchemaVersioned interface {
+	EventSchemaVersion() SchemaVersion
+}
+
+// EventFactory returns a new instance of an event type to decode into, so it must be a pointer.
+type EventFactory func() Event
+
+// EventEnvelope is the JSON representation of an event built by EventRegistry.
+// The payload is the JSON encoding of the concrete event.
+type EventEnvelope struct {
+	ID              string          `json:"id"`
+	Name            EventName       `json:"name"`
+	Version         EventVersion    `json:"version"`
+	SchemaVersion   SchemaVersion   `json:"schema_version"`
+	AggregateRootID string          `json:"aggregate_root_id,omitempty"`
+	At              vo.DateTime     `json:"at"`
+	Payload         json.RawMessage `json:"payload"`
+	Metadata        *EventMetadata  `json:"metadata,omitempty"`
+}
+
+type eventType struct {
+	name   EventName
+	schema SchemaVersion
+}
+
+// EventRegistryOpt is the common type of functions that set options on EventRegistry construction.
+type EventRegistryOpt func(r *EventRegistry)
+
+// EventRegistryUpcastersOpt sets the upcasters that migrate the envelopes to their latest schema version
+// before they are decoded.
+func EventRegistryUpcastersOpt(upcasters *UpcasterChain) EventRegistryOpt {
+	return func(r *EventRegistry) {
+		r.upcasters = upcasters
+	}
+}
+
+var _ EventCodec = &EventRegistry{}
+
+// EventRegistry maps each event type, identified by its EventName and SchemaVersion, to the factory of its
+// concrete type, so the events can be decoded back from their EventEnvelope.
+// It's the EventCodec to use in the stores and transports that need the concrete events.
+type EventRegistry struct {
+	mu        sync.RWMutex
+	factories map[eventType]EventFactory
+	upcasters *UpcasterChain
+}
+
+// NewEventRegistry is a constructor.
+func NewEventRegistry(opts ...EventRegistryOpt) *EventRegistry {
+	r := &EventRegistry{
+		factories: make(map[eventType]EventFactory),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Register registers the factory of an event type.
+func (r *EventRegistry) Register(name EventName, schema SchemaVersion, factory EventFactory) error {
+	if name == "" {
+		return ErrEmptyEventName
+	}
+
+	if schema < 1 {
+		return fmt.Errorf("%w: %d", ErrInvalidSchemaVersion, schema)
+	}
+
+	if factory == nil {
+		return ErrEmptyEventFactory
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := eventType{name: name, schema: schema}
+	if _, ok := r.factories[key]; ok {
+		return fmt.Errorf("%w: %s v%d", ErrEventTypeAlreadyRegistered, name, schema)
+	}
+
+	r.factories[key] = factory
+
+	return nil
+}
+
+// RegisterEvent registers the event type E, whose pointer is the decoded event.
+func RegisterEvent[E any, PE interface {
+	*E
+	Event
+}](r *EventRegistry, name EventName, schema SchemaVersion) error {
+	return r.Register(name, schema, func() Event { return PE(new(E)) })
+}
+
+// New returns a new instance of the event type.
+func (r *EventRegistry) New(name EventName, schema SchemaVersion) (Event, error) {
+	r.mu.RLock()
+	factory, ok := r.factories[eventType{name: name, schema: schema}]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s v%d", ErrEventTypeNotRegistered, name, schema)
+	}
+
+	return factory(), nil
+}
+
+// Envelope wraps the event in its EventEnvelope.
+func (r *EventRegistry) Envelope(ev Event) (EventEnvelope, error) {
+	if ev == nil {
+		return EventEnvelope{}, ErrEmptyEvent
+	}
+
+	schema := SchemaVersionOf(ev)
+	if !r.registered(ev.EventName(), schema) {
+		return EventEnvelope{}, fmt.Errorf("%w: %s v%d", ErrEventTypeNotRegistered, ev.EventName(), schema)
+	}
+
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return EventEnvelope{}, fmt.Errorf("marshal event %s payload: %w", ev.EventName(), err)
+	}
+
+	env := EventEnvelope{
+		ID:              idString(ev.EventID()),
+		Name:            ev.EventName(),
+		Version:         ev.EventVersion(),
+		SchemaVersion:   schema,
+		AggregateRootID: idString(ev.EventAggregateRootID()),
+		At:              ev.EventAt(),
+		Payload:         payload,
+	}
+
+	if md := EventMetadataOf(ev); !md.IsEmpty() {
+		env.Metadata = &md
+	}
+
+	return env, nil
+}
+
+// Open decodes the event of the envelope into its concrete type, once upcasted. The events that embed BasicEvent
+// are hydrated with the envelope fields, metadata included.
+func (r *EventRegistry) Open(env EventEnvelope) (Event, error) {
+	if env.SchemaVersion == 0 {
+		env.SchemaVersion = defaultSchemaVersion
+	}
+
+	if r.upcasters != nil {
+		var err error
+		if env, err = r.upcasters.Upcast(env); err != nil {
+			return nil, err
+		}
+	}
+
+	ev, err := r.New(env.Name, env.SchemaVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(env.Payload) > 0 {
+		if err := json.Unmarshal(env.Payload, ev); err != nil {
+			return nil, fmt.Errorf("unmarshal event %s payload: %w", env.Name, err)
+		}
+	}
+
+	if mev, ok := ev.(MetadataEvent); ok && env.Metadata != nil {
+		mev.SetEventMetadata(*env.Metadata)
+	}
+
+	hev, ok := ev.(HydratableEvent)
+	if !ok {
+		return ev, nil
+	}
+
+	id, err := parseOptionalID(env.ID)
+	if err != nil {
+		return nil, fmt.Errorf("event %s id: %w", env.Name, err)
+	}
+
+	aggRootID, err := parseOptionalID(env.AggregateRootID)
+	if err != nil {
+		return nil, fmt.Errorf("event %s aggregate root id: %w", env.Name, err)
+	}
+
+	hev.Hydrate(id, env.Name, env.At, aggRootID, env.Version)
+
+	return hev, nil
+}
+
+// Marshal is the EventCodec interface implementation.
+func (r *EventRegistry) Marshal(ev Event) ([]byte, error) {
+	env, err := r.Envelope(ev)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(env)
+}
+
+// Unmarshal is the EventCodec interface implementation.
+func (r *EventRegistry) Unmarshal(data []byte) (Event, error) {
+	var env EventEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("unmarshal event envelope: %w", err)
+	}
+
+	return r.Open(env)
+}
+
+func (r *EventRegistry) registered(name EventName, schema SchemaVersion) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.factories[eventType{name: name, schema: schema}]
+
+	return ok
+}
+
+// SchemaVersionOf returns the schema version of the event, which is 1 unless it implements SchemaVersioned.
+func SchemaVersionOf(ev Event) SchemaVersion {
+	if sv, ok := ev.(SchemaVersioned); ok {
+		return sv.EventSchemaVersion()
+	}
+
+	return defaultSchemaVersion
+}
+
+func idString(id vo.ID) string {
+	if id.IsEmpty() {
+		return ""
+	}
+
+	return id.String()
+}
+
+func parseOptionalID(s string) (vo.ID, error) {
+	if s == "" {
+		return vo.ID{}, nil
+	}
+
+	return vo.ParseID(s)
+}
@@ -0,0 +1,148 @@
+package cqs_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+type moneyDepositedV2 struct {
+	cqs.BasicEvent
+	Amount   int    `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func (moneyDepositedV2) EventSchemaVersion() cqs.SchemaVersion {
+	return 2
+}
+
+func newAccountEventRegistry(t *testing.T) *cqs.EventRegistry {
+	t.Helper()
+
+	r := cqs.NewEventRegistry()
+	require.NoError(t, cqs.RegisterEvent[accountOpened](r, accountOpenedName, 1))
+	require.NoError(t, cqs.RegisterEvent[moneyDeposited](r, moneyDepositedName, 1))
+	require.NoError(t, cqs.RegisterEvent[moneyDepositedV2](r, moneyDepositedName, 2))
+
+	return r
+}
+
+func TestEventRegistryRegister(t *testing.T) {
+	require := require.New(t)
+
+	factory := func() cqs.Event { return &moneyDeposited{} }
+
+	t.Run(`Given an event registry,
+	when an invalid event type is registered,
+	then it returns an error`, func(t *testing.T) {
+		r := cqs.NewEventRegistry()
+
+		require.ErrorIs(r.Register("", 1, factory), cqs.ErrEmptyEventName)
+		require.ErrorIs(r.Register(moneyDepositedName, 0, factory), cqs.ErrInvalidSchemaVersion)
+		require.ErrorIs(r.Register(moneyDepositedName, 1, nil), cqs.ErrEmptyEventFactory)
+	})
+
+	t.Run(`Given an event registry with an event type,
+	when the same name and schema version is registered again,
+	then it returns an ErrEventTypeAlreadyRegistered`, func(t *testing.T) {
+		r := cqs.NewEventRegistry()
+
+		require.NoError(r.Register(moneyDepositedName, 1, factory))
+		require.ErrorIs(r.Register(moneyDepositedName, 1, factory), cqs.ErrEventTypeAlreadyRegistered)
+		require.NoError(r.Register(moneyDepositedName, 2, factory))
+	})
+}
+
+func TestEventRegistryMarshal(t *testing.T) {
+	require := require.New(t)
+
+	t.Run(`Given an event registry,
+	when an event is marshaled and unmarshaled,
+	then it gets back the concrete event type`, func(t *testing.T) {
+		r := newAccountEventRegistry(t)
+
+		var ev moneyDeposited
+
+		ev.Hydrate(vo.NewID(), moneyDepositedName, vo.DateTimeNow(), vo.NewID(), 3)
+		ev.Amount = 10
+
+		data, err := r.Marshal(&ev)
+		require.NoError(err)
+
+		var env cqs.EventEnvelope
+		require.NoError(json.Unmarshal(data, &env))
+		require.Equal(ev.ID.String(), env.ID)
+		require.Equal(moneyDepositedName, env.Name)
+		require.Equal(cqs.EventVersion(3), env.Version)
+		require.Equal(cqs.SchemaVersion(1), env.SchemaVersion)
+		require.Equal(ev.AggregateRootID.String(), env.AggregateRootID)
+
+		decoded, err := r.Unmarshal(data)
+		require.NoError(err)
+		requireSameEvent(t, &ev, decoded)
+
+		deposited, ok := decoded.(*moneyDeposited)
+		require.True(ok)
+		require.Equal(10, deposited.Amount)
+	})
+
+	t.Run(`Given an event registry with several schema versions of an event,
+	when each of them is unmarshaled,
+	then it gets the type of its schema version`, func(t *testing.T) {
+		r := newAccountEventRegistry(t)
+
+		ev := &moneyDepositedV2{Amount: 10, Currency: "EUR"}
+		ev.Hydrate(vo.NewID(), moneyDepositedName, vo.DateTimeNow(), vo.NewID(), 1)
+
+		data, err := r.Marshal(ev)
+		require.NoError(err)
+
+		decoded, err := r.Unmarshal(data)
+		require.NoError(err)
+		require.Equal(cqs.SchemaVersion(2), cqs.SchemaVersionOf(decoded))
+		require.Equal("EUR", decoded.(*moneyDepositedV2).Currency)
+	})
+
+	t.Run(`Given an event registry,
+	when an event of a type not registered is marshaled or unmarshaled,
+	then it returns an ErrEventTypeNotRegistered`, func(t *testing.T) {
+		r := newAccountEventRegistry(t)
+
+		_, err := r.Marshal(newAggregateEvent(vo.NewID(), "unknown", 1))
+		require.ErrorIs(err, cqs.ErrEventTypeNotRegistered)
+
+		_, err = r.Unmarshal([]byte(`{"name":"money_deposited","schema_version":3,"payload":{}}`))
+		require.ErrorIs(err, cqs.ErrEventTypeNotRegistered)
+
+		_, err = r.Marshal(nil)
+		require.ErrorIs(err, cqs.ErrEmptyEvent)
+	})
+
+	t.Run(`Given an SQL event store with an event registry as codec,
+	when events are appended and loaded,
+	then it returns the concrete event types`, func(t *testing.T) {
+		ctx := context.Background()
+		store, err := cqs.NewSQLEventStore(newSQLiteDB(t), cqs.SQLiteDialect{}, newAccountEventRegistry(t))
+		require.NoError(err)
+		require.NoError(store.Migrate(ctx))
+
+		id := vo.NewID()
+		acc := newAccount(id)
+		require.NoError(acc.Open("john"))
+		require.NoError(acc.Deposit(10))
+		require.NoError(store.Append(ctx, id, acc.ExpectedVersion(), acc.PullEvents()...))
+
+		events, err := store.Load(ctx, id, 0)
+		require.NoError(err)
+
+		replayed := newAccount(id)
+		require.NoError(replayed.Replay(events...))
+		require.Equal("john", replayed.owner)
+		require.Equal(10, replayed.balance)
+	})
+}
@@ -0,0 +1,185 @@
+package cqs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+var (
+	ErrConcurrencyConflict    = errors.New("concurrency conflict")
+	ErrEventAggregateMismatch = errors.New("event aggregate mismatch")
+	ErrEventVersionMismatch   = errors.New("event version mismatch")
+	ErrInvalidBatchLimit      = errors.New("invalid batch limit")
+)
+
+// ConcurrencyConflictError is returned when appending to a stream whose version is not the expected one.
+// It matches ErrConcurrencyConflict with errors.Is.
+type ConcurrencyConflictError struct {
+	AggregateID vo.ID
+	Expected    EventVersion
+	Actual      EventVersion
+}
+
+// Error implements the Error interface.
+func (e ConcurrencyConflictError) Error() string {
+	return fmt.Sprintf("%s: aggregate %s expected version %d, actual version %d",
+		ErrConcurrencyConflict, e.AggregateID, e.Expected, e.Actual)
+}
+
+// Is reports whether the target is ErrConcurrencyConflict.
+func (e ConcurrencyConflictError) Is(target error) bool {
+	return target == ErrConcurrencyConflict //nolint:errorlint // it's the errors.Is implementation.
+}
+
+// StoredEvent is an event with its position in the whole store.
+type StoredEvent struct {
+	Position uint64
+	Event    Event
+}
+
+// EventStore persists the events of each aggregate in a stream, ordered by EventVersion.
+// The stream version is the version of its last event, or 0 if it's empty.
+type EventStore interface {
+	// Append adds the events to the aggregate stream if its version is the expected one, or returns a
+	// ConcurrencyConflictError otherwise. The versions of the events must follow the expected version.
+	Append(ctx context.Context, aggregateID vo.ID, expectedVersion EventVersion, events ...Event) error
+	// Load returns the events of the aggregate stream from the given version, included.
+	Load(ctx context.Context, aggregateID vo.ID, fromVersion EventVersion) ([]Event, error)
+	// LoadAll returns the events of every stream from the given position, included, in the order they were appended.
+	LoadAll(ctx context.Context, fromPosition uint64) ([]StoredEvent, error)
+}
+
+// validateAppend checks that the events belong to the aggregate and follow the expected version.
+func validateAppend(aggregateID vo.ID, expectedVersion EventVersion, events []Event) error {
+	for i, ev := range events {
+		if ev.EventAggregateRootID() != aggregateID {
+			return fmt.Errorf("%w: event %s belongs to aggregate %s, not %s",
+				ErrEventAggregateMismatch, ev.EventID(), ev.EventAggregateRootID(), aggregateID)
+		}
+
+		if want := expectedVersion + EventVersion(i) + 1; ev.EventVersion() != want {
+			return fmt.Errorf("%w: event %s has version %d, expected %d",
+				ErrEventVersionMismatch, ev.EventID(), ev.EventVersion(), want)
+		}
+	}
+
+	return nil
+}
+
+var _ EventStore = &InMemoryEventStore{}
+
+// InMemoryEventStore is a concurrent-safe EventStore that keeps the events in memory.
+// Its zero value is ready to use.
+type InMemoryEventStore struct {
+	mu      sync.RWMutex
+	all     []StoredEvent
+	streams map[vo.ID][]Event
+}
+
+// Append is the EventStore interface implementation.
+func (s *InMemoryEventStore) Append(_ context.Context, aggregateID vo.ID, expectedVersion EventVersion, events ...Event) error {
+	if err := validateAppend(aggregateID, expectedVersion, events); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.streams == nil {
+		s.streams = make(map[vo.ID][]Event)
+	}
+
+	stream := s.streams[aggregateID]
+
+	var version EventVersion
+	if len(stream) > 0 {
+		version = stream[len(stream)-1].EventVersion()
+	}
+
+	if version != expectedVersion {
+		return ConcurrencyConflictError{AggregateID: aggregateID, Expected: expectedVersion, Actual: version}
+	}
+
+	for _, ev := range events {
+		s.all = append(s.all, StoredEvent{Position: uint64(len(s.all)) + 1, Event: ev})
+	}
+
+	s.streams[aggregateID] = append(stream, events...)
+
+	return nil
+}
+
+// Load is the EventStore interface implementation.
+func (s *InMemoryEventStore) Load(_ context.Context, aggregateID vo.ID, fromVersion EventVersion) ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []Event
+
+	for _, ev := range s.streams[aggregateID] {
+		if ev.EventVersion() >= fromVersion {
+			events = append(events, ev)
+		}
+	}
+
+	return events, nil
+}
+
+// LoadAll is the EventStore interface implementation.
+func (s *InMemoryEventStore) LoadAll(_ context.Context, fromPosition uint64) ([]StoredEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if fromPosition == 0 {
+		fromPosition = 1
+	}
+
+	if fromPosition > uint64(len(s.all)) {
+		return nil, nil
+	}
+
+	events := make([]StoredEvent, uint64(len(s.all))-fromPosition+1)
+	copy(events, s.all[fromPosition-1:])
+
+	return events, nil
+}
+
+// LoadBatch is the OrderedEventSource interface implementation.
+func (s *InMemoryEventStore) LoadBatch(_ context.Context, fromPosition uint64, limit int) ([]StoredEvent, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidBatchLimit, limit)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if fromPosition == 0 {
+		fromPosition = 1
+	}
+
+	if fromPosition > uint64(len(s.all)) {
+		return nil, nil
+	}
+
+	to := uint64(len(s.all))
+	if fromPosition+uint64(limit) <= to {
+		to = fromPosition + uint64(limit) - 1
+	}
+
+	events := make([]StoredEvent, to-fromPosition+1)
+	copy(events, s.all[fromPosition-1:to])
+
+	return events, nil
+}
+
+// LastPosition returns the position of the last event, or 0 if the store is empty.
+func (s *InMemoryEventStore) LastPosition(_ context.Context) (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return uint64(len(s.all)), nil
+}
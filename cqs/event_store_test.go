@@ -0,0 +1,198 @@
+package cqs_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+func TestInMemoryEventStore(t *testing.T) {
+	testEventStore(t, func() cqs.EventStore { return &cqs.InMemoryEventStore{} })
+}
+
+func newAggregateEvent(aggID vo.ID, name cqs.EventName, version cqs.EventVersion) cqs.BasicEvent {
+	var ev cqs.BasicEvent
+
+	ev.Hydrate(vo.NewID(), name, vo.DateTimeNow(), aggID, version)
+
+	return ev
+}
+
+// testEventStore checks the behavior every EventStore implementation must have.
+func testEventStore(t *testing.T, newStore func() cqs.EventStore) {
+	t.Helper()
+
+	require := require.New(t)
+
+	ctx := context.Background()
+
+	t.Run(`Given an event store,
+	when events are appended to a new stream with the expected version 0,
+	then Load returns them in order`, func(t *testing.T) {
+		store := newStore()
+		aggID := vo.NewID()
+		ev1 := newAggregateEvent(aggID, "foo", 1)
+		ev2 := newAggregateEvent(aggID, "bar", 2)
+
+		require.NoError(store.Append(ctx, aggID, 0, ev1, ev2))
+
+		events, err := store.Load(ctx, aggID, 0)
+		require.NoError(err)
+		require.Len(events, 2)
+		requireSameEvent(t, ev1, events[0])
+		requireSameEvent(t, ev2, events[1])
+
+		events, err = store.Load(ctx, aggID, 2)
+		require.NoError(err)
+		require.Len(events, 1)
+		requireSameEvent(t, ev2, events[0])
+
+		events, err = store.Load(ctx, vo.NewID(), 0)
+		require.NoError(err)
+		require.Empty(events)
+	})
+
+	t.Run(`Given an event store with a stream,
+	when events are appended with a stale expected version,
+	then it returns a ConcurrencyConflictError`, func(t *testing.T) {
+		store := newStore()
+		aggID := vo.NewID()
+
+		require.NoError(store.Append(ctx, aggID, 0, newAggregateEvent(aggID, "foo", 1)))
+
+		err := store.Append(ctx, aggID, 0, newAggregateEvent(aggID, "foo", 1))
+		require.ErrorIs(err, cqs.ErrConcurrencyConflict)
+
+		var conflictErr cqs.ConcurrencyConflictError
+		require.ErrorAs(err, &conflictErr)
+		require.Equal(aggID, conflictErr.AggregateID)
+		require.Equal(cqs.EventVersion(0), conflictErr.Expected)
+		require.Equal(cqs.EventVersion(1), conflictErr.Actual)
+
+		require.NoError(store.Append(ctx, aggID, 1, newAggregateEvent(aggID, "foo", 2)))
+	})
+
+	t.Run(`Given an event store,
+	when events that don't follow the expected version or belong to another aggregate are appended,
+	then it returns an error`, func(t *testing.T) {
+		store := newStore()
+		aggID := vo.NewID()
+
+		err := store.Append(ctx, aggID, 0, newAggregateEvent(aggID, "foo", 2))
+		require.ErrorIs(err, cqs.ErrEventVersionMismatch)
+
+		err = store.Append(ctx, aggID, 0, newAggregateEvent(vo.NewID(), "foo", 1))
+		require.ErrorIs(err, cqs.ErrEventAggregateMismatch)
+	})
+
+	t.Run(`Given an event store with several streams,
+	when LoadAll is called,
+	then it returns the events of every stream in order with their positions`, func(t *testing.T) {
+		store := newStore()
+		aggA := vo.NewID()
+		aggB := vo.NewID()
+		evA1 := newAggregateEvent(aggA, "foo", 1)
+		evB1 := newAggregateEvent(aggB, "foo", 1)
+		evA2 := newAggregateEvent(aggA, "foo", 2)
+
+		require.NoError(store.Append(ctx, aggA, 0, evA1))
+		require.NoError(store.Append(ctx, aggB, 0, evB1))
+		require.NoError(store.Append(ctx, aggA, 1, evA2))
+
+		all, err := store.LoadAll(ctx, 0)
+		require.NoError(err)
+		require.Len(all, 3)
+
+		for i, ev := range []cqs.Event{evA1, evB1, evA2} {
+			requireSameEvent(t, ev, all[i].Event)
+
+			if i > 0 {
+				require.Greater(all[i].Position, all[i-1].Position)
+			}
+		}
+
+		from, err := store.LoadAll(ctx, all[1].Position)
+		require.NoError(err)
+		require.Len(from, 2)
+		require.Equal(all[1].Position, from[0].Position)
+
+		none, err := store.LoadAll(ctx, all[2].Position+1)
+		require.NoError(err)
+		require.Empty(none)
+
+		if source, ok := store.(cqs.OrderedEventSource); ok {
+			last, err := source.LastPosition(ctx)
+			require.NoError(err)
+			require.Equal(all[2].Position, last)
+
+			batch, err := source.LoadBatch(ctx, all[1].Position, 1)
+			require.NoError(err)
+			require.Len(batch, 1)
+			require.Equal(all[1].Position, batch[0].Position)
+
+			for _, limit := range []int{0, -1} {
+				_, err = source.LoadBatch(ctx, 0, limit)
+				require.ErrorIs(err, cqs.ErrInvalidBatchLimit)
+			}
+		}
+	})
+
+	t.Run(`Given an event store,
+	when several writers append to the same stream concurrently with the same expected version,
+	then only one of them succeeds`, func(t *testing.T) {
+		store := newStore()
+		aggID := vo.NewID()
+
+		const writers = 10
+
+		var (
+			wg        sync.WaitGroup
+			mu        sync.Mutex
+			succeeded int
+		)
+
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				err := store.Append(ctx, aggID, 0, newAggregateEvent(aggID, "foo", 1))
+				if err == nil {
+					mu.Lock()
+					succeeded++
+					mu.Unlock()
+
+					return
+				}
+
+				if !errors.Is(err, cqs.ErrConcurrencyConflict) {
+					t.Error(err)
+				}
+			}()
+		}
+
+		wg.Wait()
+		require.Equal(1, succeeded)
+
+		events, err := store.Load(ctx, aggID, 0)
+		require.NoError(err)
+		require.Len(events, 1)
+	})
+}
+
+func requireSameEvent(t *testing.T, expected, actual cqs.Event) {
+	t.Helper()
+
+	require.Equal(t, expected.EventID(), actual.EventID())
+	require.Equal(t, expected.EventName(), actual.EventName())
+	require.Equal(t, expected.EventAggregateRootID(), actual.EventAggregateRootID())
+	require.Equal(t, expected.EventVersion(), actual.EventVersion())
+	require.True(t, expected.EventAt().Equal(actual.EventAt()))
+}
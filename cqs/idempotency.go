@@ -0,0 +1,322 @@
+package cqs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultIdempotencyTTL = 24 * time.Hour
+
+var ErrCommandInFlight = errors.New("command in flight")
+
+// IdempotentCommand is a command that carries an idempotency key, usually set by the client,
+// that identifies its retries.
+type IdempotentCommand interface {
+	Command
+	IdempotencyKey() string
+}
+
+// IdempotentOutcome is the result of handling an idempotent command. Dispatched reports whether its events were
+// dispatched to the events bus by the caller that handled it.
+type IdempotentOutcome struct {
+	Events     []Event
+	Err        error
+	Dispatched bool
+}
+
+// IdempotencyStore keeps the outcomes of the idempotent commands.
+type IdempotencyStore interface {
+	// Reserve reserves the key for the given time to live. If the key already has an outcome it returns it and true.
+	// If the key is reserved but has no outcome yet, it returns ErrCommandInFlight.
+	Reserve(ctx context.Context, key string, ttl time.Duration) (IdempotentOutcome, bool, error)
+	// Complete stores the outcome of a reserved key for the given time to live.
+	Complete(ctx context.Context, key string, outcome IdempotentOutcome, ttl time.Duration) error
+	// Release removes the reservation of a key without outcome, so it can be handled again.
+	Release(ctx context.Context, key string) error
+}
+
+// IdempotencyOpt is the common type of functions that set options on the idempotency middleware.
+type IdempotencyOpt func(m *idempotency)
+
+// IdempotencyTTLOpt sets how long the outcomes are kept. The default is 24h.
+func IdempotencyTTLOpt(ttl time.Duration) IdempotencyOpt {
+	return func(m *idempotency) {
+		if ttl > 0 {
+			m.ttl = ttl
+		}
+	}
+}
+
+type idempotency struct {
+	store IdempotencyStore
+	ttl   time.Duration
+}
+
+// IdempotencyCommandHandlerMiddleware returns the cached outcome, events and error, when a command that implements
+// IdempotentCommand is handled again with the same key, and rejects it with ErrCommandInFlight while it's being
+// handled. The keys are scoped by command name. The commands without key are handled as usual, and the context
+// errors aren't cached so the command can be retried, and neither are the panics, which release the key.
+// The cached events are returned again, but the CommandBus and the EventCommandsHandler don't dispatch them again
+// once they were dispatched successfully: the outcome records it after the dispatch.
+func IdempotencyCommandHandlerMiddleware[C Command](store IdempotencyStore, opts ...IdempotencyOpt) CommandHandlerMiddleware[C] {
+	m := idempotency{
+		store: store,
+		ttl:   defaultIdempotencyTTL,
+	}
+	for _, opt := range opts {
+		opt(&m)
+	}
+
+	return func(h CommandHandler[C]) CommandHandler[C] {
+		return CommandHandlerFunc[C](func(ctx context.Context, cmd C) ([]Event, error) {
+			ctx, mark := takeDispatchMark(ctx, cmd.CommandName())
+
+			idempotent, ok := any(cmd).(IdempotentCommand)
+			if !ok || idempotent.IdempotencyKey() == "" {
+				return h.Handle(ctx, cmd)
+			}
+
+			key := idempotent.CommandName() + ":" + idempotent.IdempotencyKey()
+
+			outcome, done, err := m.store.Reserve(ctx, key, m.ttl)
+			if err != nil {
+				return nil, fmt.Errorf("reserve idempotency key %s: %w", key, err)
+			}
+
+			if done {
+				if outcome.Dispatched {
+					mark.replay()
+				} else {
+					mark.onDispatched(m.dispatched(key, outcome))
+				}
+
+				return outcome.Events, outcome.Err
+			}
+
+			defer func() {
+				if r := recover(); r != nil {
+					_ = m.store.Release(withoutCancel(ctx), key)
+
+					panic(r)
+				}
+			}()
+
+			events, err := h.Handle(ctx, cmd)
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				if releaseErr := m.store.Release(withoutCancel(ctx), key); releaseErr != nil {
+					return events, fmt.Errorf("%w; release idempotency key %s: %s", err, key, releaseErr)
+				}
+
+				return events, err
+			}
+
+			outcome = IdempotentOutcome{Events: events, Err: err}
+			if completeErr := m.store.Complete(withoutCancel(ctx), key, outcome, m.ttl); completeErr != nil {
+				return events, fmt.Errorf("complete idempotency key %s: %w", key, completeErr)
+			}
+
+			mark.onDispatched(m.dispatched(key, outcome))
+
+			return events, err
+		})
+	}
+}
+
+// dispatched returns the function that records that the events of the outcome were dispatched.
+func (m idempotency) dispatched(key string, outcome IdempotentOutcome) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		outcome.Dispatched = true
+		if err := m.store.Complete(withoutCancel(ctx), key, outcome, m.ttl); err != nil {
+			return fmt.Errorf("complete idempotency key %s: %w", key, err)
+		}
+
+		return nil
+	}
+}
+
+type dispatchMarkKey struct{}
+
+// dispatchMark lets the caller that dispatches the events of a command and the idempotency middleware agree:
+// the events of a replayed outcome aren't dispatched again, and the outcome records the successful dispatches.
+// It belongs to a single command: the middleware takes it out of the context, so the nested commands don't see it.
+type dispatchMark struct {
+	command    string
+	replayed   bool
+	dispatched func(ctx context.Context) error
+}
+
+// withDispatchMark returns a copy of the context that carries a new dispatch mark for the command.
+func withDispatchMark(ctx context.Context, command string) (context.Context, *dispatchMark) {
+	mark := &dispatchMark{command: command}
+
+	return context.WithValue(ctx, dispatchMarkKey{}, mark), mark
+}
+
+// takeDispatchMark returns the dispatch mark of the command, or nil, and a copy of the context without it.
+func takeDispatchMark(ctx context.Context, command string) (context.Context, *dispatchMark) {
+	mark, ok := ctx.Value(dispatchMarkKey{}).(*dispatchMark)
+	if !ok {
+		return ctx, nil
+	}
+
+	ctx = context.WithValue(ctx, dispatchMarkKey{}, (*dispatchMark)(nil))
+	if mark == nil || mark.command != command {
+		return ctx, nil
+	}
+
+	return ctx, mark
+}
+
+func (mark *dispatchMark) replay() {
+	if mark != nil {
+		mark.replayed = true
+	}
+}
+
+func (mark *dispatchMark) onDispatched(f func(ctx context.Context) error) {
+	if mark != nil {
+		mark.dispatched = f
+	}
+}
+
+// dispatch dispatches the events to the bus unless they are replayed, and records the dispatch when it succeeds.
+func (mark *dispatchMark) dispatch(ctx context.Context, bus EventsBus, events []Event, multierror *MultiError) {
+	if bus == nil || mark.replayed {
+		return
+	}
+
+	failed := false
+
+	for _, ev := range events {
+		if err := bus.Dispatch(ctx, ev); err != nil {
+			multierror.Add(err)
+
+			failed = true
+		}
+	}
+
+	if failed || mark.dispatched == nil {
+		return
+	}
+
+	if err := mark.dispatched(ctx); err != nil {
+		multierror.Add(err)
+	}
+}
+
+// InMemoryIdempotencyStoreOpt is the common type of functions that set options on InMemoryIdempotencyStore
+// construction.
+type InMemoryIdempotencyStoreOpt func(s *InMemoryIdempotencyStore)
+
+// InMemoryIdempotencyStoreNowOpt sets the clock used to expire the keys. It's useful for testing purposes.
+func InMemoryIdempotencyStoreNowOpt(now func() time.Time) InMemoryIdempotencyStoreOpt {
+	return func(s *InMemoryIdempotencyStore) {
+		if now != nil {
+			s.now = now
+		}
+	}
+}
+
+type idempotencyEntry struct {
+	done      bool
+	outcome   IdempotentOutcome
+	expiresAt time.Time
+}
+
+var _ IdempotencyStore = &InMemoryIdempotencyStore{}
+
+// InMemoryIdempotencyStore is a concurrent-safe IdempotencyStore that keeps the outcomes in memory.
+type InMemoryIdempotencyStore struct {
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[string]idempotencyEntry
+	swept   time.Time
+}
+
+// NewInMemoryIdempotencyStore is a constructor.
+func NewInMemoryIdempotencyStore(opts ...InMemoryIdempotencyStoreOpt) *InMemoryIdempotencyStore {
+	s := &InMemoryIdempotencyStore{
+		now:     time.Now,
+		entries: make(map[string]idempotencyEntry),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.swept = s.now()
+
+	return s
+}
+
+// Reserve is the IdempotencyStore interface implementation.
+func (s *InMemoryIdempotencyStore) Reserve(_ context.Context, key string, ttl time.Duration) (IdempotentOutcome, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now, ttl)
+
+	if entry, ok := s.entries[key]; ok && now.Before(entry.expiresAt) {
+		if !entry.done {
+			return IdempotentOutcome{}, false, fmt.Errorf("%w: %s", ErrCommandInFlight, key)
+		}
+
+		return copyOutcome(entry.outcome), true, nil
+	}
+
+	s.entries[key] = idempotencyEntry{expiresAt: now.Add(ttl)}
+
+	return IdempotentOutcome{}, false, nil
+}
+
+// Complete is the IdempotencyStore interface implementation.
+func (s *InMemoryIdempotencyStore) Complete(_ context.Context, key string, outcome IdempotentOutcome, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = idempotencyEntry{
+		done:      true,
+		outcome:   copyOutcome(outcome),
+		expiresAt: s.now().Add(ttl),
+	}
+
+	return nil
+}
+
+// Release is the IdempotencyStore interface implementation.
+func (s *InMemoryIdempotencyStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok && !entry.done {
+		delete(s.entries, key)
+	}
+
+	return nil
+}
+
+// sweep removes the expired keys, at most once per time to live.
+func (s *InMemoryIdempotencyStore) sweep(now time.Time, ttl time.Duration) {
+	if now.Sub(s.swept) < ttl {
+		return
+	}
+
+	for key, entry := range s.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+
+	s.swept = now
+}
+
+func copyOutcome(outcome IdempotentOutcome) IdempotentOutcome {
+	if outcome.Events != nil {
+		outcome.Events = append([]Event(nil), outcome.Events...)
+	}
+
+	return outcome
+}
@@ -0,0 +1,288 @@
+package cqs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+)
+
+type payCommand struct {
+	Key    string
+	Amount int
+}
+
+func (payCommand) CommandName() string {
+	return "pay"
+}
+
+func (c payCommand) IdempotencyKey() string {
+	return c.Key
+}
+
+func TestIdempotencyCommandHandlerMiddleware(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+
+	newHandler := func(handle func(ctx context.Context, cmd payCommand) ([]cqs.Event, error)) *CommandHandlerMock[payCommand] {
+		return &CommandHandlerMock[payCommand]{HandleFunc: handle}
+	}
+
+	t.Run(`Given a command handler with the idempotency middleware,
+	when a command is handled twice with the same key,
+	then the handler is called once and the cached events are returned`, func(t *testing.T) {
+		handler := newHandler(func(context.Context, payCommand) ([]cqs.Event, error) {
+			return []cqs.Event{newBasicEvent("paid")}, nil
+		})
+		h := cqs.IdempotencyCommandHandlerMiddleware[payCommand](cqs.NewInMemoryIdempotencyStore())(handler)
+
+		first, err := h.Handle(ctx, payCommand{Key: "a"})
+		require.NoError(err)
+
+		second, err := h.Handle(ctx, payCommand{Key: "a"})
+		require.NoError(err)
+		require.Equal(first, second)
+		require.Len(handler.HandleCalls(), 1)
+
+		_, err = h.Handle(ctx, payCommand{Key: "b"})
+		require.NoError(err)
+
+		_, err = h.Handle(ctx, payCommand{})
+		require.NoError(err)
+
+		_, err = h.Handle(ctx, payCommand{})
+		require.NoError(err)
+		require.Len(handler.HandleCalls(), 4)
+	})
+
+	t.Run(`Given a command handler with the idempotency middleware that fails,
+	when the command is handled again with the same key,
+	then the cached error is returned unless it's a context error`, func(t *testing.T) {
+		errHandler := errors.New("insufficient funds")
+		handler := newHandler(func(ctx context.Context, cmd payCommand) ([]cqs.Event, error) {
+			if cmd.Amount < 0 {
+				return nil, context.DeadlineExceeded
+			}
+
+			return nil, errHandler
+		})
+		h := cqs.IdempotencyCommandHandlerMiddleware[payCommand](cqs.NewInMemoryIdempotencyStore())(handler)
+
+		_, err := h.Handle(ctx, payCommand{Key: "a"})
+		require.ErrorIs(err, errHandler)
+
+		_, err = h.Handle(ctx, payCommand{Key: "a"})
+		require.ErrorIs(err, errHandler)
+		require.Len(handler.HandleCalls(), 1)
+
+		_, err = h.Handle(ctx, payCommand{Key: "b", Amount: -1})
+		require.ErrorIs(err, context.DeadlineExceeded)
+
+		_, err = h.Handle(ctx, payCommand{Key: "b"})
+		require.ErrorIs(err, errHandler)
+		require.Len(handler.HandleCalls(), 3)
+	})
+
+	t.Run(`Given a command being handled with the idempotency middleware,
+	when a duplicate is handled at the same time,
+	then it's rejected with ErrCommandInFlight`, func(t *testing.T) {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		handler := newHandler(func(context.Context, payCommand) ([]cqs.Event, error) {
+			close(started)
+			<-release
+
+			return nil, nil
+		})
+		h := cqs.IdempotencyCommandHandlerMiddleware[payCommand](cqs.NewInMemoryIdempotencyStore())(handler)
+
+		done := make(chan error)
+
+		go func() {
+			_, err := h.Handle(ctx, payCommand{Key: "a"})
+			done <- err
+		}()
+
+		<-started
+
+		_, err := h.Handle(ctx, payCommand{Key: "a"})
+		require.ErrorIs(err, cqs.ErrCommandInFlight)
+
+		close(release)
+		require.NoError(<-done)
+
+		_, err = h.Handle(ctx, payCommand{Key: "a"})
+		require.NoError(err)
+		require.Len(handler.HandleCalls(), 1)
+	})
+
+	t.Run(`Given a command bus with the idempotency middleware and a time to live,
+	when a command is handled again after the time to live,
+	then the handler is called again`, func(t *testing.T) {
+		now := time.Now()
+		store := cqs.NewInMemoryIdempotencyStore(cqs.InMemoryIdempotencyStoreNowOpt(func() time.Time { return now }))
+		bus := cqs.NewCommandBus(cqs.CommandBusMiddlewareOpt(
+			cqs.IdempotencyCommandHandlerMiddleware[cqs.Command](store, cqs.IdempotencyTTLOpt(time.Minute)),
+		))
+		handler := newHandler(func(context.Context, payCommand) ([]cqs.Event, error) { return nil, nil })
+		require.NoError(cqs.RegisterCommandHandler[payCommand](bus, payCommand{}.CommandName(), handler))
+
+		_, err := bus.Dispatch(ctx, payCommand{Key: "a"})
+		require.NoError(err)
+
+		now = now.Add(59 * time.Second)
+
+		_, err = bus.Dispatch(ctx, payCommand{Key: "a"})
+		require.NoError(err)
+		require.Len(handler.HandleCalls(), 1)
+
+		now = now.Add(time.Second)
+
+		_, err = bus.Dispatch(ctx, payCommand{Key: "a"})
+		require.NoError(err)
+		require.Len(handler.HandleCalls(), 2)
+	})
+
+	t.Run(`Given a command handler with the idempotency middleware that panics,
+	when the command is handled again after the panic,
+	then the key is released and the handler is called again`, func(t *testing.T) {
+		fail := true
+		handler := newHandler(func(context.Context, payCommand) ([]cqs.Event, error) {
+			if fail {
+				panic("boom")
+			}
+
+			return nil, nil
+		})
+		h := cqs.IdempotencyCommandHandlerMiddleware[payCommand](cqs.NewInMemoryIdempotencyStore())(handler)
+
+		require.PanicsWithValue("boom", func() { _, _ = h.Handle(ctx, payCommand{Key: "a"}) })
+
+		fail = false
+
+		_, err := h.Handle(ctx, payCommand{Key: "a"})
+		require.NoError(err)
+		require.Len(handler.HandleCalls(), 2)
+	})
+
+	t.Run(`Given a command bus with the idempotency middleware and an events bus,
+	when a command is handled twice with the same key,
+	then the cached events are returned but dispatched once`, func(t *testing.T) {
+		eventsHandler := &EventHandlerMock{HandleFunc: func(context.Context, cqs.Event) error { return nil }}
+		eventsBus := &cqs.ConcurrentEventsBus{}
+		require.NoError(eventsBus.Subscribe("paid", eventsHandler))
+
+		bus := cqs.NewCommandBus(
+			cqs.CommandBusEventsBusOpt(eventsBus),
+			cqs.CommandBusMiddlewareOpt(cqs.IdempotencyCommandHandlerMiddleware[cqs.Command](cqs.NewInMemoryIdempotencyStore())),
+		)
+		handler := newHandler(func(context.Context, payCommand) ([]cqs.Event, error) {
+			return []cqs.Event{newBasicEvent("paid")}, nil
+		})
+		require.NoError(cqs.RegisterCommandHandler[payCommand](bus, payCommand{}.CommandName(), handler))
+
+		first, err := bus.Dispatch(ctx, payCommand{Key: "a"})
+		require.NoError(err)
+
+		second, err := bus.Dispatch(ctx, payCommand{Key: "a"})
+		require.NoError(err)
+		require.Equal(first, second)
+		require.Len(handler.HandleCalls(), 1)
+		require.Len(eventsHandler.HandleCalls(), 1)
+	})
+
+	t.Run(`Given a command bus with the idempotency middleware and an events bus that fails once,
+	when a command is handled again with the same key,
+	then the cached events are dispatched until a dispatch succeeds`, func(t *testing.T) {
+		fail := true
+		eventsHandler := &EventHandlerMock{HandleFunc: func(context.Context, cqs.Event) error {
+			if fail {
+				fail = false
+
+				return errors.New("unavailable")
+			}
+
+			return nil
+		}}
+		eventsBus := &cqs.ConcurrentEventsBus{}
+		require.NoError(eventsBus.Subscribe("paid", eventsHandler))
+
+		bus := cqs.NewCommandBus(
+			cqs.CommandBusEventsBusOpt(eventsBus),
+			cqs.CommandBusMiddlewareOpt(cqs.IdempotencyCommandHandlerMiddleware[cqs.Command](cqs.NewInMemoryIdempotencyStore())),
+		)
+		handler := newHandler(func(context.Context, payCommand) ([]cqs.Event, error) {
+			return []cqs.Event{newBasicEvent("paid")}, nil
+		})
+		require.NoError(cqs.RegisterCommandHandler[payCommand](bus, payCommand{}.CommandName(), handler))
+
+		_, err := bus.Dispatch(ctx, payCommand{Key: "a"})
+		require.Error(err)
+
+		_, err = bus.Dispatch(ctx, payCommand{Key: "a"})
+		require.NoError(err)
+
+		_, err = bus.Dispatch(ctx, payCommand{Key: "a"})
+		require.NoError(err)
+		require.Len(handler.HandleCalls(), 1)
+		require.Len(eventsHandler.HandleCalls(), 2)
+	})
+
+	t.Run(`Given a command bus with an events bus,
+	when a command handler handles a replayed command with the idempotency middleware,
+	then the events of the outer command are dispatched`, func(t *testing.T) {
+		eventsHandler := &EventHandlerMock{HandleFunc: func(context.Context, cqs.Event) error { return nil }}
+		eventsBus := &cqs.ConcurrentEventsBus{}
+		require.NoError(eventsBus.Subscribe("ordered", eventsHandler))
+
+		pay := cqs.IdempotencyCommandHandlerMiddleware[payCommand](cqs.NewInMemoryIdempotencyStore())(newHandler(func(context.Context, payCommand) ([]cqs.Event, error) {
+			return []cqs.Event{newBasicEvent("paid")}, nil
+		}))
+		_, err := pay.Handle(ctx, payCommand{Key: "a"})
+		require.NoError(err)
+
+		bus := cqs.NewCommandBus(cqs.CommandBusEventsBusOpt(eventsBus))
+		require.NoError(cqs.RegisterCommandHandler[helloCommand](bus, helloCommand{}.CommandName(), &CommandHandlerMock[helloCommand]{
+			HandleFunc: func(ctx context.Context, _ helloCommand) ([]cqs.Event, error) {
+				if _, err := pay.Handle(ctx, payCommand{Key: "a"}); err != nil {
+					return nil, err
+				}
+
+				return []cqs.Event{newBasicEvent("ordered")}, nil
+			},
+		}))
+
+		_, err = bus.Dispatch(ctx, helloCommand{})
+		require.NoError(err)
+		require.Len(eventsHandler.HandleCalls(), 1)
+	})
+
+	t.Run(`Given a concurrent event commands handler with the idempotency middleware and an events bus,
+	when the same idempotent command is produced by two events,
+	then its events are dispatched once`, func(t *testing.T) {
+		eventsHandler := &EventHandlerMock{HandleFunc: func(context.Context, cqs.Event) error { return nil }}
+		eventsBus := &cqs.ConcurrentEventsBus{}
+		require.NoError(eventsBus.Subscribe("paid", eventsHandler))
+
+		handler := &CommandHandlerMock[cqs.Command]{HandleFunc: func(context.Context, cqs.Command) ([]cqs.Event, error) {
+			return []cqs.Event{newBasicEvent("paid")}, nil
+		}}
+		h, err := cqs.NewEventCommandsHandler(
+			func(cqs.Event) ([]cqs.Command, error) { return []cqs.Command{payCommand{Key: "a"}}, nil },
+			cqs.IdempotencyCommandHandlerMiddleware[cqs.Command](cqs.NewInMemoryIdempotencyStore())(handler),
+			cqs.EventCommandsHandlerConcurrentOpt(),
+			cqs.EventCommandsHandlerEventsBusOpt(eventsBus),
+		)
+		require.NoError(err)
+
+		require.NoError(h.Handle(ctx, newBasicEvent("ordered")))
+		require.NoError(h.Handle(ctx, newBasicEvent("ordered")))
+		require.Len(handler.HandleCalls(), 1)
+		require.Len(eventsHandler.HandleCalls(), 1)
+	})
+}
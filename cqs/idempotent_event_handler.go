@@ -0,0 +1,202 @@
+package cqs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+var ErrEventAlreadyProcessed = errors.New("event already processed")
+
+// ProcessedStore records the events each handler has processed.
+type ProcessedStore interface {
+	// IsProcessed reports whether the handler has processed the event.
+	IsProcessed(ctx context.Context, handlerName string, eventID vo.ID) (bool, error)
+	// MarkProcessed records that the handler has processed the event. It returns ErrEventAlreadyProcessed
+	// if it was already recorded, when the store can detect it.
+	MarkProcessed(ctx context.Context, handlerName string, eventID vo.ID) error
+}
+
+// TransactionalProcessedStore is a ProcessedStore that can record the processed events in the same
+// transaction as the changes of the handler.
+type TransactionalProcessedStore interface {
+	ProcessedStore
+	// InTx calls f with a context that carries a transaction, committed if f succeeds or rolled back otherwise.
+	InTx(ctx context.Context, f func(ctx context.Context) error) error
+}
+
+// IdempotentEventHandlerOpt is the common type of functions that set options on the idempotent event handler.
+type IdempotentEventHandlerOpt func(h *idempotentEventHandler)
+
+// IdempotentHandlerNameOpt sets the name that identifies the handler in the store.
+// The default is the name of the handler, or its type if it isn't Named.
+func IdempotentHandlerNameOpt(name string) IdempotentEventHandlerOpt {
+	return func(h *idempotentEventHandler) {
+		h.name = name
+	}
+}
+
+var (
+	_ EventHandler = idempotentEventHandler{}
+	_ Named        = idempotentEventHandler{}
+)
+
+type idempotentEventHandler struct {
+	handler EventHandler
+	store   ProcessedStore
+	name    string
+}
+
+// NewIdempotentEventHandler decorates the event handler to skip the events it has already processed.
+// The events are recorded once handled successfully. With a TransactionalProcessedStore the handler is called
+// inside a transaction, which it can get from the context to make its changes atomically with the record.
+func NewIdempotentEventHandler(h EventHandler, store ProcessedStore, opts ...IdempotentEventHandlerOpt) EventHandler {
+	ih := idempotentEventHandler{
+		handler: h,
+		store:   store,
+		name:    handlerName(h),
+	}
+	for _, opt := range opts {
+		opt(&ih)
+	}
+
+	return ih
+}
+
+// Handle is the EventHandler interface implementation.
+func (h idempotentEventHandler) Handle(ctx context.Context, ev Event) error {
+	var err error
+
+	if txStore, ok := h.store.(TransactionalProcessedStore); ok {
+		err = txStore.InTx(ctx, func(ctx context.Context) error {
+			return h.handle(ctx, ev)
+		})
+	} else {
+		err = h.handle(ctx, ev)
+	}
+
+	// A concurrent duplicate has been processed first.
+	if errors.Is(err, ErrEventAlreadyProcessed) {
+		return nil
+	}
+
+	return err
+}
+
+func (h idempotentEventHandler) handle(ctx context.Context, ev Event) error {
+	processed, err := h.store.IsProcessed(ctx, h.name, ev.EventID())
+	if err != nil {
+		return fmt.Errorf("check processed event %s: %w", ev.EventID(), err)
+	}
+
+	if processed {
+		return nil
+	}
+
+	if err := h.handler.Handle(ctx, ev); err != nil {
+		return err
+	}
+
+	return h.store.MarkProcessed(ctx, h.name, ev.EventID())
+}
+
+// HandlerName is the Named interface implementation.
+func (h idempotentEventHandler) HandlerName() string {
+	return h.name
+}
+
+// InMemoryProcessedStoreOpt is the common type of functions that set options on InMemoryProcessedStore construction.
+type InMemoryProcessedStoreOpt func(s *InMemoryProcessedStore)
+
+// InMemoryProcessedStoreNowOpt sets the clock used to expire the records. It's useful for testing purposes.
+func InMemoryProcessedStoreNowOpt(now func() time.Time) InMemoryProcessedStoreOpt {
+	return func(s *InMemoryProcessedStore) {
+		if now != nil {
+			s.now = now
+		}
+	}
+}
+
+type processedKey struct {
+	handlerName string
+	eventID     vo.ID
+}
+
+var _ ProcessedStore = &InMemoryProcessedStore{}
+
+// InMemoryProcessedStore is a concurrent-safe ProcessedStore that keeps the records in memory
+// for a time to live, or forever if it's 0.
+type InMemoryProcessedStore struct {
+	ttl       time.Duration
+	now       func() time.Time
+	mu        sync.Mutex
+	processed map[processedKey]time.Time
+	swept     time.Time
+}
+
+// NewInMemoryProcessedStore is a constructor.
+func NewInMemoryProcessedStore(ttl time.Duration, opts ...InMemoryProcessedStoreOpt) *InMemoryProcessedStore {
+	s := &InMemoryProcessedStore{
+		ttl:       ttl,
+		now:       time.Now,
+		processed: make(map[processedKey]time.Time),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.swept = s.now()
+
+	return s
+}
+
+// IsProcessed is the ProcessedStore interface implementation.
+func (s *InMemoryProcessedStore) IsProcessed(_ context.Context, handlerName string, eventID vo.ID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at, ok := s.processed[processedKey{handlerName: handlerName, eventID: eventID}]
+
+	return ok && !s.expired(at, s.now()), nil
+}
+
+// MarkProcessed is the ProcessedStore interface implementation.
+func (s *InMemoryProcessedStore) MarkProcessed(_ context.Context, handlerName string, eventID vo.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	key := processedKey{handlerName: handlerName, eventID: eventID}
+	if at, ok := s.processed[key]; ok && !s.expired(at, now) {
+		return fmt.Errorf("%w: %s by %s", ErrEventAlreadyProcessed, eventID, handlerName)
+	}
+
+	s.processed[key] = now
+
+	return nil
+}
+
+func (s *InMemoryProcessedStore) expired(at, now time.Time) bool {
+	return s.ttl > 0 && now.Sub(at) >= s.ttl
+}
+
+// sweep removes the expired records, at most once per time to live.
+func (s *InMemoryProcessedStore) sweep(now time.Time) {
+	if s.ttl <= 0 || now.Sub(s.swept) < s.ttl {
+		return
+	}
+
+	for key, at := range s.processed {
+		if s.expired(at, now) {
+			delete(s.processed, key)
+		}
+	}
+
+	s.swept = now
+}
@@ -0,0 +1,95 @@
+package cqs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+func TestIdempotentEventHandler(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+
+	t.Run(`Given an idempotent event handler,
+	when the same event is handled twice,
+	then the decorated handler is called once`, func(t *testing.T) {
+		store := cqs.NewInMemoryProcessedStore(0)
+		handler := &EventHandlerMock{HandleFunc: func(context.Context, cqs.Event) error { return nil }}
+		h := cqs.NewIdempotentEventHandler(handler, store)
+		ev := newBasicEvent("foo")
+
+		require.NoError(h.Handle(ctx, ev))
+		require.NoError(h.Handle(ctx, ev))
+		require.Len(handler.HandleCalls(), 1)
+
+		require.NoError(h.Handle(ctx, newBasicEvent("foo")))
+		require.Len(handler.HandleCalls(), 2)
+
+		other := cqs.NewIdempotentEventHandler(handler, store, cqs.IdempotentHandlerNameOpt("other"))
+		require.NoError(other.Handle(ctx, ev))
+		require.Len(handler.HandleCalls(), 3)
+	})
+
+	t.Run(`Given an idempotent event handler whose handler fails,
+	when the event is handled again,
+	then the decorated handler is called again`, func(t *testing.T) {
+		errHandler := errors.New("handler failed")
+		fail := true
+		handler := &EventHandlerMock{HandleFunc: func(context.Context, cqs.Event) error {
+			if fail {
+				return errHandler
+			}
+
+			return nil
+		}}
+		h := cqs.NewIdempotentEventHandler(handler, cqs.NewInMemoryProcessedStore(0))
+		ev := newBasicEvent("foo")
+
+		require.ErrorIs(h.Handle(ctx, ev), errHandler)
+
+		fail = false
+
+		require.NoError(h.Handle(ctx, ev))
+		require.NoError(h.Handle(ctx, ev))
+		require.Len(handler.HandleCalls(), 2)
+	})
+}
+
+func TestInMemoryProcessedStore(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+
+	t.Run(`Given an in-memory processed store with a time to live,
+	when a record gets older than it,
+	then the event is not processed anymore`, func(t *testing.T) {
+		now := time.Now()
+		store := cqs.NewInMemoryProcessedStore(time.Minute, cqs.InMemoryProcessedStoreNowOpt(func() time.Time { return now }))
+		id := vo.NewID()
+
+		processed, err := store.IsProcessed(ctx, "handler", id)
+		require.NoError(err)
+		require.False(processed)
+
+		require.NoError(store.MarkProcessed(ctx, "handler", id))
+		require.ErrorIs(store.MarkProcessed(ctx, "handler", id), cqs.ErrEventAlreadyProcessed)
+
+		processed, err = store.IsProcessed(ctx, "handler", id)
+		require.NoError(err)
+		require.True(processed)
+
+		now = now.Add(time.Minute)
+
+		processed, err = store.IsProcessed(ctx, "handler", id)
+		require.NoError(err)
+		require.False(processed)
+		require.NoError(store.MarkProcessed(ctx, "handler", id))
+	})
+}
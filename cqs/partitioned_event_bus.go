@@ -0,0 +1,157 @@
+package cqs
+
+import (
+	"context"
+	"hash/fnv"
+	"sync/atomic"
+)
+
+const (
+	defaultPartitionedLanes      = 4
+	defaultPartitionedQueueDepth = 100
+)
+
+// PartitionedEventsBusOpt is the common type of functions that set options on PartitionedEventsBus construction.
+type PartitionedEventsBusOpt func(bus *PartitionedEventsBus)
+
+// PartitionedEventsBusLanesOpt sets the number of lanes. The default is 4.
+func PartitionedEventsBusLanesOpt(lanes int) PartitionedEventsBusOpt {
+	return func(bus *PartitionedEventsBus) {
+		if lanes > 0 {
+			bus.laneCount = lanes
+		}
+	}
+}
+
+// PartitionedEventsBusQueueDepthOpt sets the size of the queue of each lane. The default is 100.
+func PartitionedEventsBusQueueDepthOpt(depth int) PartitionedEventsBusOpt {
+	return func(bus *PartitionedEventsBus) {
+		if depth >= 0 {
+			bus.queueDepth = depth
+		}
+	}
+}
+
+// PartitionedEventsBusBackPressureOpt sets the policy applied when a lane is full. The default is BackPressureBlock.
+func PartitionedEventsBusBackPressureOpt(policy BackPressurePolicy) PartitionedEventsBusOpt {
+	return func(bus *PartitionedEventsBus) {
+		bus.policy = policy
+	}
+}
+
+// PartitionedEventsBusErrorSinkOpt sets the sink that receives the handler errors. By default, they are discarded.
+func PartitionedEventsBusErrorSinkOpt(sink ErrorSink) PartitionedEventsBusOpt {
+	return func(bus *PartitionedEventsBus) {
+		if sink != nil {
+			bus.sink = sink
+		}
+	}
+}
+
+// PartitionedEventsBusDelegateOpt sets the bus that holds the subscriptions and calls the handlers.
+// The default is a ConcurrentEventsBus.
+func PartitionedEventsBusDelegateOpt(delegate EventsBus) PartitionedEventsBusOpt {
+	return func(bus *PartitionedEventsBus) {
+		if delegate != nil {
+			bus.delegate = delegate
+		}
+	}
+}
+
+// LaneStats is a snapshot of the state of a PartitionedEventsBus lane.
+type LaneStats struct {
+	Lane     int
+	Depth    int
+	Capacity int
+	Handled  uint64
+}
+
+var _ EventsBus = &PartitionedEventsBus{}
+
+// PartitionedEventsBus is an EventsBus that handles the events asynchronously in lanes selected by their
+// aggregate root ID. Events of the same aggregate are always handled in order, one at a time, while events of
+// different aggregates can be handled in parallel. Handler errors are reported to the error sink.
+type PartitionedEventsBus struct {
+	delegate   EventsBus
+	sink       ErrorSink
+	policy     BackPressurePolicy
+	laneCount  int
+	queueDepth int
+
+	queues *eventQueues
+	// handled counts the events handled in each lane.
+	handled []uint64
+}
+
+// NewPartitionedEventsBus is a constructor. It starts a worker per lane, which run until Shutdown is called.
+func NewPartitionedEventsBus(opts ...PartitionedEventsBusOpt) *PartitionedEventsBus {
+	bus := &PartitionedEventsBus{
+		delegate:   &ConcurrentEventsBus{},
+		sink:       noopErrorSink{},
+		policy:     BackPressureBlock,
+		laneCount:  defaultPartitionedLanes,
+		queueDepth: defaultPartitionedQueueDepth,
+	}
+	for _, opt := range opts {
+		opt(bus)
+	}
+
+	bus.queues = newEventQueues(bus.laneCount, bus.queueDepth, bus.policy, bus.sink)
+	bus.handled = make([]uint64, bus.laneCount)
+
+	for i := 0; i < bus.laneCount; i++ {
+		i := i
+		bus.queues.consume(i, func(qe queuedEvent) { bus.handle(i, qe) })
+	}
+
+	return bus
+}
+
+// Subscribe links a specific event with its handler.
+func (bus *PartitionedEventsBus) Subscribe(name EventName, handler EventHandler) error {
+	return bus.delegate.Subscribe(name, handler)
+}
+
+// Dispatch enqueues the event in the lane of its aggregate and returns without waiting for its handlers.
+// The handlers receive a context with the values of ctx but not its cancellation.
+// When the bus is shut down while Dispatch waits for room in the lane, it returns an ErrEventsBusClosed.
+func (bus *PartitionedEventsBus) Dispatch(ctx context.Context, ev Event) error {
+	return bus.queues.send(ctx, bus.LaneOf(ev), ev)
+}
+
+// LaneOf returns the lane where the event is handled.
+func (bus *PartitionedEventsBus) LaneOf(ev Event) int {
+	id := ev.EventAggregateRootID()
+	h := fnv.New32a()
+	_, _ = h.Write(id[:])
+
+	return int(h.Sum32() % uint32(bus.laneCount))
+}
+
+// Stats returns the current state of each lane.
+func (bus *PartitionedEventsBus) Stats() []LaneStats {
+	stats := make([]LaneStats, bus.laneCount)
+	for i, queue := range bus.queues.queues {
+		stats[i] = LaneStats{
+			Lane:     i,
+			Depth:    len(queue),
+			Capacity: cap(queue),
+			Handled:  atomic.LoadUint64(&bus.handled[i]),
+		}
+	}
+
+	return stats
+}
+
+// Shutdown stops accepting events and waits until the queued ones are handled or the context is done.
+func (bus *PartitionedEventsBus) Shutdown(ctx context.Context) error {
+	return bus.queues.shutdown(ctx)
+}
+
+func (bus *PartitionedEventsBus) handle(lane int, qe queuedEvent) {
+	if err := bus.delegate.Dispatch(qe.ctx, qe.ev); err != nil {
+		bus.sink.HandleError(qe.ctx, qe.ev, err)
+	}
+
+	atomic.AddUint64(&bus.handled[lane], 1)
+}
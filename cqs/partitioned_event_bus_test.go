@@ -0,0 +1,165 @@
+package cqs_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+func TestPartitionedEventsBusDispatch(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+	eventName := cqs.EventName("foo")
+
+	newEvent := func(aggID vo.ID, version int) cqs.BasicEvent {
+		return cqs.BasicEvent{
+			ID:              vo.NewID(),
+			Name:            eventName,
+			AggregateRootID: aggID,
+			Version:         cqs.EventVersion(version),
+		}
+	}
+
+	t.Run(`Given a partitioned events bus,
+	when events of several aggregates are dispatched,
+	then the events of each aggregate are handled in order`, func(t *testing.T) {
+		var mu sync.Mutex
+
+		handled := make(map[vo.ID][]cqs.EventVersion)
+		bus := cqs.NewPartitionedEventsBus(cqs.PartitionedEventsBusLanesOpt(3), cqs.PartitionedEventsBusQueueDepthOpt(5))
+		require.NoError(bus.Subscribe(eventName, &EventHandlerMock{
+			HandleFunc: func(_ context.Context, ev cqs.Event) error {
+				mu.Lock()
+				defer mu.Unlock()
+
+				handled[ev.EventAggregateRootID()] = append(handled[ev.EventAggregateRootID()], ev.EventVersion())
+
+				return nil
+			},
+		}))
+
+		const (
+			aggregates = 10
+			versions   = 20
+		)
+
+		aggIDs := make([]vo.ID, aggregates)
+		for i := range aggIDs {
+			aggIDs[i] = vo.NewID()
+		}
+
+		for v := 1; v <= versions; v++ {
+			for _, aggID := range aggIDs {
+				require.NoError(bus.Dispatch(ctx, newEvent(aggID, v)))
+			}
+		}
+
+		require.NoError(bus.Shutdown(ctx))
+		require.Len(handled, aggregates)
+
+		for _, aggID := range aggIDs {
+			require.Len(handled[aggID], versions)
+
+			for i, v := range handled[aggID] {
+				require.Equal(cqs.EventVersion(i+1), v)
+			}
+		}
+
+		var total uint64
+		for _, s := range bus.Stats() {
+			require.Equal(0, s.Depth)
+			require.Equal(5, s.Capacity)
+			total += s.Handled
+		}
+
+		require.Equal(uint64(aggregates*versions), total)
+	})
+
+	t.Run(`Given a partitioned events bus,
+	when two aggregates in different lanes are dispatched,
+	then they are handled in parallel`, func(t *testing.T) {
+		bus := cqs.NewPartitionedEventsBus(cqs.PartitionedEventsBusLanesOpt(2))
+
+		aggA := vo.NewID()
+		aggB := vo.NewID()
+
+		for bus.LaneOf(newEvent(aggA, 1)) == bus.LaneOf(newEvent(aggB, 1)) {
+			aggB = vo.NewID()
+		}
+
+		bHandled := make(chan struct{})
+		require.NoError(bus.Subscribe(eventName, &EventHandlerMock{
+			HandleFunc: func(_ context.Context, ev cqs.Event) error {
+				if ev.EventAggregateRootID() == aggA {
+					// It would block forever if B were handled after A in the same lane.
+					<-bHandled
+					return nil
+				}
+
+				close(bHandled)
+
+				return nil
+			},
+		}))
+
+		require.NoError(bus.Dispatch(ctx, newEvent(aggA, 1)))
+		require.NoError(bus.Dispatch(ctx, newEvent(aggB, 1)))
+		require.NoError(bus.Shutdown(ctx))
+		require.ErrorIs(bus.Dispatch(ctx, newEvent(aggA, 2)), cqs.ErrEventsBusClosed)
+	})
+
+	t.Run(`Given a partitioned events bus,
+	when the same aggregate is dispatched several times,
+	then it always goes to the same lane`, func(t *testing.T) {
+		bus := cqs.NewPartitionedEventsBus(cqs.PartitionedEventsBusLanesOpt(8))
+		aggID := vo.NewID()
+
+		lane := bus.LaneOf(newEvent(aggID, 1))
+		require.Equal(lane, bus.LaneOf(newEvent(aggID, 2)))
+		require.Less(lane, 8)
+		require.Len(bus.Stats(), 8)
+		require.NoError(bus.Shutdown(ctx))
+	})
+
+	t.Run(`Given a partitioned events bus and a handler that dispatches into its full lane,
+	when Shutdown is called,
+	then the blocked Dispatch returns an ErrEventsBusClosed and the queued events are handled`, func(t *testing.T) {
+		var calls int
+
+		blocked := make(chan struct{})
+		dispatchErr := make(chan error, 1)
+		bus := cqs.NewPartitionedEventsBus(cqs.PartitionedEventsBusLanesOpt(1), cqs.PartitionedEventsBusQueueDepthOpt(1))
+		ev := newEvent(vo.NewID(), 1)
+
+		require.NoError(bus.Subscribe(eventName, cqs.EventHandlerFunc(func(ctx context.Context, ev cqs.Event) error {
+			calls++
+			if calls > 1 {
+				return nil
+			}
+
+			require.NoError(bus.Dispatch(ctx, ev))
+			close(blocked)
+			dispatchErr <- bus.Dispatch(ctx, ev)
+
+			return nil
+		})))
+
+		require.NoError(bus.Dispatch(ctx, ev))
+		<-blocked
+		time.Sleep(10 * time.Millisecond)
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+
+		require.NoError(bus.Shutdown(timeoutCtx))
+		require.ErrorIs(<-dispatchErr, cqs.ErrEventsBusClosed)
+		require.Equal(2, calls)
+	})
+}
@@ -0,0 +1,483 @@
+package cqs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProjectionBatchSize    = 100
+	defaultProjectionPollInterval = time.Second
+	defaultProjectionGapTimeout   = 5 * time.Second
+)
+
+var (
+	ErrEmptyProjectionName                = errors.New("empty projection name")
+	ErrProjectionAlreadyRegistered        = errors.New("projection already registered")
+	ErrProjectionNotFound                 = errors.New("projection not found")
+	ErrProjectionHandlerAlreadyRegistered = errors.New("projection handler already registered")
+)
+
+// OrderedEventSource reads the events of every stream in the order they were appended, with their global position.
+// InMemoryEventStore and SQLEventStore implement it.
+type OrderedEventSource interface {
+	// LoadBatch returns at most limit events from the given position, included.
+	// A limit that isn't positive is rejected with ErrInvalidBatchLimit.
+	LoadBatch(ctx context.Context, fromPosition uint64, limit int) ([]StoredEvent, error)
+	// LastPosition returns the position of the last event, or 0 if there isn't any.
+	LastPosition(ctx context.Context) (uint64, error)
+}
+
+var (
+	_ OrderedEventSource = &InMemoryEventStore{}
+	_ OrderedEventSource = &SQLEventStore{}
+)
+
+// CheckpointStore keeps the position of the last event applied by each projection.
+type CheckpointStore interface {
+	// Load returns the checkpoint of the projection, or 0 if it has none.
+	Load(ctx context.Context, projection string) (uint64, error)
+	Save(ctx context.Context, projection string, position uint64) error
+}
+
+var _ CheckpointStore = &InMemoryCheckpointStore{}
+
+// InMemoryCheckpointStore is a concurrent-safe CheckpointStore that keeps the checkpoints in memory.
+// Its zero value is ready to use.
+type InMemoryCheckpointStore struct {
+	mu          sync.RWMutex
+	checkpoints map[string]uint64
+}
+
+// Load is the CheckpointStore interface implementation.
+func (s *InMemoryCheckpointStore) Load(_ context.Context, projection string) (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.checkpoints[projection], nil
+}
+
+// Save is the CheckpointStore interface implementation.
+func (s *InMemoryCheckpointStore) Save(_ context.Context, projection string, position uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.checkpoints == nil {
+		s.checkpoints = make(map[string]uint64)
+	}
+
+	s.checkpoints[projection] = position
+
+	return nil
+}
+
+// ProjectionOpt is the common type of functions that set options on Projection construction.
+type ProjectionOpt func(p *Projection)
+
+// ProjectionHandlerOpt sets the handler that applies the events with the given name to the read model.
+func ProjectionHandlerOpt(name EventName, h EventHandler) ProjectionOpt {
+	return func(p *Projection) {
+		if h == nil {
+			p.err = fmt.Errorf("%w: %s", ErrEmptyEventHandler, name)
+
+			return
+		}
+
+		if _, ok := p.handlers[name]; ok {
+			p.err = fmt.Errorf("%w: %s", ErrProjectionHandlerAlreadyRegistered, name)
+		}
+
+		p.handlers[name] = h
+	}
+}
+
+// ProjectionResetOpt sets the function that clears the read model before a rebuild.
+func ProjectionResetOpt(reset func(ctx context.Context) error) ProjectionOpt {
+	return func(p *Projection) {
+		p.reset = reset
+	}
+}
+
+// Projection builds a read model applying the events with a handler registered, in order.
+// The rest of the events are skipped.
+type Projection struct {
+	name     string
+	handlers map[EventName]EventHandler
+	reset    func(ctx context.Context) error
+	err      error
+}
+
+// NewProjection is a constructor.
+func NewProjection(name string, opts ...ProjectionOpt) *Projection {
+	p := &Projection{
+		name:     name,
+		handlers: make(map[EventName]EventHandler),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Name returns the name of the projection, which identifies its checkpoint.
+func (p *Projection) Name() string {
+	return p.name
+}
+
+// ProjectionRunnerOpt is the common type of functions that set options on ProjectionRunner construction.
+type ProjectionRunnerOpt func(r *ProjectionRunner)
+
+// ProjectionRunnerBatchSizeOpt sets the maximum number of events read at once. The default is 100.
+func ProjectionRunnerBatchSizeOpt(size int) ProjectionRunnerOpt {
+	return func(r *ProjectionRunner) {
+		if size > 0 {
+			r.batchSize = size
+		}
+	}
+}
+
+// ProjectionRunnerPollIntervalOpt sets the time Run waits for new events once the projections are up to date.
+// The default is 1s.
+func ProjectionRunnerPollIntervalOpt(interval time.Duration) ProjectionRunnerOpt {
+	return func(r *ProjectionRunner) {
+		if interval > 0 {
+			r.pollInterval = interval
+		}
+	}
+}
+
+// ProjectionRunnerGapTimeoutOpt sets how long a projection waits for a missing position before skipping it,
+// counted from the time of the event that follows it. A zero timeout skips the gaps at once. The default is 5s.
+func ProjectionRunnerGapTimeoutOpt(timeout time.Duration) ProjectionRunnerOpt {
+	return func(r *ProjectionRunner) {
+		if timeout >= 0 {
+			r.gapTimeout = timeout
+		}
+	}
+}
+
+// ProjectionRunnerSleeperOpt sets the Sleeper used to wait between polls. It's useful for testing purposes.
+func ProjectionRunnerSleeperOpt(sleeper Sleeper) ProjectionRunnerOpt {
+	return func(r *ProjectionRunner) {
+		if sleeper != nil {
+			r.sleeper = sleeper
+		}
+	}
+}
+
+// ProjectionRunnerNowOpt sets the clock used to time the gaps. It's useful for testing purposes.
+func ProjectionRunnerNowOpt(now func() time.Time) ProjectionRunnerOpt {
+	return func(r *ProjectionRunner) {
+		if now != nil {
+			r.now = now
+		}
+	}
+}
+
+// ProjectionRunnerErrorSinkOpt sets the sink that receives the errors of Run. By default, they are discarded.
+func ProjectionRunnerErrorSinkOpt(sink ErrorSink) ProjectionRunnerOpt {
+	return func(r *ProjectionRunner) {
+		if sink != nil {
+			r.sink = sink
+		}
+	}
+}
+
+// ProjectionRunner feeds the projections with the events of an OrderedEventSource, from their checkpoints.
+// The checkpoint is saved once each batch of events is applied, or up to the last one applied when a handler fails,
+// so after a crash the events since the last checkpoint are applied again: the handlers must be idempotent.
+//
+// The positions of an SQLEventStore can be committed out of order, so a projection stops before a missing position
+// until it shows up or the gap timeout runs out, as it may belong to a rolled back transaction. Only the gaps
+// followed by recent events are waited on, and the expired ones are remembered, so the holes left by the
+// transactions that failed don't stall the projections on every restart or rebuild.
+type ProjectionRunner struct {
+	source       OrderedEventSource
+	checkpoints  CheckpointStore
+	batchSize    int
+	pollInterval time.Duration
+	gapTimeout   time.Duration
+	sleeper      Sleeper
+	now          func() time.Time
+	sink         ErrorSink
+
+	// runMu serializes the runs, so each projection is fed by a single goroutine.
+	runMu       sync.Mutex
+	mu          sync.RWMutex
+	projections map[string]*Projection
+	names       []string
+	// gaps keeps the first missing position found by each projection and when it was found, and holes the missing
+	// positions that expired. They are guarded by runMu.
+	gaps  map[string]projectionGap
+	holes map[uint64]struct{}
+}
+
+type projectionGap struct {
+	position uint64
+	since    time.Time
+}
+
+// NewProjectionRunner is a constructor.
+func NewProjectionRunner(source OrderedEventSource, checkpoints CheckpointStore, opts ...ProjectionRunnerOpt) *ProjectionRunner {
+	r := &ProjectionRunner{
+		source:       source,
+		checkpoints:  checkpoints,
+		batchSize:    defaultProjectionBatchSize,
+		pollInterval: defaultProjectionPollInterval,
+		gapTimeout:   defaultProjectionGapTimeout,
+		sleeper:      timerSleeper{},
+		now:          time.Now,
+		sink:         noopErrorSink{},
+		projections:  make(map[string]*Projection),
+		gaps:         make(map[string]projectionGap),
+		holes:        make(map[uint64]struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Register adds the projection to the runner.
+func (r *ProjectionRunner) Register(p *Projection) error {
+	if p.name == "" {
+		return ErrEmptyProjectionName
+	}
+
+	if p.err != nil {
+		return fmt.Errorf("projection %s: %w", p.name, p.err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.projections[p.name]; ok {
+		return fmt.Errorf("%w: %s", ErrProjectionAlreadyRegistered, p.name)
+	}
+
+	r.projections[p.name] = p
+	r.names = append(r.names, p.name)
+
+	return nil
+}
+
+// RunOnce applies the pending events to every projection, in the order they were registered.
+// It stops at the first failure.
+func (r *ProjectionRunner) RunOnce(ctx context.Context) error {
+	_, err := r.runOnce(ctx)
+
+	return err
+}
+
+// Run applies the pending events until the context is done, and returns its error.
+func (r *ProjectionRunner) Run(ctx context.Context) error {
+	for {
+		if ev, err := r.runOnce(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			r.sink.HandleError(ctx, ev, err)
+		}
+
+		if err := r.sleeper.Sleep(ctx, r.pollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// Rebuild resets the read model of the projection and applies every event from the beginning, up to the last
+// one when it starts. It waits for the gaps like Run, and returns the error of the context if it's done before.
+func (r *ProjectionRunner) Rebuild(ctx context.Context, name string) error {
+	r.runMu.Lock()
+	defer r.runMu.Unlock()
+
+	p, err := r.projection(name)
+	if err != nil {
+		return err
+	}
+
+	if p.reset != nil {
+		if err := p.reset(ctx); err != nil {
+			return fmt.Errorf("reset projection %s: %w", name, err)
+		}
+	}
+
+	if err := r.checkpoints.Save(ctx, name, 0); err != nil {
+		return fmt.Errorf("reset checkpoint of projection %s: %w", name, err)
+	}
+
+	delete(r.gaps, name)
+
+	last, err := r.source.LastPosition(ctx)
+	if err != nil {
+		return err
+	}
+
+	for {
+		if _, err := r.catchUp(ctx, p); err != nil {
+			return err
+		}
+
+		checkpoint, err := r.checkpoints.Load(ctx, name)
+		if err != nil {
+			return fmt.Errorf("load checkpoint of projection %s: %w", name, err)
+		}
+
+		if checkpoint >= last {
+			return nil
+		}
+
+		if err := r.sleeper.Sleep(ctx, r.pollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// Lag returns how many positions the projection is behind the last event.
+func (r *ProjectionRunner) Lag(ctx context.Context, name string) (uint64, error) {
+	if _, err := r.projection(name); err != nil {
+		return 0, err
+	}
+
+	checkpoint, err := r.checkpoints.Load(ctx, name)
+	if err != nil {
+		return 0, fmt.Errorf("load checkpoint of projection %s: %w", name, err)
+	}
+
+	last, err := r.source.LastPosition(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if last < checkpoint {
+		return 0, nil
+	}
+
+	return last - checkpoint, nil
+}
+
+// runOnce returns the event that failed, if any, along with the error.
+func (r *ProjectionRunner) runOnce(ctx context.Context) (Event, error) {
+	r.runMu.Lock()
+	defer r.runMu.Unlock()
+
+	r.mu.RLock()
+	projections := make([]*Projection, len(r.names))
+	for i, name := range r.names {
+		projections[i] = r.projections[name]
+	}
+	r.mu.RUnlock()
+
+	for _, p := range projections {
+		if ev, err := r.catchUp(ctx, p); err != nil {
+			return ev, err
+		}
+	}
+
+	return nil, nil
+}
+
+// catchUp applies the events after the checkpoint of the projection, batch by batch, until a missing position.
+func (r *ProjectionRunner) catchUp(ctx context.Context, p *Projection) (Event, error) {
+	checkpoint, err := r.checkpoints.Load(ctx, p.name)
+	if err != nil {
+		return nil, fmt.Errorf("load checkpoint of projection %s: %w", p.name, err)
+	}
+
+	for {
+		events, err := r.source.LoadBatch(ctx, checkpoint+1, r.batchSize)
+		if err != nil {
+			return nil, fmt.Errorf("load events of projection %s: %w", p.name, err)
+		}
+
+		position := checkpoint
+
+		for _, stored := range events {
+			if stored.Position > position+1 && !r.gapExpired(p.name, position+1, stored.Event) {
+				return nil, r.save(ctx, p.name, checkpoint, position, nil)
+			}
+
+			if h, ok := p.handlers[stored.Event.EventName()]; ok {
+				if err := h.Handle(ctx, stored.Event); err != nil {
+					err = fmt.Errorf("projection %s at position %d: %w", p.name, stored.Position, err)
+
+					return stored.Event, r.save(ctx, p.name, checkpoint, position, err)
+				}
+			}
+
+			position = stored.Position
+		}
+
+		if err := r.save(ctx, p.name, checkpoint, position, nil); err != nil || len(events) < r.batchSize {
+			return nil, err
+		}
+
+		checkpoint = position
+	}
+}
+
+// gapExpired reports whether the position is a hole that expired already, or has been missing for the gap timeout
+// since the event that follows it, next, or since the projection found it. The first time it's missing, the time
+// is recorded.
+func (r *ProjectionRunner) gapExpired(name string, position uint64, next Event) bool {
+	if r.gapTimeout == 0 {
+		return true
+	}
+
+	if _, ok := r.holes[position]; ok {
+		return true
+	}
+
+	now := r.now()
+
+	gap, ok := r.gaps[name]
+	if !ok || gap.position != position {
+		gap = projectionGap{position: position, since: now}
+		r.gaps[name] = gap
+	}
+
+	if now.Sub(time.Time(next.EventAt())) < r.gapTimeout && now.Sub(gap.since) < r.gapTimeout {
+		return false
+	}
+
+	delete(r.gaps, name)
+	r.holes[position] = struct{}{}
+
+	return true
+}
+
+// save saves the checkpoint if it has changed, and returns the cause, if any, along with the save error.
+func (r *ProjectionRunner) save(ctx context.Context, name string, previous, position uint64, cause error) error {
+	if position == previous {
+		return cause
+	}
+
+	err := r.checkpoints.Save(withoutCancel(ctx), name, position)
+
+	switch {
+	case err == nil:
+		return cause
+	case cause == nil:
+		return fmt.Errorf("save checkpoint of projection %s: %w", name, err)
+	default:
+		return fmt.Errorf("%w; save checkpoint: %s", cause, err)
+	}
+}
+
+func (r *ProjectionRunner) projection(name string) (*Projection, error) {
+	r.mu.RLock()
+	p, ok := r.projections[name]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrProjectionNotFound, name)
+	}
+
+	return p, nil
+}
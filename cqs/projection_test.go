@@ -0,0 +1,471 @@
+package cqs_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+// balances is a read model with the balance of each account.
+type balances struct {
+	mu       sync.Mutex
+	byID     map[vo.ID]int
+	failOn   int
+	handling int
+}
+
+func newBalances() *balances {
+	return &balances{byID: make(map[vo.ID]int)}
+}
+
+func (b *balances) projection(name string) *cqs.Projection {
+	return cqs.NewProjection(name,
+		cqs.ProjectionHandlerOpt(accountOpenedName, cqs.EventHandlerFunc(func(_ context.Context, ev cqs.Event) error {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			b.byID[ev.EventAggregateRootID()] = 0
+
+			return nil
+		})),
+		cqs.ProjectionHandlerOpt(moneyDepositedName, cqs.EventHandlerFunc(func(_ context.Context, ev cqs.Event) error {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			b.handling++
+			if b.handling == b.failOn {
+				return errors.New("projection failed")
+			}
+
+			b.byID[ev.EventAggregateRootID()] += ev.(*moneyDeposited).Amount
+
+			return nil
+		})),
+		cqs.ProjectionResetOpt(func(context.Context) error {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			b.byID = make(map[vo.ID]int)
+
+			return nil
+		}),
+	)
+}
+
+func (b *balances) balance(id vo.ID) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.byID[id]
+}
+
+// eventSource is an OrderedEventSource with the given events, which may have gaps, that records the batches read.
+type eventSource struct {
+	events []cqs.StoredEvent
+	froms  []uint64
+}
+
+func (s *eventSource) LoadBatch(_ context.Context, fromPosition uint64, limit int) ([]cqs.StoredEvent, error) {
+	if limit <= 0 {
+		return nil, cqs.ErrInvalidBatchLimit
+	}
+
+	s.froms = append(s.froms, fromPosition)
+
+	var events []cqs.StoredEvent
+
+	for _, stored := range s.events {
+		if stored.Position >= fromPosition && len(events) < limit {
+			events = append(events, stored)
+		}
+	}
+
+	return events, nil
+}
+
+func (s *eventSource) LastPosition(context.Context) (uint64, error) {
+	if len(s.events) == 0 {
+		return 0, nil
+	}
+
+	return s.events[len(s.events)-1].Position, nil
+}
+
+func TestProjectionRunnerRegister(t *testing.T) {
+	require := require.New(t)
+
+	handler := cqs.EventHandlerFunc(func(context.Context, cqs.Event) error { return nil })
+
+	t.Run(`Given a projection runner,
+	when invalid projections are registered,
+	then it returns an error`, func(t *testing.T) {
+		r := cqs.NewProjectionRunner(&cqs.InMemoryEventStore{}, &cqs.InMemoryCheckpointStore{})
+
+		require.ErrorIs(r.Register(cqs.NewProjection("")), cqs.ErrEmptyProjectionName)
+		require.ErrorIs(r.Register(cqs.NewProjection("foo", cqs.ProjectionHandlerOpt("bar", nil))), cqs.ErrEmptyEventHandler)
+		require.ErrorIs(r.Register(cqs.NewProjection("foo",
+			cqs.ProjectionHandlerOpt("bar", handler),
+			cqs.ProjectionHandlerOpt("bar", handler),
+		)), cqs.ErrProjectionHandlerAlreadyRegistered)
+
+		require.NoError(r.Register(cqs.NewProjection("foo", cqs.ProjectionHandlerOpt("bar", handler))))
+		require.ErrorIs(r.Register(cqs.NewProjection("foo")), cqs.ErrProjectionAlreadyRegistered)
+
+		_, err := r.Lag(context.Background(), "unknown")
+		require.ErrorIs(err, cqs.ErrProjectionNotFound)
+		require.ErrorIs(r.Rebuild(context.Background(), "unknown"), cqs.ErrProjectionNotFound)
+	})
+}
+
+func TestProjectionRunner(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+
+	appendDeposits := func(t *testing.T, store cqs.EventStore, acc *account, amounts ...int) {
+		t.Helper()
+
+		for _, amount := range amounts {
+			require.NoError(acc.Deposit(amount))
+		}
+
+		require.NoError(store.Append(ctx, acc.AggregateID(), acc.ExpectedVersion(), acc.PullEvents()...))
+	}
+
+	newOpenAccount := func(t *testing.T, store cqs.EventStore) *account {
+		t.Helper()
+
+		acc := newAccount(vo.NewID())
+		require.NoError(acc.Open("john"))
+		require.NoError(store.Append(ctx, acc.AggregateID(), 0, acc.PullEvents()...))
+
+		return acc
+	}
+
+	t.Run(`Given a projection runner with a projection,
+	when it runs,
+	then the projection applies the events after its checkpoint and reports its lag`, func(t *testing.T) {
+		var (
+			store       cqs.InMemoryEventStore
+			checkpoints cqs.InMemoryCheckpointStore
+		)
+
+		model := newBalances()
+		r := cqs.NewProjectionRunner(&store, &checkpoints)
+		require.NoError(r.Register(model.projection("balances")))
+
+		acc := newOpenAccount(t, &store)
+		appendDeposits(t, &store, acc, 1, 2)
+
+		lag, err := r.Lag(ctx, "balances")
+		require.NoError(err)
+		require.Equal(uint64(3), lag)
+
+		require.NoError(r.RunOnce(ctx))
+		require.Equal(3, model.balance(acc.AggregateID()))
+
+		checkpoint, err := checkpoints.Load(ctx, "balances")
+		require.NoError(err)
+		require.Equal(uint64(3), checkpoint)
+
+		appendDeposits(t, &store, acc, 4)
+
+		lag, err = r.Lag(ctx, "balances")
+		require.NoError(err)
+		require.Equal(uint64(1), lag)
+
+		require.NoError(r.RunOnce(ctx))
+		require.Equal(7, model.balance(acc.AggregateID()))
+
+		lag, err = r.Lag(ctx, "balances")
+		require.NoError(err)
+		require.Zero(lag)
+	})
+
+	t.Run(`Given a projection whose handler fails on an event,
+	when it runs again,
+	then it resumes from the failed event`, func(t *testing.T) {
+		var (
+			store       cqs.InMemoryEventStore
+			checkpoints cqs.InMemoryCheckpointStore
+		)
+
+		model := newBalances()
+		model.failOn = 2
+		r := cqs.NewProjectionRunner(&store, &checkpoints)
+		require.NoError(r.Register(model.projection("balances")))
+
+		acc := newOpenAccount(t, &store)
+		appendDeposits(t, &store, acc, 1, 2, 4)
+
+		require.Error(r.RunOnce(ctx))
+		require.Equal(1, model.balance(acc.AggregateID()))
+
+		lag, err := r.Lag(ctx, "balances")
+		require.NoError(err)
+		require.Equal(uint64(2), lag)
+
+		require.NoError(r.RunOnce(ctx))
+		require.Equal(7, model.balance(acc.AggregateID()))
+	})
+
+	t.Run(`Given a projection up to date,
+	when it's rebuilt,
+	then its read model is reset and every event is applied again`, func(t *testing.T) {
+		var (
+			store       cqs.InMemoryEventStore
+			checkpoints cqs.InMemoryCheckpointStore
+		)
+
+		model := newBalances()
+		r := cqs.NewProjectionRunner(&store, &checkpoints)
+		require.NoError(r.Register(model.projection("balances")))
+
+		acc := newOpenAccount(t, &store)
+		appendDeposits(t, &store, acc, 1, 2)
+		require.NoError(r.RunOnce(ctx))
+
+		require.NoError(r.Rebuild(ctx, "balances"))
+		require.Equal(3, model.balance(acc.AggregateID()))
+		require.Equal(4, model.handling)
+	})
+
+	t.Run(`Given a running projection runner with an SQL event store and checkpoint store,
+	when events are appended,
+	then the projection applies them`, func(t *testing.T) {
+		db := newSQLiteDB(t)
+		store, err := cqs.NewSQLEventStore(db, cqs.SQLiteDialect{}, newAccountEventRegistry(t))
+		require.NoError(err)
+		require.NoError(store.Migrate(ctx))
+
+		checkpoints := cqs.NewSQLCheckpointStore(db, cqs.SQLiteDialect{})
+		require.NoError(checkpoints.Migrate(ctx))
+
+		model := newBalances()
+		r := cqs.NewProjectionRunner(store, checkpoints, cqs.ProjectionRunnerPollIntervalOpt(time.Millisecond))
+		require.NoError(r.Register(model.projection("balances")))
+
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error)
+
+		go func() { done <- r.Run(runCtx) }()
+
+		acc := newOpenAccount(t, store)
+		appendDeposits(t, store, acc, 1, 2)
+
+		require.Eventually(func() bool { return model.balance(acc.AggregateID()) == 3 }, time.Second, time.Millisecond)
+		cancel()
+		require.ErrorIs(<-done, context.Canceled)
+
+		checkpoint, err := checkpoints.Load(ctx, "balances")
+		require.NoError(err)
+		require.Equal(uint64(3), checkpoint)
+	})
+
+	t.Run(`Given a projection runner with a batch size,
+	when it runs,
+	then the projection reads the events in batches`, func(t *testing.T) {
+		var (
+			store       cqs.InMemoryEventStore
+			checkpoints cqs.InMemoryCheckpointStore
+		)
+
+		acc := newOpenAccount(t, &store)
+		appendDeposits(t, &store, acc, 1, 2, 4, 8)
+
+		all, err := store.LoadAll(ctx, 1)
+		require.NoError(err)
+
+		source := &eventSource{events: all}
+		model := newBalances()
+		r := cqs.NewProjectionRunner(source, &checkpoints, cqs.ProjectionRunnerBatchSizeOpt(2))
+		require.NoError(r.Register(model.projection("balances")))
+
+		require.NoError(r.RunOnce(ctx))
+		require.Equal(15, model.balance(acc.AggregateID()))
+		require.Equal([]uint64{1, 3, 5}, source.froms)
+
+		checkpoint, err := checkpoints.Load(ctx, "balances")
+		require.NoError(err)
+		require.Equal(uint64(5), checkpoint)
+	})
+
+	t.Run(`Given events whose positions have a gap,
+	when the projection runs,
+	then it stops before the gap until the missing event shows up or the gap timeout runs out`, func(t *testing.T) {
+		var store cqs.InMemoryEventStore
+
+		acc := newOpenAccount(t, &store)
+		appendDeposits(t, &store, acc, 1, 2, 4)
+
+		all, err := store.LoadAll(ctx, 1)
+		require.NoError(err)
+
+		now := time.Now()
+		newRunner := func(source *eventSource) (*cqs.ProjectionRunner, *balances, *cqs.InMemoryCheckpointStore) {
+			checkpoints := &cqs.InMemoryCheckpointStore{}
+			model := newBalances()
+			r := cqs.NewProjectionRunner(source, checkpoints,
+				cqs.ProjectionRunnerGapTimeoutOpt(5*time.Second),
+				cqs.ProjectionRunnerNowOpt(func() time.Time { return now }),
+			)
+			require.NoError(r.Register(model.projection("balances")))
+
+			return r, model, checkpoints
+		}
+
+		late := &eventSource{events: []cqs.StoredEvent{all[0], all[2], all[3]}}
+		r, model, checkpoints := newRunner(late)
+
+		require.NoError(r.RunOnce(ctx))
+
+		checkpoint, err := checkpoints.Load(ctx, "balances")
+		require.NoError(err)
+		require.Equal(uint64(1), checkpoint)
+
+		late.events = all
+
+		require.NoError(r.RunOnce(ctx))
+		require.Equal(7, model.balance(acc.AggregateID()))
+
+		rolledBack := &eventSource{events: []cqs.StoredEvent{all[0], all[2], all[3]}}
+		r, model, checkpoints = newRunner(rolledBack)
+
+		require.NoError(r.RunOnce(ctx))
+
+		now = now.Add(4 * time.Second)
+
+		require.NoError(r.RunOnce(ctx))
+
+		checkpoint, err = checkpoints.Load(ctx, "balances")
+		require.NoError(err)
+		require.Equal(uint64(1), checkpoint)
+
+		now = now.Add(time.Second)
+
+		require.NoError(r.RunOnce(ctx))
+		require.Equal(6, model.balance(acc.AggregateID()))
+
+		checkpoint, err = checkpoints.Load(ctx, "balances")
+		require.NoError(err)
+		require.Equal(uint64(4), checkpoint)
+	})
+
+	t.Run(`Given events whose positions have a gap followed by old events,
+	when the projection runs,
+	then it skips the gap at once`, func(t *testing.T) {
+		var store cqs.InMemoryEventStore
+
+		acc := newOpenAccount(t, &store)
+		appendDeposits(t, &store, acc, 1, 2, 4)
+
+		all, err := store.LoadAll(ctx, 1)
+		require.NoError(err)
+
+		checkpoints := &cqs.InMemoryCheckpointStore{}
+		model := newBalances()
+		r := cqs.NewProjectionRunner(&eventSource{events: []cqs.StoredEvent{all[0], all[2], all[3]}}, checkpoints,
+			cqs.ProjectionRunnerNowOpt(func() time.Time { return time.Now().Add(time.Hour) }),
+		)
+		require.NoError(r.Register(model.projection("balances")))
+
+		require.NoError(r.RunOnce(ctx))
+		require.Equal(6, model.balance(acc.AggregateID()))
+	})
+
+	t.Run(`Given events whose positions have a recent gap,
+	when the projection is rebuilt,
+	then it waits until the gap expires, and the expired gap isn't waited on by the next rebuild`, func(t *testing.T) {
+		var store cqs.InMemoryEventStore
+
+		acc := newOpenAccount(t, &store)
+		appendDeposits(t, &store, acc, 1, 2, 4)
+
+		all, err := store.LoadAll(ctx, 1)
+		require.NoError(err)
+
+		var sleeps int
+
+		now := time.Now()
+		checkpoints := &cqs.InMemoryCheckpointStore{}
+		model := newBalances()
+		r := cqs.NewProjectionRunner(&eventSource{events: []cqs.StoredEvent{all[0], all[2], all[3]}}, checkpoints,
+			cqs.ProjectionRunnerGapTimeoutOpt(5*time.Second),
+			cqs.ProjectionRunnerNowOpt(func() time.Time { return now }),
+			cqs.ProjectionRunnerSleeperOpt(cqs.SleeperFunc(func(context.Context, time.Duration) error {
+				sleeps++
+				now = now.Add(time.Second)
+
+				return nil
+			})),
+		)
+		require.NoError(r.Register(model.projection("balances")))
+
+		require.NoError(r.Rebuild(ctx, "balances"))
+		require.Equal(6, model.balance(acc.AggregateID()))
+		require.Equal(5, sleeps)
+
+		now = now.Add(-time.Hour)
+
+		require.NoError(r.Rebuild(ctx, "balances"))
+		require.Equal(6, model.balance(acc.AggregateID()))
+		require.Equal(5, sleeps)
+	})
+
+	t.Run(`Given events whose positions have a recent gap,
+	when the projection is rebuilt and the context is done while it waits,
+	then the context error is returned`, func(t *testing.T) {
+		var store cqs.InMemoryEventStore
+
+		acc := newOpenAccount(t, &store)
+		appendDeposits(t, &store, acc, 1, 2, 4)
+
+		all, err := store.LoadAll(ctx, 1)
+		require.NoError(err)
+
+		rebuildCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		r := cqs.NewProjectionRunner(&eventSource{events: []cqs.StoredEvent{all[0], all[2], all[3]}}, &cqs.InMemoryCheckpointStore{},
+			cqs.ProjectionRunnerSleeperOpt(cqs.SleeperFunc(func(ctx context.Context, _ time.Duration) error {
+				cancel()
+
+				return ctx.Err()
+			})),
+		)
+		require.NoError(r.Register(newBalances().projection("balances")))
+
+		require.ErrorIs(r.Rebuild(rebuildCtx, "balances"), context.Canceled)
+	})
+
+	t.Run(`Given a running projection runner with a sleeper,
+	when it's up to date,
+	then it waits for the poll interval with the sleeper`, func(t *testing.T) {
+		var sleeps []time.Duration
+
+		runCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		r := cqs.NewProjectionRunner(&cqs.InMemoryEventStore{}, &cqs.InMemoryCheckpointStore{},
+			cqs.ProjectionRunnerPollIntervalOpt(time.Minute),
+			cqs.ProjectionRunnerSleeperOpt(cqs.SleeperFunc(func(ctx context.Context, d time.Duration) error {
+				sleeps = append(sleeps, d)
+				if len(sleeps) == 2 {
+					cancel()
+				}
+
+				return ctx.Err()
+			})),
+		)
+
+		require.ErrorIs(r.Run(runCtx), context.Canceled)
+		require.Equal([]time.Duration{time.Minute, time.Minute}, sleeps)
+	})
+}
@@ -0,0 +1,131 @@
+package cqs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrEmptyQuery                    = errors.New("empty query")
+	ErrEmptyQueryName                = errors.New("empty query name")
+	ErrEmptyQueryHandler             = errors.New("empty query handler")
+	ErrQueryHandlerAlreadyRegistered = errors.New("query handler already registered")
+	ErrQueryHandlerNotFound          = errors.New("query handler not found")
+	ErrInvalidQueryType              = errors.New("invalid query type")
+	ErrInvalidQueryResultType        = errors.New("invalid query result type")
+)
+
+// QueryBusOpt is the common type of functions that set options on QueryBus construction.
+type QueryBusOpt func(bus *QueryBus)
+
+// QueryBusMiddlewareOpt sets the middlewares applied to every handler registered in the bus.
+func QueryBusMiddlewareOpt(middlewares ...QueryHandlerMiddleware[Query, QueryResult]) QueryBusOpt {
+	return func(bus *QueryBus) {
+		bus.middlewares = append(bus.middlewares, middlewares...)
+	}
+}
+
+var _ QueryHandler[Query, QueryResult] = &QueryBus{}
+
+// QueryBus routes each query to the handler registered for its name.
+// It's safe to register handlers and ask queries concurrently.
+type QueryBus struct {
+	mu          sync.RWMutex
+	handlers    map[string]QueryHandler[Query, QueryResult]
+	middlewares []QueryHandlerMiddleware[Query, QueryResult]
+}
+
+// NewQueryBus is a constructor.
+func NewQueryBus(opts ...QueryBusOpt) *QueryBus {
+	bus := &QueryBus{
+		handlers: make(map[string]QueryHandler[Query, QueryResult]),
+	}
+	for _, opt := range opts {
+		opt(bus)
+	}
+
+	return bus
+}
+
+// Register links a query name with its handler. The bus middlewares are applied to the handler.
+func (bus *QueryBus) Register(name string, handler QueryHandler[Query, QueryResult]) error {
+	if name == "" {
+		return ErrEmptyQueryName
+	}
+
+	if handler == nil {
+		return ErrEmptyQueryHandler
+	}
+
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	if bus.handlers == nil {
+		bus.handlers = make(map[string]QueryHandler[Query, QueryResult])
+	}
+
+	if _, ok := bus.handlers[name]; ok {
+		return fmt.Errorf("%w: %s", ErrQueryHandlerAlreadyRegistered, name)
+	}
+
+	bus.handlers[name] = QueryHandlerMultiMiddleware(bus.middlewares...)(handler)
+
+	return nil
+}
+
+// Handle calls the handler registered for the query and returns its untyped result.
+func (bus *QueryBus) Handle(ctx context.Context, query Query) (QueryResult, error) {
+	if query == nil {
+		return nil, ErrEmptyQuery
+	}
+
+	bus.mu.RLock()
+	handler, ok := bus.handlers[query.QueryName()]
+	bus.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrQueryHandlerNotFound, query.QueryName())
+	}
+
+	return handler.Handle(ctx, query)
+}
+
+// RegisterQueryHandler links a query name with a typed query handler.
+func RegisterQueryHandler[Q Query, R QueryResult](bus *QueryBus, name string, handler QueryHandler[Q, R]) error {
+	if handler == nil {
+		return ErrEmptyQueryHandler
+	}
+
+	return bus.Register(name, queryHandlerFunc[Query, QueryResult](func(ctx context.Context, query Query) (QueryResult, error) {
+		q, ok := query.(Q)
+		if !ok {
+			return nil, fmt.Errorf("%w: expected %s, got %T", ErrInvalidQueryType, typeName[Q](), query)
+		}
+
+		return handler.Handle(ctx, q)
+	}))
+}
+
+// Ask sends the query to the bus and returns its result as R.
+// It returns an ErrInvalidQueryResultType when the registered handler result is not an R.
+func Ask[Q Query, R QueryResult](ctx context.Context, bus *QueryBus, query Q) (R, error) {
+	var result R
+
+	res, err := bus.Handle(ctx, query)
+	if err != nil {
+		return result, err
+	}
+
+	if res == nil {
+		return result, nil
+	}
+
+	result, ok := res.(R)
+	if !ok {
+		return result, fmt.Errorf("%w: query %s expected %s, got %T", ErrInvalidQueryResultType, query.QueryName(), typeName[R](), res)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,150 @@
+package cqs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+)
+
+type helloQuery struct {
+	ID string
+}
+
+func (helloQuery) QueryName() string {
+	return "hello_query"
+}
+
+type helloQueryResult struct {
+	Greeting string
+}
+
+func TestQueryBusRegister(t *testing.T) {
+	require := require.New(t)
+
+	t.Run(`Given a query bus,
+	when Register is called with an empty query name,
+	then it returns an ErrEmptyQueryName`, func(t *testing.T) {
+		bus := cqs.NewQueryBus()
+
+		err := bus.Register("", &QueryHandlerMock[cqs.Query, cqs.QueryResult]{})
+		require.ErrorIs(err, cqs.ErrEmptyQueryName)
+	})
+
+	t.Run(`Given a query bus,
+	when Register is called with an empty query handler,
+	then it returns an ErrEmptyQueryHandler`, func(t *testing.T) {
+		bus := cqs.NewQueryBus()
+
+		err := bus.Register("foo", nil)
+		require.ErrorIs(err, cqs.ErrEmptyQueryHandler)
+	})
+
+	t.Run(`Given a query bus with a registered handler,
+	when Register is called with the same query name,
+	then it returns an ErrQueryHandlerAlreadyRegistered`, func(t *testing.T) {
+		bus := cqs.NewQueryBus()
+
+		err := cqs.RegisterQueryHandler[helloQuery, helloQueryResult](bus, "foo", &QueryHandlerMock[helloQuery, helloQueryResult]{})
+		require.NoError(err)
+
+		err = bus.Register("foo", &QueryHandlerMock[cqs.Query, cqs.QueryResult]{})
+		require.ErrorIs(err, cqs.ErrQueryHandlerAlreadyRegistered)
+	})
+}
+
+func TestAsk(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+	query := helloQuery{ID: "some-id"}
+
+	t.Run(`Given a query bus,
+	when Ask is called with a query without handler,
+	then it returns an ErrQueryHandlerNotFound`, func(t *testing.T) {
+		bus := cqs.NewQueryBus()
+
+		_, err := cqs.Ask[helloQuery, helloQueryResult](ctx, bus, query)
+		require.ErrorIs(err, cqs.ErrQueryHandlerNotFound)
+	})
+
+	t.Run(`Given a query bus with a handler that returns error,
+	when Ask is called,
+	then it returns the error`, func(t *testing.T) {
+		expectedErr := errors.New("query handler error")
+		bus := cqs.NewQueryBus()
+
+		err := cqs.RegisterQueryHandler[helloQuery, helloQueryResult](bus, query.QueryName(), &QueryHandlerMock[helloQuery, helloQueryResult]{
+			HandleFunc: func(context.Context, helloQuery) (helloQueryResult, error) {
+				return helloQueryResult{}, expectedErr
+			},
+		})
+		require.NoError(err)
+
+		_, err = cqs.Ask[helloQuery, helloQueryResult](ctx, bus, query)
+		require.ErrorIs(err, expectedErr)
+	})
+
+	t.Run(`Given a query bus with middlewares and a typed handler,
+	when Ask is called,
+	then the middlewares wrap the handler and it returns the typed result`, func(t *testing.T) {
+		var mwExecutionCount int
+
+		mw := func(h cqs.QueryHandler[cqs.Query, cqs.QueryResult]) cqs.QueryHandler[cqs.Query, cqs.QueryResult] {
+			return &QueryHandlerMock[cqs.Query, cqs.QueryResult]{
+				HandleFunc: func(ctx context.Context, query cqs.Query) (cqs.QueryResult, error) {
+					mwExecutionCount++
+					return h.Handle(ctx, query)
+				},
+			}
+		}
+		qh := &QueryHandlerMock[helloQuery, helloQueryResult]{
+			HandleFunc: func(_ context.Context, q helloQuery) (helloQueryResult, error) {
+				return helloQueryResult{Greeting: "hello " + q.ID}, nil
+			},
+		}
+		bus := cqs.NewQueryBus(cqs.QueryBusMiddlewareOpt(mw))
+
+		err := cqs.RegisterQueryHandler[helloQuery, helloQueryResult](bus, query.QueryName(), qh)
+		require.NoError(err)
+
+		result, err := cqs.Ask[helloQuery, helloQueryResult](ctx, bus, query)
+		require.NoError(err)
+		require.Equal(helloQueryResult{Greeting: "hello some-id"}, result)
+		require.Equal(1, mwExecutionCount)
+		require.Len(qh.HandleCalls(), 1)
+		require.Equal(query, qh.HandleCalls()[0].Query)
+	})
+
+	t.Run(`Given a query bus with a handler returning another result type,
+	when Ask is called,
+	then it returns an ErrInvalidQueryResultType`, func(t *testing.T) {
+		bus := cqs.NewQueryBus()
+
+		err := cqs.RegisterQueryHandler[helloQuery, string](bus, query.QueryName(), &QueryHandlerMock[helloQuery, string]{
+			HandleFunc: func(context.Context, helloQuery) (string, error) {
+				return "hello", nil
+			},
+		})
+		require.NoError(err)
+
+		_, err = cqs.Ask[helloQuery, helloQueryResult](ctx, bus, query)
+		require.ErrorIs(err, cqs.ErrInvalidQueryResultType)
+		require.ErrorContains(err, "cqs_test.helloQueryResult")
+	})
+
+	t.Run(`Given a query bus with a typed handler,
+	when a query of another type with the same name is asked,
+	then it returns an ErrInvalidQueryType`, func(t *testing.T) {
+		bus := cqs.NewQueryBus()
+
+		err := cqs.RegisterQueryHandler[*helloQuery, helloQueryResult](bus, query.QueryName(), &QueryHandlerMock[*helloQuery, helloQueryResult]{})
+		require.NoError(err)
+
+		_, err = cqs.Ask[helloQuery, helloQueryResult](ctx, bus, query)
+		require.ErrorIs(err, cqs.ErrInvalidQueryType)
+	})
+}
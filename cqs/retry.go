@@ -0,0 +1,261 @@
+package cqs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts    = 3
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff     = 10 * time.Second
+	defaultRetryMultiplier     = 2
+	defaultRetryJitter         = 0.2
+)
+
+// RetryError is returned when an operation retried by a RetryPolicy doesn't succeed.
+type RetryError struct {
+	Attempts int
+	// Err is the error of the last attempt.
+	Err error
+	// Interrupted is the context error that stopped the retries before the attempts ran out, if any.
+	Interrupted error
+}
+
+// Error implements the Error interface.
+func (e RetryError) Error() string {
+	if e.Interrupted != nil {
+		return fmt.Sprintf("%d attempts: %s: last error: %s", e.Attempts, e.Interrupted, e.Err)
+	}
+
+	return fmt.Sprintf("%d attempts: %s", e.Attempts, e.Err)
+}
+
+// Unwrap returns the error of the last attempt.
+func (e RetryError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the retries were interrupted by the target error, so errors.Is matches both the error of the
+// last attempt and the context error.
+func (e RetryError) Is(target error) bool {
+	return e.Interrupted != nil && errors.Is(e.Interrupted, target)
+}
+
+// RetryClassifier decides whether an error is worth retrying.
+type RetryClassifier func(err error) bool
+
+// Sleeper waits for the given duration unless the context is done first.
+type Sleeper interface {
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// SleeperFunc is a function that implements Sleeper interface.
+type SleeperFunc func(ctx context.Context, d time.Duration) error
+
+// Sleep is the Sleeper interface implementation.
+func (f SleeperFunc) Sleep(ctx context.Context, d time.Duration) error {
+	return f(ctx, d)
+}
+
+var _ Sleeper = timerSleeper{}
+
+type timerSleeper struct{}
+
+func (timerSleeper) Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RetryPolicyOpt is the common type of functions that set options on RetryPolicy construction.
+type RetryPolicyOpt func(p *RetryPolicy)
+
+// RetryMaxAttemptsOpt sets the maximum number of attempts, including the first one. The default is 3.
+func RetryMaxAttemptsOpt(attempts int) RetryPolicyOpt {
+	return func(p *RetryPolicy) {
+		if attempts > 0 {
+			p.maxAttempts = attempts
+		}
+	}
+}
+
+// RetryBackoffOpt sets the backoff before the first retry and its upper bound, which can't be lower.
+// The defaults are 100ms and 10s.
+func RetryBackoffOpt(initial, maxBackoff time.Duration) RetryPolicyOpt {
+	return func(p *RetryPolicy) {
+		if initial > 0 && maxBackoff >= initial {
+			p.initialBackoff = initial
+			p.maxBackoff = maxBackoff
+		}
+	}
+}
+
+// RetryMultiplierOpt sets the factor the backoff grows by on each retry. The default is 2.
+func RetryMultiplierOpt(multiplier float64) RetryPolicyOpt {
+	return func(p *RetryPolicy) {
+		if multiplier >= 1 {
+			p.multiplier = multiplier
+		}
+	}
+}
+
+// RetryJitterOpt sets the fraction, between 0 and 1, of the backoff that is randomly subtracted. The default is 0.2.
+func RetryJitterOpt(jitter float64) RetryPolicyOpt {
+	return func(p *RetryPolicy) {
+		p.jitter = math.Max(0, math.Min(1, jitter))
+	}
+}
+
+// RetryClassifierOpt sets the classifier of retryable errors.
+// By default, every error but the context ones is retryable.
+func RetryClassifierOpt(classifier RetryClassifier) RetryPolicyOpt {
+	return func(p *RetryPolicy) {
+		if classifier != nil {
+			p.classifier = classifier
+		}
+	}
+}
+
+// RetrySleeperOpt sets the Sleeper used to wait between attempts. It's useful for testing purposes.
+func RetrySleeperOpt(sleeper Sleeper) RetryPolicyOpt {
+	return func(p *RetryPolicy) {
+		if sleeper != nil {
+			p.sleeper = sleeper
+		}
+	}
+}
+
+// RetryRandOpt sets the source of randomness for the jitter, which must return values in [0, 1).
+func RetryRandOpt(random func() float64) RetryPolicyOpt {
+	return func(p *RetryPolicy) {
+		if random != nil {
+			p.random = random
+		}
+	}
+}
+
+// RetryPolicy retries operations with exponential backoff and jitter.
+type RetryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	multiplier     float64
+	jitter         float64
+	classifier     RetryClassifier
+	sleeper        Sleeper
+	random         func() float64
+}
+
+// NewRetryPolicy is a constructor.
+func NewRetryPolicy(opts ...RetryPolicyOpt) RetryPolicy {
+	p := RetryPolicy{
+		maxAttempts:    defaultRetryMaxAttempts,
+		initialBackoff: defaultRetryInitialBackoff,
+		maxBackoff:     defaultRetryMaxBackoff,
+		multiplier:     defaultRetryMultiplier,
+		jitter:         defaultRetryJitter,
+		classifier:     isRetryable,
+		sleeper:        timerSleeper{},
+		random:         rand.Float64, //nolint:gosec // the jitter doesn't need a secure random source.
+	}
+	for _, opt := range opts {
+		opt(&p)
+	}
+
+	return p
+}
+
+// Backoff returns the time to wait before the given retry, starting by 1.
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	backoff := float64(p.initialBackoff) * math.Pow(p.multiplier, float64(retry-1))
+	if backoff > float64(p.maxBackoff) {
+		backoff = float64(p.maxBackoff)
+	}
+
+	return time.Duration(backoff * (1 - p.jitter*p.random()))
+}
+
+// Do calls f until it succeeds, it returns a non retryable error, the attempts run out or the context is done.
+// When f doesn't succeed, the returned error is a RetryError.
+func (p RetryPolicy) Do(ctx context.Context, f func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := f(ctx)
+		if err == nil {
+			return nil
+		}
+
+		if !p.shouldRetry(attempt, err) {
+			return RetryError{Attempts: attempt, Err: err}
+		}
+
+		if sleepErr := p.sleeper.Sleep(ctx, p.Backoff(attempt)); sleepErr != nil {
+			return RetryError{Attempts: attempt, Err: err, Interrupted: sleepErr}
+		}
+	}
+}
+
+// shouldRetry reports whether the error of the given attempt, starting by 1, is retried.
+func (p RetryPolicy) shouldRetry(attempt int, err error) bool {
+	return attempt < p.maxAttempts && p.classifier(err)
+}
+
+func isRetryable(err error) bool {
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+var (
+	_ EventHandler = retryEventHandler{}
+	_ Named        = retryEventHandler{}
+)
+
+type retryEventHandler struct {
+	handler EventHandler
+	policy  RetryPolicy
+}
+
+// NewRetryEventHandler decorates the event handler to retry it following the policy.
+func NewRetryEventHandler(h EventHandler, policy RetryPolicy) EventHandler {
+	return retryEventHandler{handler: h, policy: policy}
+}
+
+// Handle is the EventHandler interface implementation.
+func (h retryEventHandler) Handle(ctx context.Context, ev Event) error {
+	return h.policy.Do(ctx, func(ctx context.Context) error {
+		return h.handler.Handle(ctx, ev)
+	})
+}
+
+// HandlerName is the Named interface implementation.
+func (h retryEventHandler) HandlerName() string {
+	return handlerName(h.handler)
+}
+
+// RetryCommandHandlerMiddleware retries the command handler following the policy.
+func RetryCommandHandlerMiddleware[C Command](policy RetryPolicy) CommandHandlerMiddleware[C] {
+	return func(h CommandHandler[C]) CommandHandler[C] {
+		return CommandHandlerFunc[C](func(ctx context.Context, cmd C) ([]Event, error) {
+			var events []Event
+
+			err := policy.Do(ctx, func(ctx context.Context) error {
+				var err error
+
+				events, err = h.Handle(ctx, cmd)
+
+				return err
+			})
+
+			return events, err
+		})
+	}
+}
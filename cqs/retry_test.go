@@ -0,0 +1,180 @@
+package cqs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	require := require.New(t)
+
+	t.Run(`Given a retry policy without jitter,
+	when Backoff is called for each retry,
+	then it grows exponentially up to the max backoff`, func(t *testing.T) {
+		policy := cqs.NewRetryPolicy(
+			cqs.RetryBackoffOpt(10*time.Millisecond, 50*time.Millisecond),
+			cqs.RetryJitterOpt(0),
+		)
+
+		require.Equal(10*time.Millisecond, policy.Backoff(1))
+		require.Equal(20*time.Millisecond, policy.Backoff(2))
+		require.Equal(40*time.Millisecond, policy.Backoff(3))
+		require.Equal(50*time.Millisecond, policy.Backoff(4))
+	})
+
+	t.Run(`Given a retry policy with jitter,
+	when Backoff is called,
+	then the random fraction of the jitter is subtracted`, func(t *testing.T) {
+		policy := cqs.NewRetryPolicy(
+			cqs.RetryBackoffOpt(100*time.Millisecond, time.Second),
+			cqs.RetryJitterOpt(0.5),
+			cqs.RetryRandOpt(func() float64 { return 0.5 }),
+		)
+
+		require.Equal(75*time.Millisecond, policy.Backoff(1))
+	})
+
+	t.Run(`Given a retry policy with an invalid backoff,
+	when Backoff is called,
+	then the default backoff is used`, func(t *testing.T) {
+		for _, opt := range []cqs.RetryPolicyOpt{
+			cqs.RetryBackoffOpt(0, time.Second),
+			cqs.RetryBackoffOpt(-time.Second, time.Second),
+			cqs.RetryBackoffOpt(time.Second, time.Millisecond),
+		} {
+			policy := cqs.NewRetryPolicy(opt, cqs.RetryJitterOpt(0))
+
+			require.Equal(100*time.Millisecond, policy.Backoff(1))
+			require.Equal(10*time.Second, policy.Backoff(10))
+		}
+	})
+}
+
+func TestRetryEventHandler(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+	handlerErr := errors.New("event handler error")
+
+	newPolicy := func(sleeps *[]time.Duration, opts ...cqs.RetryPolicyOpt) cqs.RetryPolicy {
+		opts = append([]cqs.RetryPolicyOpt{
+			cqs.RetryMaxAttemptsOpt(3),
+			cqs.RetryJitterOpt(0),
+			cqs.RetryBackoffOpt(time.Second, time.Minute),
+			cqs.RetrySleeperOpt(cqs.SleeperFunc(func(ctx context.Context, d time.Duration) error {
+				*sleeps = append(*sleeps, d)
+				return ctx.Err()
+			})),
+		}, opts...)
+
+		return cqs.NewRetryPolicy(opts...)
+	}
+
+	t.Run(`Given a retry event handler whose handler fails once,
+	when Handle is called,
+	then it retries and succeeds`, func(t *testing.T) {
+		var sleeps []time.Duration
+
+		evHandlerMock := &EventHandlerMock{}
+		evHandlerMock.HandleFunc = func(context.Context, cqs.Event) error {
+			if len(evHandlerMock.HandleCalls()) == 1 {
+				return handlerErr
+			}
+
+			return nil
+		}
+
+		err := cqs.NewRetryEventHandler(evHandlerMock, newPolicy(&sleeps)).Handle(ctx, &EventMock{})
+		require.NoError(err)
+		require.Len(evHandlerMock.HandleCalls(), 2)
+		require.Equal([]time.Duration{time.Second}, sleeps)
+	})
+
+	t.Run(`Given a retry event handler whose handler always fails,
+	when Handle is called,
+	then it returns a RetryError after the max attempts`, func(t *testing.T) {
+		var sleeps []time.Duration
+
+		evHandlerMock := &EventHandlerMock{
+			HandleFunc: func(context.Context, cqs.Event) error { return handlerErr },
+		}
+
+		err := cqs.NewRetryEventHandler(evHandlerMock, newPolicy(&sleeps)).Handle(ctx, &EventMock{})
+		require.ErrorIs(err, handlerErr)
+
+		var retryErr cqs.RetryError
+		require.ErrorAs(err, &retryErr)
+		require.Equal(3, retryErr.Attempts)
+		require.Len(evHandlerMock.HandleCalls(), 3)
+		require.Equal([]time.Duration{time.Second, 2 * time.Second}, sleeps)
+	})
+
+	t.Run(`Given a retry event handler with a classifier,
+	when the handler returns a non retryable error,
+	then it doesn't retry`, func(t *testing.T) {
+		var sleeps []time.Duration
+
+		evHandlerMock := &EventHandlerMock{
+			HandleFunc: func(context.Context, cqs.Event) error { return handlerErr },
+		}
+		policy := newPolicy(&sleeps, cqs.RetryClassifierOpt(func(err error) bool {
+			return !errors.Is(err, handlerErr)
+		}))
+
+		err := cqs.NewRetryEventHandler(evHandlerMock, policy).Handle(ctx, &EventMock{})
+		require.ErrorIs(err, handlerErr)
+		require.Len(evHandlerMock.HandleCalls(), 1)
+		require.Empty(sleeps)
+	})
+
+	t.Run(`Given a retry event handler,
+	when the context is canceled while waiting,
+	then it stops retrying and returns the context error along with the handler error`, func(t *testing.T) {
+		var sleeps []time.Duration
+
+		cancelCtx, cancel := context.WithCancel(ctx)
+		evHandlerMock := &EventHandlerMock{
+			HandleFunc: func(context.Context, cqs.Event) error {
+				cancel()
+				return handlerErr
+			},
+		}
+
+		err := cqs.NewRetryEventHandler(evHandlerMock, newPolicy(&sleeps)).Handle(cancelCtx, &EventMock{})
+		require.ErrorIs(err, context.Canceled)
+		require.ErrorIs(err, handlerErr)
+		require.Len(evHandlerMock.HandleCalls(), 1)
+	})
+}
+
+func TestRetryCommandHandlerMiddleware(t *testing.T) {
+	require := require.New(t)
+
+	t.Run(`Given a command handler decorated with the retry middleware that fails once,
+	when Handle is called,
+	then it returns the events of the successful attempt`, func(t *testing.T) {
+		ev := &EventMock{}
+		cmdHandlerMock := &CommandHandlerMock[cqs.Command]{}
+		cmdHandlerMock.HandleFunc = func(context.Context, cqs.Command) ([]cqs.Event, error) {
+			if len(cmdHandlerMock.HandleCalls()) == 1 {
+				return nil, errors.New("command handler error")
+			}
+
+			return []cqs.Event{ev}, nil
+		}
+		policy := cqs.NewRetryPolicy(cqs.RetrySleeperOpt(cqs.SleeperFunc(func(context.Context, time.Duration) error {
+			return nil
+		})))
+
+		events, err := cqs.RetryCommandHandlerMiddleware[cqs.Command](policy)(cmdHandlerMock).Handle(context.Background(), &CommandMock{})
+		require.NoError(err)
+		require.Equal([]cqs.Event{ev}, events)
+		require.Len(cmdHandlerMock.HandleCalls(), 2)
+	})
+}
@@ -0,0 +1,539 @@
+package cqs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrEmptySagaName                = errors.New("empty saga name")
+	ErrEmptySagaID                  = errors.New("empty saga id")
+	ErrEmptySagaHandler             = errors.New("empty saga handler")
+	ErrSagaNotFound                 = errors.New("saga not found")
+	ErrSagaHandlerAlreadyRegistered = errors.New("saga handler already registered")
+	ErrSagaCompensationNotFound     = errors.New("saga compensation not found")
+)
+
+// SagaStatus is the status of a saga instance.
+type SagaStatus string
+
+const (
+	SagaRunning     SagaStatus = "running"
+	SagaCompleted   SagaStatus = "completed"
+	SagaCompensated SagaStatus = "compensated"
+)
+
+// SagaTimeout is a timeout scheduled by a saga instance.
+type SagaTimeout struct {
+	SagaID string
+	Name   string
+	At     time.Time
+}
+
+// SagaRecord is the persisted form of a saga instance.
+type SagaRecord struct {
+	Saga   string
+	ID     string
+	Status SagaStatus
+	// State is the JSON encoding of the saga state.
+	State json.RawMessage
+	// Steps are the compensable steps done, in order.
+	Steps    []string
+	Timeouts []SagaTimeout
+	// Version is increased on each save, to detect concurrent changes.
+	Version int
+}
+
+// SagaStore persists the saga instances.
+type SagaStore interface {
+	// Load returns the instance of the saga with the given ID, or ErrSagaNotFound.
+	Load(ctx context.Context, saga, id string) (SagaRecord, error)
+	// Save stores the instance if the stored one has the previous version, or returns ErrConcurrencyConflict.
+	Save(ctx context.Context, record SagaRecord) error
+	// DueTimeouts returns the timeouts of the running instances of the saga due at the given time.
+	DueTimeouts(ctx context.Context, saga string, now time.Time) ([]SagaTimeout, error)
+}
+
+// SagaInstance is an instance of a saga, given to its handlers to change its state, send commands and schedule
+// timeouts. The commands are sent once the handler returns and the instance is saved.
+type SagaInstance[S any] struct {
+	ID     string
+	State  S
+	status SagaStatus
+	steps  []string
+
+	timeouts []SagaTimeout
+	commands []sagaCommand
+}
+
+type sagaCommand struct {
+	cmd  Command
+	step string
+}
+
+// Send sends a command.
+func (i *SagaInstance[S]) Send(cmd Command) {
+	i.commands = append(i.commands, sagaCommand{cmd: cmd})
+}
+
+// SendStep sends a command that is compensated, with the compensation registered for the step, when a
+// following command fails.
+func (i *SagaInstance[S]) SendStep(step string, cmd Command) {
+	i.commands = append(i.commands, sagaCommand{cmd: cmd, step: step})
+}
+
+// ScheduleTimeout schedules a timeout, replacing the one with the same name if any.
+func (i *SagaInstance[S]) ScheduleTimeout(name string, at time.Time) {
+	i.CancelTimeout(name)
+	i.timeouts = append(i.timeouts, SagaTimeout{SagaID: i.ID, Name: name, At: at})
+}
+
+// CancelTimeout cancels the timeout with the given name.
+func (i *SagaInstance[S]) CancelTimeout(name string) {
+	timeouts := i.timeouts[:0]
+
+	for _, t := range i.timeouts {
+		if t.Name != name {
+			timeouts = append(timeouts, t)
+		}
+	}
+
+	i.timeouts = timeouts
+}
+
+// Complete completes the saga, so it ignores the following events and timeouts.
+func (i *SagaInstance[S]) Complete() {
+	i.status = SagaCompleted
+	i.timeouts = nil
+}
+
+// SagaCorrelator returns the ID of the saga instance an event belongs to.
+type SagaCorrelator func(ev Event) string
+
+// CorrelateByAggregateID is a SagaCorrelator that uses the aggregate ID of the events.
+func CorrelateByAggregateID(ev Event) string {
+	return idString(ev.EventAggregateRootID())
+}
+
+// SagaHandler reacts to an event received by a saga instance.
+type SagaHandler[S any] func(ctx context.Context, instance *SagaInstance[S], ev Event) error
+
+// SagaTimeoutHandler reacts to a timeout of a saga instance.
+type SagaTimeoutHandler[S any] func(ctx context.Context, instance *SagaInstance[S]) error
+
+// SagaCompensation returns the command that compensates a step, given the saga state.
+type SagaCompensation[S any] func(state S) Command
+
+// SagaOpt is the common type of functions that set options on Saga construction.
+type SagaOpt[S any] func(s *Saga[S])
+
+// SagaStartOpt sets the handler of the events with the given name, which start a new instance when there
+// isn't any with their ID.
+func SagaStartOpt[S any](name EventName, correlate SagaCorrelator, h SagaHandler[S]) SagaOpt[S] {
+	return sagaOnOpt(name, correlate, h, true)
+}
+
+// SagaOnOpt sets the handler of the events with the given name. The events without instance are ignored.
+func SagaOnOpt[S any](name EventName, correlate SagaCorrelator, h SagaHandler[S]) SagaOpt[S] {
+	return sagaOnOpt(name, correlate, h, false)
+}
+
+func sagaOnOpt[S any](name EventName, correlate SagaCorrelator, h SagaHandler[S], starts bool) SagaOpt[S] {
+	return func(s *Saga[S]) {
+		if correlate == nil || h == nil {
+			s.err = fmt.Errorf("%w: %s", ErrEmptySagaHandler, name)
+
+			return
+		}
+
+		if _, ok := s.handlers[name]; ok {
+			s.err = fmt.Errorf("%w: %s", ErrSagaHandlerAlreadyRegistered, name)
+
+			return
+		}
+
+		s.handlers[name] = sagaEventHandler[S]{correlate: correlate, handle: h, starts: starts}
+	}
+}
+
+// SagaTimeoutOpt sets the handler of the timeouts with the given name.
+func SagaTimeoutOpt[S any](name string, h SagaTimeoutHandler[S]) SagaOpt[S] {
+	return func(s *Saga[S]) {
+		if h == nil {
+			s.err = fmt.Errorf("%w: timeout %s", ErrEmptySagaHandler, name)
+
+			return
+		}
+
+		s.timeouts[name] = h
+	}
+}
+
+// SagaCompensationOpt sets the compensation of a step.
+func SagaCompensationOpt[S any](step string, compensate SagaCompensation[S]) SagaOpt[S] {
+	return func(s *Saga[S]) {
+		if compensate == nil {
+			s.err = fmt.Errorf("%w: compensation %s", ErrEmptySagaHandler, step)
+
+			return
+		}
+
+		s.compensations[step] = compensate
+	}
+}
+
+type sagaEventHandler[S any] struct {
+	correlate SagaCorrelator
+	handle    SagaHandler[S]
+	starts    bool
+}
+
+var _ EventHandler = &Saga[struct{}]{}
+
+// Saga coordinates a long-running workflow across several events. Each instance is correlated by an ID
+// extracted from the events, and keeps a state of type S, which is persisted as JSON in a SagaStore.
+//
+// The instance is saved before its commands are sent, so the events they produce see the new state. When a command
+// fails, the saga is compensated: the compensations of the steps done are sent in reverse order.
+// The commands may be sent again if an event is redelivered after a failure, so they must be idempotent.
+type Saga[S any] struct {
+	name          string
+	store         SagaStore
+	commands      CommandHandler[Command]
+	handlers      map[EventName]sagaEventHandler[S]
+	timeouts      map[string]SagaTimeoutHandler[S]
+	compensations map[string]SagaCompensation[S]
+	err           error
+}
+
+// NewSaga is a constructor. The commands are usually sent to a CommandBus.
+func NewSaga[S any](name string, store SagaStore, commands CommandHandler[Command], opts ...SagaOpt[S]) (*Saga[S], error) {
+	if name == "" {
+		return nil, ErrEmptySagaName
+	}
+
+	if commands == nil {
+		return nil, ErrEmptyCommandHandler
+	}
+
+	s := &Saga[S]{
+		name:          name,
+		store:         store,
+		commands:      commands,
+		handlers:      make(map[EventName]sagaEventHandler[S]),
+		timeouts:      make(map[string]SagaTimeoutHandler[S]),
+		compensations: make(map[string]SagaCompensation[S]),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.err != nil {
+		return nil, fmt.Errorf("saga %s: %w", name, s.err)
+	}
+
+	return s, nil
+}
+
+// Subscribe subscribes the saga to the events it handles.
+func (s *Saga[S]) Subscribe(bus EventsBus) error {
+	names := make([]string, 0, len(s.handlers))
+	for name := range s.handlers {
+		names = append(names, string(name))
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := bus.Subscribe(EventName(name), s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Handle is the EventHandler interface implementation.
+func (s *Saga[S]) Handle(ctx context.Context, ev Event) error {
+	h, ok := s.handlers[ev.EventName()]
+	if !ok {
+		return nil
+	}
+
+	id := h.correlate(ev)
+	if id == "" {
+		return fmt.Errorf("%w: saga %s, event %s", ErrEmptySagaID, s.name, ev.EventName())
+	}
+
+	record, err := s.store.Load(ctx, s.name, id)
+
+	switch {
+	case errors.Is(err, ErrSagaNotFound) && h.starts:
+		record = SagaRecord{Saga: s.name, ID: id, Status: SagaRunning}
+	case errors.Is(err, ErrSagaNotFound):
+		return nil
+	case err != nil:
+		return fmt.Errorf("load saga %s %s: %w", s.name, id, err)
+	}
+
+	return s.run(ctx, record, func(instance *SagaInstance[S]) error {
+		return h.handle(ctx, instance, ev)
+	})
+}
+
+// HandleTimeouts calls the handlers of the timeouts due at the given time. It's meant to be called periodically.
+func (s *Saga[S]) HandleTimeouts(ctx context.Context, now time.Time) error {
+	due, err := s.store.DueTimeouts(ctx, s.name, now)
+	if err != nil {
+		return fmt.Errorf("load due timeouts of saga %s: %w", s.name, err)
+	}
+
+	multierror := NewMultiError()
+
+	for _, timeout := range due {
+		if err := s.handleTimeout(ctx, timeout); err != nil {
+			multierror.Add(err)
+		}
+	}
+
+	return multierror.ErrResult()
+}
+
+func (s *Saga[S]) handleTimeout(ctx context.Context, timeout SagaTimeout) error {
+	record, err := s.store.Load(ctx, s.name, timeout.SagaID)
+	if err != nil {
+		return fmt.Errorf("load saga %s %s: %w", s.name, timeout.SagaID, err)
+	}
+
+	return s.run(ctx, record, func(instance *SagaInstance[S]) error {
+		// The timeout may have been cancelled or rescheduled since it was read.
+		scheduled := false
+
+		for _, t := range instance.timeouts {
+			if t.Name == timeout.Name && !t.At.After(timeout.At) {
+				scheduled = true
+			}
+		}
+
+		if !scheduled {
+			return nil
+		}
+
+		instance.CancelTimeout(timeout.Name)
+
+		if h, ok := s.timeouts[timeout.Name]; ok {
+			return h(ctx, instance)
+		}
+
+		return nil
+	})
+}
+
+// run calls f with the instance of the record, saves it and sends its commands.
+func (s *Saga[S]) run(ctx context.Context, record SagaRecord, f func(instance *SagaInstance[S]) error) error {
+	if record.Status != SagaRunning {
+		return nil
+	}
+
+	instance := &SagaInstance[S]{
+		ID:       record.ID,
+		status:   record.Status,
+		steps:    record.Steps,
+		timeouts: append([]SagaTimeout(nil), record.Timeouts...),
+	}
+
+	if len(record.State) > 0 {
+		if err := json.Unmarshal(record.State, &instance.State); err != nil {
+			return fmt.Errorf("unmarshal state of saga %s %s: %w", s.name, record.ID, err)
+		}
+	}
+
+	if err := f(instance); err != nil {
+		return fmt.Errorf("saga %s %s: %w", s.name, record.ID, err)
+	}
+
+	state, err := json.Marshal(instance.State)
+	if err != nil {
+		return fmt.Errorf("marshal state of saga %s %s: %w", s.name, record.ID, err)
+	}
+
+	record.State = state
+	record.Status = instance.status
+	record.Timeouts = instance.timeouts
+	record.Steps = append([]string(nil), instance.steps...)
+
+	for _, c := range instance.commands {
+		if c.step != "" {
+			record.Steps = append(record.Steps, c.step)
+		}
+	}
+
+	record.Version++
+
+	if err := s.store.Save(ctx, record); err != nil {
+		return fmt.Errorf("save saga %s %s: %w", s.name, record.ID, err)
+	}
+
+	// The steps of the commands sent are after the previous ones, followed by the ones of the commands not sent.
+	done := len(instance.steps)
+
+	for _, c := range instance.commands {
+		if _, err := s.commands.Handle(ctx, c.cmd); err != nil {
+			err = fmt.Errorf("saga %s %s: command %s: %w", s.name, record.ID, c.cmd.CommandName(), err)
+
+			return s.compensate(ctx, record.ID, done, len(record.Steps), err)
+		}
+
+		if c.step != "" {
+			done++
+		}
+	}
+
+	return nil
+}
+
+// compensate sends the compensations of the steps done, in reverse order. The steps from the index notSent to
+// notSentEnd belong to the commands not sent, so they are skipped.
+// It returns the cause along with the compensation errors.
+func (s *Saga[S]) compensate(ctx context.Context, id string, notSent, notSentEnd int, cause error) error {
+	ctx = withoutCancel(ctx)
+
+	// The record is loaded again because the events of the commands sent may have changed it.
+	record, err := s.store.Load(ctx, s.name, id)
+	if err != nil {
+		return fmt.Errorf("%w; compensate: load saga: %s", cause, err)
+	}
+
+	// A failure in the handling of the events of the commands sent may have compensated it already.
+	if record.Status != SagaRunning {
+		return cause
+	}
+
+	steps := append([]string(nil), record.Steps[:notSent]...)
+	if notSentEnd < len(record.Steps) {
+		steps = append(steps, record.Steps[notSentEnd:]...)
+	}
+
+	var state S
+
+	if len(record.State) > 0 {
+		if err := json.Unmarshal(record.State, &state); err != nil {
+			return fmt.Errorf("%w; compensate: unmarshal state: %s", cause, err)
+		}
+	}
+
+	record.Status = SagaCompensated
+	record.Timeouts = nil
+	record.Version++
+
+	if err := s.store.Save(ctx, record); err != nil {
+		return fmt.Errorf("%w; compensate: save saga: %s", cause, err)
+	}
+
+	multierror := NewMultiError()
+	multierror.Add(cause)
+
+	for i := len(steps) - 1; i >= 0; i-- {
+		compensate, ok := s.compensations[steps[i]]
+		if !ok {
+			multierror.Add(fmt.Errorf("%w: %s", ErrSagaCompensationNotFound, steps[i]))
+
+			continue
+		}
+
+		cmd := compensate(state)
+		if cmd == nil {
+			continue
+		}
+
+		if _, err := s.commands.Handle(ctx, cmd); err != nil {
+			multierror.Add(fmt.Errorf("compensate step %s: %w", steps[i], err))
+		}
+	}
+
+	return multierror.ErrResult()
+}
+
+type sagaKey struct {
+	saga string
+	id   string
+}
+
+var _ SagaStore = &InMemorySagaStore{}
+
+// InMemorySagaStore is a concurrent-safe SagaStore that keeps the instances in memory.
+// Its zero value is ready to use.
+type InMemorySagaStore struct {
+	mu      sync.RWMutex
+	records map[sagaKey]SagaRecord
+}
+
+// Load is the SagaStore interface implementation.
+func (s *InMemorySagaStore) Load(_ context.Context, saga, id string) (SagaRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.records[sagaKey{saga: saga, id: id}]
+	if !ok {
+		return SagaRecord{}, fmt.Errorf("%w: %s %s", ErrSagaNotFound, saga, id)
+	}
+
+	return copySagaRecord(record), nil
+}
+
+// Save is the SagaStore interface implementation.
+func (s *InMemorySagaStore) Save(_ context.Context, record SagaRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.records == nil {
+		s.records = make(map[sagaKey]SagaRecord)
+	}
+
+	key := sagaKey{saga: record.Saga, id: record.ID}
+	if stored := s.records[key]; stored.Version != record.Version-1 {
+		return fmt.Errorf("%w: saga %s %s expected version %d, actual version %d",
+			ErrConcurrencyConflict, record.Saga, record.ID, record.Version-1, stored.Version)
+	}
+
+	s.records[key] = copySagaRecord(record)
+
+	return nil
+}
+
+// DueTimeouts is the SagaStore interface implementation.
+func (s *InMemorySagaStore) DueTimeouts(_ context.Context, saga string, now time.Time) ([]SagaTimeout, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var due []SagaTimeout
+
+	for key, record := range s.records {
+		if key.saga != saga || record.Status != SagaRunning {
+			continue
+		}
+
+		for _, t := range record.Timeouts {
+			if !t.At.After(now) {
+				due = append(due, t)
+			}
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool { return due[i].At.Before(due[j].At) })
+
+	return due, nil
+}
+
+func copySagaRecord(record SagaRecord) SagaRecord {
+	record.State = append(json.RawMessage(nil), record.State...)
+	record.Steps = append([]string(nil), record.Steps...)
+	record.Timeouts = append([]SagaTimeout(nil), record.Timeouts...)
+
+	return record
+}
@@ -0,0 +1,358 @@
+package cqs_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+const (
+	orderPlacedName    cqs.EventName = "order_placed"
+	paymentChargedName cqs.EventName = "payment_charged"
+	orderShippedName   cqs.EventName = "order_shipped"
+)
+
+// orderCommand is a command of the order saga, identified by its name.
+type orderCommand struct {
+	Name    string
+	OrderID vo.ID
+}
+
+func (c orderCommand) CommandName() string {
+	return c.Name
+}
+
+type orderState struct {
+	Charged bool
+	Retries int
+}
+
+// orderWorkflow runs the order saga against a command bus that records the commands sent.
+type orderWorkflow struct {
+	mu     sync.Mutex
+	sent   []string
+	failOn string
+	// unpaid makes the charges produce no event, so the payment times out.
+	unpaid bool
+
+	saga *cqs.Saga[orderState]
+	bus  *cqs.CommandBus
+}
+
+func newOrderWorkflow(t *testing.T, store cqs.SagaStore) *orderWorkflow {
+	t.Helper()
+
+	require := require.New(t)
+
+	w := &orderWorkflow{}
+	events := &cqs.BasicEventsBus{}
+	w.bus = cqs.NewCommandBus(cqs.CommandBusEventsBusOpt(events))
+
+	for name, produced := range map[string]cqs.EventName{
+		"charge": paymentChargedName, "reserve": "", "ship": orderShippedName, "refund": "", "release": "", "cancel": "",
+	} {
+		name, produced := name, produced
+		require.NoError(cqs.RegisterCommandHandler[orderCommand](w.bus, name,
+			cqs.CommandHandlerFunc[orderCommand](func(_ context.Context, cmd orderCommand) ([]cqs.Event, error) {
+				w.mu.Lock()
+				w.sent = append(w.sent, cmd.Name)
+				fail := w.failOn == cmd.Name
+				unpaid := w.unpaid
+				w.mu.Unlock()
+
+				if fail {
+					return nil, errors.New(cmd.Name + " failed")
+				}
+
+				if produced == "" || (produced == paymentChargedName && unpaid) {
+					return nil, nil
+				}
+
+				var ev cqs.BasicEvent
+				ev.Hydrate(vo.NewID(), produced, vo.DateTimeNow(), cmd.OrderID, 1)
+
+				return []cqs.Event{ev}, nil
+			})))
+	}
+
+	command := func(name string) func(id string) orderCommand {
+		return func(id string) orderCommand {
+			return orderCommand{Name: name, OrderID: vo.MustParseID(id)}
+		}
+	}
+
+	saga, err := cqs.NewSaga[orderState]("order", store, w.bus,
+		cqs.SagaStartOpt(orderPlacedName, cqs.CorrelateByAggregateID,
+			func(_ context.Context, i *cqs.SagaInstance[orderState], _ cqs.Event) error {
+				i.ScheduleTimeout("payment", time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC))
+				i.SendStep("charge", command("charge")(i.ID))
+
+				return nil
+			}),
+		cqs.SagaOnOpt(paymentChargedName, cqs.CorrelateByAggregateID,
+			func(_ context.Context, i *cqs.SagaInstance[orderState], _ cqs.Event) error {
+				i.State.Charged = true
+				i.CancelTimeout("payment")
+				i.SendStep("reserve", command("reserve")(i.ID))
+				i.Send(command("ship")(i.ID))
+
+				return nil
+			}),
+		cqs.SagaOnOpt(orderShippedName, cqs.CorrelateByAggregateID,
+			func(_ context.Context, i *cqs.SagaInstance[orderState], _ cqs.Event) error {
+				i.Complete()
+
+				return nil
+			}),
+		cqs.SagaTimeoutOpt("payment", func(_ context.Context, i *cqs.SagaInstance[orderState]) error {
+			i.State.Retries++
+			if i.State.Retries < 2 {
+				i.ScheduleTimeout("payment", time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC))
+
+				return nil
+			}
+
+			i.Send(command("cancel")(i.ID))
+			i.Complete()
+
+			return nil
+		}),
+		cqs.SagaCompensationOpt("charge", func(s orderState) cqs.Command {
+			if !s.Charged {
+				return nil
+			}
+
+			return orderCommand{Name: "refund"}
+		}),
+		cqs.SagaCompensationOpt("reserve", func(orderState) cqs.Command { return orderCommand{Name: "release"} }),
+	)
+	require.NoError(err)
+	require.NoError(saga.Subscribe(events))
+
+	w.saga = saga
+
+	return w
+}
+
+func (w *orderWorkflow) commands() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]string(nil), w.sent...)
+}
+
+func newOrderPlaced(id vo.ID) cqs.Event {
+	var ev cqs.BasicEvent
+	ev.Hydrate(vo.NewID(), orderPlacedName, vo.DateTimeNow(), id, 1)
+
+	return ev
+}
+
+func TestNewSaga(t *testing.T) {
+	require := require.New(t)
+
+	handler := func(context.Context, *cqs.SagaInstance[orderState], cqs.Event) error { return nil }
+
+	t.Run(`Given invalid saga options,
+	when a saga is created,
+	then it returns an error`, func(t *testing.T) {
+		_, err := cqs.NewSaga[orderState]("", &cqs.InMemorySagaStore{}, cqs.NewCommandBus())
+		require.ErrorIs(err, cqs.ErrEmptySagaName)
+
+		_, err = cqs.NewSaga[orderState]("order", &cqs.InMemorySagaStore{}, nil)
+		require.ErrorIs(err, cqs.ErrEmptyCommandHandler)
+
+		_, err = cqs.NewSaga[orderState]("order", &cqs.InMemorySagaStore{}, cqs.NewCommandBus(),
+			cqs.SagaOnOpt[orderState](orderPlacedName, nil, handler))
+		require.ErrorIs(err, cqs.ErrEmptySagaHandler)
+
+		_, err = cqs.NewSaga[orderState]("order", &cqs.InMemorySagaStore{}, cqs.NewCommandBus(),
+			cqs.SagaStartOpt(orderPlacedName, cqs.CorrelateByAggregateID, handler),
+			cqs.SagaOnOpt(orderPlacedName, cqs.CorrelateByAggregateID, handler),
+		)
+		require.ErrorIs(err, cqs.ErrSagaHandlerAlreadyRegistered)
+	})
+}
+
+func TestSaga(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+
+	t.Run(`Given an order saga,
+	when an order is placed and its commands succeed,
+	then the saga reacts to the events they produce until it's completed`, func(t *testing.T) {
+		var store cqs.InMemorySagaStore
+
+		w := newOrderWorkflow(t, &store)
+		id := vo.NewID()
+
+		require.NoError(w.saga.Handle(ctx, newOrderPlaced(id)))
+		require.Equal([]string{"charge", "reserve", "ship"}, w.commands())
+
+		record, err := store.Load(ctx, "order", id.String())
+		require.NoError(err)
+		require.Equal(cqs.SagaCompleted, record.Status)
+		require.Equal([]string{"charge", "reserve"}, record.Steps)
+		require.Empty(record.Timeouts)
+		require.JSONEq(`{"Charged":true,"Retries":0}`, string(record.State))
+
+		require.NoError(w.saga.Handle(ctx, newOrderPlaced(id)))
+		require.Len(w.commands(), 3)
+	})
+
+	t.Run(`Given an order saga,
+	when a command fails after some steps are done,
+	then the steps are compensated in reverse order and the saga is compensated`, func(t *testing.T) {
+		var store cqs.InMemorySagaStore
+
+		w := newOrderWorkflow(t, &store)
+		w.failOn = "ship"
+		id := vo.NewID()
+
+		err := w.saga.Handle(ctx, newOrderPlaced(id))
+		require.ErrorContains(err, "ship failed")
+		require.Equal([]string{"charge", "reserve", "ship", "release", "refund"}, w.commands())
+
+		record, err := store.Load(ctx, "order", id.String())
+		require.NoError(err)
+		require.Equal(cqs.SagaCompensated, record.Status)
+	})
+
+	t.Run(`Given an order saga,
+	when the first command fails,
+	then nothing is compensated`, func(t *testing.T) {
+		var store cqs.InMemorySagaStore
+
+		w := newOrderWorkflow(t, &store)
+		w.failOn = "charge"
+
+		require.ErrorContains(w.saga.Handle(ctx, newOrderPlaced(vo.NewID())), "charge failed")
+		require.Equal([]string{"charge"}, w.commands())
+	})
+
+	t.Run(`Given an order saga,
+	when an event of an unknown instance is received,
+	then it's ignored`, func(t *testing.T) {
+		var store cqs.InMemorySagaStore
+
+		w := newOrderWorkflow(t, &store)
+
+		var ev cqs.BasicEvent
+		ev.Hydrate(vo.NewID(), paymentChargedName, vo.DateTimeNow(), vo.NewID(), 1)
+
+		require.NoError(w.saga.Handle(ctx, ev))
+		require.NoError(w.saga.Handle(ctx, newBasicEvent("unknown")))
+		require.Empty(w.commands())
+	})
+
+	t.Run(`Given an order saga waiting for the payment,
+	when its timeouts are due,
+	then the timeout handler is called until the saga is completed`, func(t *testing.T) {
+		var store cqs.InMemorySagaStore
+
+		w := newOrderWorkflow(t, &store)
+		w.unpaid = true
+		id := vo.NewID()
+
+		require.NoError(w.saga.Handle(ctx, newOrderPlaced(id)))
+
+		require.NoError(w.saga.HandleTimeouts(ctx, time.Date(2024, 1, 1, 0, 30, 0, 0, time.UTC)))
+		require.Equal([]string{"charge"}, w.commands())
+
+		require.NoError(w.saga.HandleTimeouts(ctx, time.Date(2024, 1, 1, 1, 30, 0, 0, time.UTC)))
+		require.Equal([]string{"charge"}, w.commands())
+
+		require.NoError(w.saga.HandleTimeouts(ctx, time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC)))
+		require.Equal([]string{"charge", "cancel"}, w.commands())
+
+		record, err := store.Load(ctx, "order", id.String())
+		require.NoError(err)
+		require.Equal(cqs.SagaCompleted, record.Status)
+		require.JSONEq(`{"Charged":false,"Retries":2}`, string(record.State))
+
+		require.NoError(w.saga.HandleTimeouts(ctx, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)))
+		require.Len(w.commands(), 2)
+	})
+}
+
+func testSagaStore(t *testing.T, store cqs.SagaStore) {
+	t.Helper()
+
+	require := require.New(t)
+
+	ctx := context.Background()
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err := store.Load(ctx, "order", "1")
+	require.ErrorIs(err, cqs.ErrSagaNotFound)
+
+	record := cqs.SagaRecord{
+		Saga:     "order",
+		ID:       "1",
+		Status:   cqs.SagaRunning,
+		State:    []byte(`{"Charged":true}`),
+		Steps:    []string{"charge"},
+		Timeouts: []cqs.SagaTimeout{{SagaID: "1", Name: "payment", At: at}, {SagaID: "1", Name: "ship", At: at.Add(time.Hour)}},
+		Version:  1,
+	}
+	require.NoError(store.Save(ctx, record))
+	require.ErrorIs(store.Save(ctx, record), cqs.ErrConcurrencyConflict)
+	require.NoError(store.Save(ctx, cqs.SagaRecord{Saga: "other", ID: "1", Status: cqs.SagaRunning, Version: 1,
+		Timeouts: []cqs.SagaTimeout{{SagaID: "1", Name: "payment", At: at}}}))
+
+	loaded, err := store.Load(ctx, "order", "1")
+	require.NoError(err)
+	require.Equal(record.Status, loaded.Status)
+	require.JSONEq(string(record.State), string(loaded.State))
+	require.Equal(record.Steps, loaded.Steps)
+	require.Len(loaded.Timeouts, 2)
+	require.True(at.Equal(loaded.Timeouts[0].At))
+	require.Equal(1, loaded.Version)
+
+	due, err := store.DueTimeouts(ctx, "order", at.Add(-time.Second))
+	require.NoError(err)
+	require.Empty(due)
+
+	due, err = store.DueTimeouts(ctx, "order", at)
+	require.NoError(err)
+	require.Len(due, 1)
+	require.Equal("payment", due[0].Name)
+	require.Equal("1", due[0].SagaID)
+
+	loaded.Status = cqs.SagaCompleted
+	loaded.Version++
+	require.NoError(store.Save(ctx, loaded))
+	require.ErrorIs(store.Save(ctx, loaded), cqs.ErrConcurrencyConflict)
+
+	due, err = store.DueTimeouts(ctx, "order", at.Add(time.Hour))
+	require.NoError(err)
+	require.Empty(due)
+}
+
+func TestInMemorySagaStore(t *testing.T) {
+	testSagaStore(t, &cqs.InMemorySagaStore{})
+}
+
+func TestSQLSagaStore(t *testing.T) {
+	require := require.New(t)
+
+	db := newSQLiteDB(t)
+	store := cqs.NewSQLSagaStore(db, cqs.SQLiteDialect{})
+	require.NoError(store.Migrate(context.Background()))
+
+	testSagaStore(t, store)
+
+	w := newOrderWorkflow(t, store)
+	id := vo.NewID()
+
+	require.NoError(w.saga.Handle(context.Background(), newOrderPlaced(id)))
+	require.Equal([]string{"charge", "reserve", "ship"}, w.commands())
+}
@@ -0,0 +1,231 @@
+package cqs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// Snapshot is the state of an aggregate at a version.
+type Snapshot struct {
+	AggregateID vo.ID
+	// Version is the version of the aggregate when the snapshot was taken.
+	Version EventVersion
+	// SchemaVersion is the revision of the payload format.
+	SchemaVersion SchemaVersion
+	Payload       []byte
+	At            vo.DateTime
+}
+
+// SnapshotStore keeps the latest snapshot of each aggregate.
+type SnapshotStore interface {
+	// Save stores the snapshot unless there is one of a greater version.
+	Save(ctx context.Context, snapshot Snapshot) error
+	// Load returns the latest snapshot of the aggregate, or ErrSnapshotNotFound.
+	Load(ctx context.Context, aggregateID vo.ID) (Snapshot, error)
+}
+
+var _ SnapshotStore = &InMemorySnapshotStore{}
+
+// InMemorySnapshotStore is a concurrent-safe SnapshotStore that keeps the snapshots in memory.
+// Its zero value is ready to use.
+type InMemorySnapshotStore struct {
+	mu        sync.RWMutex
+	snapshots map[vo.ID]Snapshot
+}
+
+// Save is the SnapshotStore interface implementation.
+func (s *InMemorySnapshotStore) Save(_ context.Context, snapshot Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.snapshots == nil {
+		s.snapshots = make(map[vo.ID]Snapshot)
+	}
+
+	if last, ok := s.snapshots[snapshot.AggregateID]; ok && last.Version > snapshot.Version {
+		return nil
+	}
+
+	snapshot.Payload = append([]byte(nil), snapshot.Payload...)
+	s.snapshots[snapshot.AggregateID] = snapshot
+
+	return nil
+}
+
+// Load is the SnapshotStore interface implementation.
+func (s *InMemorySnapshotStore) Load(_ context.Context, aggregateID vo.ID) (Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot, ok := s.snapshots[aggregateID]
+	if !ok {
+		return Snapshot{}, fmt.Errorf("%w: aggregate %s", ErrSnapshotNotFound, aggregateID)
+	}
+
+	snapshot.Payload = append([]byte(nil), snapshot.Payload...)
+
+	return snapshot, nil
+}
+
+// SnapshotPolicy decides when an aggregate must be snapshotted.
+type SnapshotPolicy interface {
+	// ShouldSnapshot receives the latest snapshot, zero if there isn't any, and the current version of the aggregate.
+	ShouldSnapshot(last Snapshot, version EventVersion) bool
+}
+
+// SnapshotPolicyFunc is a function that implements SnapshotPolicy.
+type SnapshotPolicyFunc func(last Snapshot, version EventVersion) bool
+
+// ShouldSnapshot is the SnapshotPolicy interface implementation.
+func (f SnapshotPolicyFunc) ShouldSnapshot(last Snapshot, version EventVersion) bool {
+	return f(last, version)
+}
+
+// EveryNEventsSnapshotPolicy snapshots the aggregates once they have n events more than their latest snapshot.
+func EveryNEventsSnapshotPolicy(n int) SnapshotPolicy {
+	return SnapshotPolicyFunc(func(last Snapshot, version EventVersion) bool {
+		return version-last.Version >= EventVersion(n)
+	})
+}
+
+// IntervalSnapshotPolicy snapshots the aggregates that have changed when their latest snapshot is older than
+// the interval.
+func IntervalSnapshotPolicy(interval time.Duration) SnapshotPolicy {
+	return SnapshotPolicyFunc(func(last Snapshot, version EventVersion) bool {
+		return version > last.Version && time.Since(time.Time(last.At)) >= interval
+	})
+}
+
+// Snapshotable is an aggregate that can be snapshotted. AggregateRoot implements every method but
+// the ones of the snapshot state.
+type Snapshotable interface {
+	AggregateID() vo.ID
+	AggregateVersion() EventVersion
+	Restore(version EventVersion)
+	Replay(events ...Event) error
+	// SnapshotSchemaVersion returns the current revision of the snapshot payload format.
+	SnapshotSchemaVersion() SchemaVersion
+	// SnapshotState encodes the state of the aggregate.
+	SnapshotState() ([]byte, error)
+	// RestoreState decodes the state of the aggregate.
+	RestoreState(payload []byte) error
+}
+
+// EventSource returns the events of an aggregate from the given version, included, like EventStore.Load.
+type EventSource func(ctx context.Context, aggregateID vo.ID, fromVersion EventVersion) ([]Event, error)
+
+// SnapshotLoaderOpt is the common type of functions that set options on SnapshotLoader construction.
+type SnapshotLoaderOpt func(l *SnapshotLoader)
+
+// SnapshotLoaderPolicyOpt sets the snapshot policy. The default is EveryNEventsSnapshotPolicy(100).
+func SnapshotLoaderPolicyOpt(policy SnapshotPolicy) SnapshotLoaderOpt {
+	return func(l *SnapshotLoader) {
+		if policy != nil {
+			l.policy = policy
+		}
+	}
+}
+
+const defaultSnapshotEvery = 100
+
+// SnapshotLoader rehydrates the aggregates from their latest snapshot and the events that follow it,
+// and takes new snapshots according to its policy.
+type SnapshotLoader struct {
+	store  SnapshotStore
+	source EventSource
+	policy SnapshotPolicy
+}
+
+// NewSnapshotLoader is a constructor.
+func NewSnapshotLoader(store SnapshotStore, source EventSource, opts ...SnapshotLoaderOpt) *SnapshotLoader {
+	l := &SnapshotLoader{
+		store:  store,
+		source: source,
+		policy: EveryNEventsSnapshotPolicy(defaultSnapshotEvery),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// Load restores the aggregate from its latest snapshot, if any, and replays the events with a greater version.
+// The snapshots of another schema version are discarded.
+func (l *SnapshotLoader) Load(ctx context.Context, agg Snapshotable) error {
+	snapshot, err := l.latest(ctx, agg)
+	if err != nil {
+		return err
+	}
+
+	if snapshot.Version > 0 {
+		if err := agg.RestoreState(snapshot.Payload); err != nil {
+			return fmt.Errorf("restore snapshot of aggregate %s: %w", agg.AggregateID(), err)
+		}
+
+		agg.Restore(snapshot.Version)
+	}
+
+	events, err := l.source(ctx, agg.AggregateID(), snapshot.Version+1)
+	if err != nil {
+		return fmt.Errorf("load events of aggregate %s: %w", agg.AggregateID(), err)
+	}
+
+	return agg.Replay(events...)
+}
+
+// Snapshot takes a snapshot of the aggregate if the policy says so, and reports whether it did.
+// It's meant to be called once the events of the aggregate are stored.
+func (l *SnapshotLoader) Snapshot(ctx context.Context, agg Snapshotable) (bool, error) {
+	last, err := l.latest(ctx, agg)
+	if err != nil {
+		return false, err
+	}
+
+	if !l.policy.ShouldSnapshot(last, agg.AggregateVersion()) {
+		return false, nil
+	}
+
+	payload, err := agg.SnapshotState()
+	if err != nil {
+		return false, fmt.Errorf("snapshot aggregate %s: %w", agg.AggregateID(), err)
+	}
+
+	err = l.store.Save(ctx, Snapshot{
+		AggregateID:   agg.AggregateID(),
+		Version:       agg.AggregateVersion(),
+		SchemaVersion: agg.SnapshotSchemaVersion(),
+		Payload:       payload,
+		At:            vo.DateTimeNow(),
+	})
+	if err != nil {
+		return false, fmt.Errorf("save snapshot of aggregate %s: %w", agg.AggregateID(), err)
+	}
+
+	return true, nil
+}
+
+// latest returns the latest snapshot of the aggregate, or a zero one if there isn't any of its schema version.
+func (l *SnapshotLoader) latest(ctx context.Context, agg Snapshotable) (Snapshot, error) {
+	snapshot, err := l.store.Load(ctx, agg.AggregateID())
+	if errors.Is(err, ErrSnapshotNotFound) {
+		return Snapshot{}, nil
+	}
+
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("load snapshot of aggregate %s: %w", agg.AggregateID(), err)
+	}
+
+	if snapshot.SchemaVersion != agg.SnapshotSchemaVersion() {
+		return Snapshot{}, nil
+	}
+
+	return snapshot, nil
+}
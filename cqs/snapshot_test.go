@@ -0,0 +1,202 @@
+package cqs_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+type accountState struct {
+	Owner   string `json:"owner"`
+	Balance int    `json:"balance"`
+}
+
+func (a *account) SnapshotSchemaVersion() cqs.SchemaVersion {
+	return 1
+}
+
+func (a *account) SnapshotState() ([]byte, error) {
+	return json.Marshal(accountState{Owner: a.owner, Balance: a.balance})
+}
+
+func (a *account) RestoreState(payload []byte) error {
+	var state accountState
+	if err := json.Unmarshal(payload, &state); err != nil {
+		return err
+	}
+
+	a.owner = state.Owner
+	a.balance = state.Balance
+
+	return nil
+}
+
+func TestInMemorySnapshotStore(t *testing.T) {
+	testSnapshotStore(t, func() cqs.SnapshotStore { return &cqs.InMemorySnapshotStore{} })
+}
+
+// testSnapshotStore checks the behavior every SnapshotStore implementation must have.
+func testSnapshotStore(t *testing.T, newStore func() cqs.SnapshotStore) {
+	t.Helper()
+
+	require := require.New(t)
+
+	ctx := context.Background()
+
+	t.Run(`Given a snapshot store,
+	when snapshots are saved,
+	then Load returns the one with the greatest version`, func(t *testing.T) {
+		store := newStore()
+		aggID := vo.NewID()
+
+		_, err := store.Load(ctx, aggID)
+		require.ErrorIs(err, cqs.ErrSnapshotNotFound)
+
+		snapshot := cqs.Snapshot{
+			AggregateID:   aggID,
+			Version:       5,
+			SchemaVersion: 2,
+			Payload:       []byte(`{"balance":5}`),
+			At:            vo.DateTimeNow(),
+		}
+		require.NoError(store.Save(ctx, snapshot))
+		require.NoError(store.Save(ctx, cqs.Snapshot{AggregateID: aggID, Version: 3, SchemaVersion: 2, Payload: []byte(`{}`)}))
+
+		loaded, err := store.Load(ctx, aggID)
+		require.NoError(err)
+		require.Equal(snapshot.AggregateID, loaded.AggregateID)
+		require.Equal(snapshot.Version, loaded.Version)
+		require.Equal(snapshot.SchemaVersion, loaded.SchemaVersion)
+		require.Equal(snapshot.Payload, loaded.Payload)
+		require.True(snapshot.At.Equal(loaded.At))
+
+		require.NoError(store.Save(ctx, cqs.Snapshot{AggregateID: aggID, Version: 8, SchemaVersion: 3, Payload: []byte(`{}`)}))
+
+		loaded, err = store.Load(ctx, aggID)
+		require.NoError(err)
+		require.Equal(cqs.EventVersion(8), loaded.Version)
+		require.Equal(cqs.SchemaVersion(3), loaded.SchemaVersion)
+
+		_, err = store.Load(ctx, vo.NewID())
+		require.ErrorIs(err, cqs.ErrSnapshotNotFound)
+	})
+}
+
+func TestSnapshotPolicies(t *testing.T) {
+	require := require.New(t)
+
+	t.Run(`Given an every N events snapshot policy,
+	when the aggregate has N events more than its snapshot,
+	then it should be snapshotted`, func(t *testing.T) {
+		policy := cqs.EveryNEventsSnapshotPolicy(3)
+
+		require.False(policy.ShouldSnapshot(cqs.Snapshot{}, 2))
+		require.True(policy.ShouldSnapshot(cqs.Snapshot{}, 3))
+		require.False(policy.ShouldSnapshot(cqs.Snapshot{Version: 3}, 5))
+		require.True(policy.ShouldSnapshot(cqs.Snapshot{Version: 3}, 6))
+	})
+
+	t.Run(`Given an interval snapshot policy,
+	when the snapshot of a changed aggregate is older than the interval,
+	then it should be snapshotted`, func(t *testing.T) {
+		policy := cqs.IntervalSnapshotPolicy(time.Hour)
+		recent := vo.DateTimeNow()
+		old := vo.NewDateTime(time.Now().Add(-2 * time.Hour))
+
+		require.True(policy.ShouldSnapshot(cqs.Snapshot{}, 1))
+		require.False(policy.ShouldSnapshot(cqs.Snapshot{Version: 1, At: recent}, 5))
+		require.True(policy.ShouldSnapshot(cqs.Snapshot{Version: 1, At: old}, 5))
+		require.False(policy.ShouldSnapshot(cqs.Snapshot{Version: 5, At: old}, 5))
+	})
+}
+
+func TestSnapshotLoader(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+
+	newAccountWithDeposits := func(t *testing.T, store cqs.EventStore, deposits int) vo.ID {
+		t.Helper()
+
+		id := vo.NewID()
+		acc := newAccount(id)
+		require.NoError(acc.Open("john"))
+
+		for i := 0; i < deposits; i++ {
+			require.NoError(acc.Deposit(1))
+		}
+
+		require.NoError(store.Append(ctx, id, 0, acc.PullEvents()...))
+
+		return id
+	}
+
+	t.Run(`Given an aggregate with a snapshot,
+	when it's loaded,
+	then it's restored from the snapshot and only the following events are replayed`, func(t *testing.T) {
+		var (
+			events    cqs.InMemoryEventStore
+			snapshots cqs.InMemorySnapshotStore
+			from      []cqs.EventVersion
+		)
+
+		source := func(ctx context.Context, id vo.ID, fromVersion cqs.EventVersion) ([]cqs.Event, error) {
+			from = append(from, fromVersion)
+
+			return events.Load(ctx, id, fromVersion)
+		}
+		loader := cqs.NewSnapshotLoader(&snapshots, source, cqs.SnapshotLoaderPolicyOpt(cqs.EveryNEventsSnapshotPolicy(5)))
+		id := newAccountWithDeposits(t, &events, 5)
+
+		acc := newAccount(id)
+		require.NoError(loader.Load(ctx, acc))
+		require.Equal(cqs.EventVersion(6), acc.AggregateVersion())
+
+		taken, err := loader.Snapshot(ctx, acc)
+		require.NoError(err)
+		require.True(taken)
+
+		taken, err = loader.Snapshot(ctx, acc)
+		require.NoError(err)
+		require.False(taken)
+
+		require.NoError(acc.Deposit(10))
+		require.NoError(events.Append(ctx, id, acc.ExpectedVersion(), acc.PullEvents()...))
+
+		restored := newAccount(id)
+		require.NoError(loader.Load(ctx, restored))
+		require.Equal("john", restored.owner)
+		require.Equal(15, restored.balance)
+		require.Equal(cqs.EventVersion(7), restored.AggregateVersion())
+		require.Equal([]cqs.EventVersion{1, 7}, from)
+	})
+
+	t.Run(`Given an aggregate with a snapshot of another schema version,
+	when it's loaded,
+	then the snapshot is discarded and every event is replayed`, func(t *testing.T) {
+		var (
+			events    cqs.InMemoryEventStore
+			snapshots cqs.InMemorySnapshotStore
+		)
+
+		loader := cqs.NewSnapshotLoader(&snapshots, events.Load, cqs.SnapshotLoaderPolicyOpt(cqs.EveryNEventsSnapshotPolicy(5)))
+		id := newAccountWithDeposits(t, &events, 2)
+
+		require.NoError(snapshots.Save(ctx, cqs.Snapshot{AggregateID: id, Version: 3, SchemaVersion: 0, Payload: []byte(`[]`)}))
+
+		acc := newAccount(id)
+		require.NoError(loader.Load(ctx, acc))
+		require.Equal(2, acc.balance)
+		require.Equal(cqs.EventVersion(3), acc.AggregateVersion())
+
+		taken, err := loader.Snapshot(ctx, acc)
+		require.NoError(err)
+		require.False(taken)
+	})
+}
@@ -0,0 +1,100 @@
+package cqs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+const defaultCheckpointStoreTable = "projection_checkpoints"
+
+// SQLCheckpointStoreOpt is the common type of functions that set options on SQLCheckpointStore construction.
+type SQLCheckpointStoreOpt func(s *SQLCheckpointStore)
+
+// SQLCheckpointStoreTableOpt sets the name of the checkpoints table. The default is "projection_checkpoints".
+func SQLCheckpointStoreTableOpt(table string) SQLCheckpointStoreOpt {
+	return func(s *SQLCheckpointStore) {
+		s.table = table
+	}
+}
+
+var _ CheckpointStore = &SQLCheckpointStore{}
+
+// SQLCheckpointStore is a CheckpointStore backed by a database/sql database.
+type SQLCheckpointStore struct {
+	db      *sql.DB
+	dialect SQLDialect
+	table   string
+}
+
+// NewSQLCheckpointStore is a constructor.
+func NewSQLCheckpointStore(db *sql.DB, dialect SQLDialect, opts ...SQLCheckpointStoreOpt) *SQLCheckpointStore {
+	s := &SQLCheckpointStore{
+		db:      db,
+		dialect: dialect,
+		table:   defaultCheckpointStoreTable,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// CheckpointStoreSchemaDialect is implemented by the SQL dialects that provide the table of the SQLCheckpointStore.
+type CheckpointStoreSchemaDialect interface {
+	// CheckpointStoreSchema returns the DDL statements that create the table of the SQLCheckpointStore.
+	CheckpointStoreSchema(table string) []string
+}
+
+// Migrate creates the checkpoints table if it doesn't exist. The dialect must implement CheckpointStoreSchemaDialect.
+func (s *SQLCheckpointStore) Migrate(ctx context.Context) error {
+	sd, ok := s.dialect.(CheckpointStoreSchemaDialect)
+	if !ok {
+		return fmt.Errorf("migrate checkpoint store: %w", ErrUnsupportedSQLSchema)
+	}
+
+	for _, stmt := range sd.CheckpointStoreSchema(s.table) {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migrate checkpoint store: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Load is the CheckpointStore interface implementation.
+func (s *SQLCheckpointStore) Load(ctx context.Context, projection string) (uint64, error) {
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf("SELECT position FROM %s WHERE projection = %s", s.table, s.dialect.Placeholder(1))
+
+	var position int64
+
+	err := s.db.QueryRowContext(ctx, query, projection).Scan(&position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("load checkpoint: %w", err)
+	}
+
+	return uint64(position), nil
+}
+
+// Save is the CheckpointStore interface implementation.
+func (s *SQLCheckpointStore) Save(ctx context.Context, projection string, position uint64) error {
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf(`INSERT INTO %s (projection, position, updated_at) VALUES (%s)
+ON CONFLICT (projection) DO UPDATE SET position = excluded.position, updated_at = excluded.updated_at`,
+		s.table, placeholders(s.dialect, 1, 3))
+
+	if _, err := s.db.ExecContext(ctx, query, projection, int64(position), vo.DateTimeNow()); err != nil {
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,269 @@
+package cqs
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	postgresUniqueViolation     = "23505"
+	sqliteConstraintPrimaryKey  = 1555
+	sqliteConstraintUnique      = 2067
+	sqliteUniqueViolationPrefix = "UNIQUE constraint failed"
+)
+
+var ErrUnsupportedSQLSchema = errors.New("unsupported sql schema")
+
+// SQLDialect adapts the SQL statements of the SQL backed stores to a database engine.
+// The DDL of each store is provided by its own optional interface, like EventStoreSchemaDialect, so a dialect only
+// implements the schemas of the stores it's used with. Without it, the store can't be migrated.
+type SQLDialect interface {
+	// Placeholder returns the bind parameter of the nth argument, starting by 1.
+	Placeholder(n int) string
+	// IsUniqueViolation reports whether the error is caused by a unique constraint.
+	IsUniqueViolation(err error) bool
+}
+
+var (
+	_ SQLDialect                   = PostgresDialect{}
+	_ EventStoreSchemaDialect      = PostgresDialect{}
+	_ SnapshotStoreSchemaDialect   = PostgresDialect{}
+	_ OutboxSchemaDialect          = PostgresDialect{}
+	_ ProcessedStoreSchemaDialect  = PostgresDialect{}
+	_ CheckpointStoreSchemaDialect = PostgresDialect{}
+	_ SagaStoreSchemaDialect       = PostgresDialect{}
+)
+
+// PostgresDialect is the SQLDialect for PostgreSQL.
+type PostgresDialect struct{}
+
+// Placeholder is the SQLDialect interface implementation.
+func (PostgresDialect) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// IsUniqueViolation is the SQLDialect interface implementation.
+// It only supports the errors of the drivers that expose the SQLSTATE code, like pgx and lib/pq.
+func (PostgresDialect) IsUniqueViolation(err error) bool {
+	var sqlStateErr interface{ SQLState() string }
+
+	return errors.As(err, &sqlStateErr) && sqlStateErr.SQLState() == postgresUniqueViolation
+}
+
+// EventStoreSchema is the EventStoreSchemaDialect interface implementation.
+func (PostgresDialect) EventStoreSchema(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	position BIGSERIAL PRIMARY KEY,
+	event_id UUID NOT NULL UNIQUE,
+	aggregate_id UUID NOT NULL,
+	version INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	at TIMESTAMPTZ NOT NULL,
+	payload BYTEA NOT NULL,
+	UNIQUE (aggregate_id, version)
+)`, table),
+	}
+}
+
+// SnapshotStoreSchema is the SnapshotStoreSchemaDialect interface implementation.
+func (PostgresDialect) SnapshotStoreSchema(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	aggregate_id UUID PRIMARY KEY,
+	version INTEGER NOT NULL,
+	schema_version INTEGER NOT NULL,
+	at TIMESTAMPTZ NOT NULL,
+	payload BYTEA NOT NULL
+)`, table),
+	}
+}
+
+// OutboxSchema is the OutboxSchemaDialect interface implementation.
+func (PostgresDialect) OutboxSchema(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id BIGSERIAL PRIMARY KEY,
+	event_id UUID NOT NULL,
+	name TEXT NOT NULL,
+	payload BYTEA NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	published_at TIMESTAMPTZ,
+	failed_at TIMESTAMPTZ
+)`, table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %[1]s_pending_idx ON %[1]s (id) WHERE published_at IS NULL AND failed_at IS NULL", table),
+	}
+}
+
+// ProcessedStoreSchema is the ProcessedStoreSchemaDialect interface implementation.
+func (PostgresDialect) ProcessedStoreSchema(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	handler_name TEXT NOT NULL,
+	event_id UUID NOT NULL,
+	processed_at BIGINT NOT NULL,
+	PRIMARY KEY (handler_name, event_id)
+)`, table),
+	}
+}
+
+// CheckpointStoreSchema is the CheckpointStoreSchemaDialect interface implementation.
+func (PostgresDialect) CheckpointStoreSchema(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	projection TEXT PRIMARY KEY,
+	position BIGINT NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+)`, table),
+	}
+}
+
+// SagaStoreSchema is the SagaStoreSchemaDialect interface implementation.
+func (PostgresDialect) SagaStoreSchema(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	saga TEXT NOT NULL,
+	saga_id TEXT NOT NULL,
+	status TEXT NOT NULL,
+	state BYTEA NOT NULL,
+	steps BYTEA NOT NULL,
+	timeouts BYTEA NOT NULL,
+	next_timeout_at BIGINT,
+	version INTEGER NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (saga, saga_id)
+)`, table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %[1]s_timeouts_idx ON %[1]s (saga, next_timeout_at) WHERE status = 'running'", table),
+	}
+}
+
+var (
+	_ SQLDialect                   = SQLiteDialect{}
+	_ EventStoreSchemaDialect      = SQLiteDialect{}
+	_ SnapshotStoreSchemaDialect   = SQLiteDialect{}
+	_ OutboxSchemaDialect          = SQLiteDialect{}
+	_ ProcessedStoreSchemaDialect  = SQLiteDialect{}
+	_ CheckpointStoreSchemaDialect = SQLiteDialect{}
+	_ SagaStoreSchemaDialect       = SQLiteDialect{}
+)
+
+// SQLiteDialect is the SQLDialect for SQLite.
+type SQLiteDialect struct{}
+
+// Placeholder is the SQLDialect interface implementation.
+func (SQLiteDialect) Placeholder(int) string {
+	return "?"
+}
+
+// IsUniqueViolation is the SQLDialect interface implementation.
+func (SQLiteDialect) IsUniqueViolation(err error) bool {
+	var codeErr interface{ Code() int }
+	if errors.As(err, &codeErr) {
+		return codeErr.Code() == sqliteConstraintUnique || codeErr.Code() == sqliteConstraintPrimaryKey
+	}
+
+	return err != nil && strings.Contains(err.Error(), sqliteUniqueViolationPrefix)
+}
+
+// EventStoreSchema is the EventStoreSchemaDialect interface implementation.
+func (SQLiteDialect) EventStoreSchema(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	position INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_id TEXT NOT NULL UNIQUE,
+	aggregate_id TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	at TEXT NOT NULL,
+	payload BLOB NOT NULL,
+	UNIQUE (aggregate_id, version)
+)`, table),
+	}
+}
+
+// SnapshotStoreSchema is the SnapshotStoreSchemaDialect interface implementation.
+func (SQLiteDialect) SnapshotStoreSchema(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	aggregate_id TEXT PRIMARY KEY,
+	version INTEGER NOT NULL,
+	schema_version INTEGER NOT NULL,
+	at TEXT NOT NULL,
+	payload BLOB NOT NULL
+)`, table),
+	}
+}
+
+// OutboxSchema is the OutboxSchemaDialect interface implementation.
+func (SQLiteDialect) OutboxSchema(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	payload BLOB NOT NULL,
+	created_at TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	published_at TEXT,
+	failed_at TEXT
+)`, table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %[1]s_pending_idx ON %[1]s (id) WHERE published_at IS NULL AND failed_at IS NULL", table),
+	}
+}
+
+// ProcessedStoreSchema is the ProcessedStoreSchemaDialect interface implementation.
+func (SQLiteDialect) ProcessedStoreSchema(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	handler_name TEXT NOT NULL,
+	event_id TEXT NOT NULL,
+	processed_at INTEGER NOT NULL,
+	PRIMARY KEY (handler_name, event_id)
+)`, table),
+	}
+}
+
+// CheckpointStoreSchema is the CheckpointStoreSchemaDialect interface implementation.
+func (SQLiteDialect) CheckpointStoreSchema(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	projection TEXT PRIMARY KEY,
+	position INTEGER NOT NULL,
+	updated_at TEXT NOT NULL
+)`, table),
+	}
+}
+
+// SagaStoreSchema is the SagaStoreSchemaDialect interface implementation.
+func (SQLiteDialect) SagaStoreSchema(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	saga TEXT NOT NULL,
+	saga_id TEXT NOT NULL,
+	status TEXT NOT NULL,
+	state BLOB NOT NULL,
+	steps BLOB NOT NULL,
+	timeouts BLOB NOT NULL,
+	next_timeout_at INTEGER,
+	version INTEGER NOT NULL,
+	updated_at TEXT NOT NULL,
+	PRIMARY KEY (saga, saga_id)
+)`, table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %[1]s_timeouts_idx ON %[1]s (saga, next_timeout_at) WHERE status = 'running'", table),
+	}
+}
+
+// placeholders returns the bind parameters from the nth argument to the nth+count-1 one, joined by commas.
+func placeholders(dialect SQLDialect, n, count int) string {
+	ps := make([]string, count)
+	for i := range ps {
+		ps[i] = dialect.Placeholder(n + i)
+	}
+
+	return strings.Join(ps, ", ")
+}
@@ -0,0 +1,249 @@
+package cqs_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+// recordingDB is a database/sql driver that records the statements it receives, to test the SQL of the dialects
+// without their database. The queries return no rows, except the aggregates, which return 0.
+type recordingDB struct {
+	mu         sync.Mutex
+	statements []string
+	// insertErr is returned by the INSERT statements.
+	insertErr error
+}
+
+func newRecordingDB(t *testing.T) (*sql.DB, *recordingDB) {
+	t.Helper()
+
+	rec := &recordingDB{}
+	db := sql.OpenDB(rec)
+	t.Cleanup(func() { _ = db.Close() })
+
+	return db, rec
+}
+
+func (r *recordingDB) Statements() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.statements...)
+}
+
+func (r *recordingDB) record(query string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.statements = append(r.statements, query)
+}
+
+func (r *recordingDB) Connect(context.Context) (driver.Conn, error) { return r, nil }
+
+func (r *recordingDB) Driver() driver.Driver { return r }
+
+func (r *recordingDB) Open(string) (driver.Conn, error) { return r, nil }
+
+func (r *recordingDB) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+
+func (r *recordingDB) Close() error { return nil }
+
+func (r *recordingDB) Begin() (driver.Tx, error) { return r, nil }
+
+func (r *recordingDB) Commit() error { return nil }
+
+func (r *recordingDB) Rollback() error { return nil }
+
+func (r *recordingDB) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	r.record(query)
+
+	if r.insertErr != nil && strings.HasPrefix(query, "INSERT") {
+		return nil, r.insertErr
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+func (r *recordingDB) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	r.record(query)
+
+	if strings.Contains(query, "COALESCE") {
+		return &recordedRows{values: [][]driver.Value{{int64(0)}}}, nil
+	}
+
+	return &recordedRows{}, nil
+}
+
+type recordedRows struct {
+	values [][]driver.Value
+}
+
+func (r *recordedRows) Columns() []string { return []string{"value"} }
+
+func (r *recordedRows) Close() error { return nil }
+
+func (r *recordedRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+
+	return nil
+}
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string { return "sql state " + string(e) }
+
+func (e sqlStateError) SQLState() string { return string(e) }
+
+// placeholdersDialect is a dialect that provides no schema.
+type placeholdersDialect struct{}
+
+func (placeholdersDialect) Placeholder(n int) string { return fmt.Sprintf(":%d", n) }
+
+func (placeholdersDialect) IsUniqueViolation(error) bool { return false }
+
+func TestPostgresDialect(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+	dialect := cqs.PostgresDialect{}
+
+	t.Run(`Given the Postgres dialect,
+	when the placeholders are built,
+	then they are numbered`, func(t *testing.T) {
+		require.Equal("$1", dialect.Placeholder(1))
+		require.Equal("$12", dialect.Placeholder(12))
+	})
+
+	t.Run(`Given the Postgres dialect,
+	when errors are checked,
+	then only the unique violations are reported, by their SQLSTATE`, func(t *testing.T) {
+		require.True(dialect.IsUniqueViolation(sqlStateError("23505")))
+		require.True(dialect.IsUniqueViolation(fmt.Errorf("insert: %w", sqlStateError("23505"))))
+		require.False(dialect.IsUniqueViolation(sqlStateError("23503")))
+		require.False(dialect.IsUniqueViolation(errors.New(`duplicate key value violates unique constraint (SQLSTATE 23505)`)))
+		require.False(dialect.IsUniqueViolation(errors.New("order 23505 not found")))
+		require.False(dialect.IsUniqueViolation(errors.New("connection refused")))
+		require.False(dialect.IsUniqueViolation(nil))
+	})
+
+	t.Run(`Given the SQL stores with the Postgres dialect,
+	when they are migrated,
+	then the Postgres DDL is executed`, func(t *testing.T) {
+		db, rec := newRecordingDB(t)
+		store, err := cqs.NewSQLEventStore(db, dialect, cqs.BasicEventCodec{})
+		require.NoError(err)
+
+		require.NoError(store.Migrate(ctx))
+		require.NoError(cqs.NewSQLSnapshotStore(db, dialect).Migrate(ctx))
+		outbox, err := cqs.NewSQLOutbox(db, dialect, cqs.BasicEventCodec{})
+		require.NoError(err)
+		require.NoError(outbox.Migrate(ctx))
+		require.NoError(cqs.NewSQLProcessedStore(db, dialect).Migrate(ctx))
+		require.NoError(cqs.NewSQLCheckpointStore(db, dialect).Migrate(ctx))
+		require.NoError(cqs.NewSQLSagaStore(db, dialect).Migrate(ctx))
+
+		statements := rec.Statements()
+		require.Len(statements, 8)
+
+		for _, stmt := range statements {
+			require.NotContains(stmt, "AUTOINCREMENT")
+			require.NotContains(stmt, "BLOB")
+		}
+
+		require.Contains(statements[0], "CREATE TABLE IF NOT EXISTS events")
+		require.Contains(statements[0], "position BIGSERIAL PRIMARY KEY")
+		require.Contains(statements[0], "at TIMESTAMPTZ NOT NULL")
+		require.Contains(statements[0], "payload BYTEA NOT NULL")
+		require.Contains(statements[0], "UNIQUE (aggregate_id, version)")
+	})
+
+	t.Run(`Given an SQL event store with the Postgres dialect,
+	when events are appended and loaded,
+	then the statements use numbered placeholders`, func(t *testing.T) {
+		db, rec := newRecordingDB(t)
+		store, err := cqs.NewSQLEventStore(db, dialect, cqs.BasicEventCodec{})
+		require.NoError(err)
+
+		aggID := vo.NewID()
+		require.NoError(store.Append(ctx, aggID, 0, newAggregateEvent(aggID, "foo", 1)))
+
+		_, err = store.Load(ctx, aggID, 1)
+		require.NoError(err)
+
+		require.Equal([]string{
+			"SELECT COALESCE(MAX(version), 0) FROM events WHERE aggregate_id = $1",
+			"INSERT INTO events (event_id, aggregate_id, version, name, at, payload) VALUES ($1, $2, $3, $4, $5, $6)",
+			"SELECT payload FROM events WHERE aggregate_id = $1 AND version >= $2 ORDER BY version",
+		}, rec.Statements())
+	})
+
+	t.Run(`Given an SQL event store with the Postgres dialect,
+	when the insert fails with a unique violation,
+	then Append returns a concurrency conflict`, func(t *testing.T) {
+		db, rec := newRecordingDB(t)
+		rec.insertErr = sqlStateError("23505")
+		store, err := cqs.NewSQLEventStore(db, dialect, cqs.BasicEventCodec{})
+		require.NoError(err)
+
+		aggID := vo.NewID()
+		err = store.Append(ctx, aggID, 0, newAggregateEvent(aggID, "foo", 1))
+
+		var conflict cqs.ConcurrencyConflictError
+		require.ErrorAs(err, &conflict)
+		require.Equal(aggID, conflict.AggregateID)
+	})
+}
+
+func TestSQLDialectSchemas(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+
+	t.Run(`Given no codec,
+	when an SQL event store is created,
+	then it returns an ErrEmptyEventCodec`, func(t *testing.T) {
+		_, err := cqs.NewSQLEventStore(newSQLiteDB(t), cqs.SQLiteDialect{}, nil)
+		require.ErrorIs(err, cqs.ErrEmptyEventCodec)
+	})
+
+	t.Run(`Given a dialect that only provides the placeholders and the unique violations,
+	when the SQL stores are used,
+	then they work but can't be migrated`, func(t *testing.T) {
+		db, rec := newRecordingDB(t)
+		dialect := placeholdersDialect{}
+		store, err := cqs.NewSQLEventStore(db, dialect, cqs.BasicEventCodec{})
+		require.NoError(err)
+
+		require.ErrorIs(store.Migrate(ctx), cqs.ErrUnsupportedSQLSchema)
+		require.ErrorIs(cqs.NewSQLSnapshotStore(db, dialect).Migrate(ctx), cqs.ErrUnsupportedSQLSchema)
+		outbox, err := cqs.NewSQLOutbox(db, dialect, cqs.BasicEventCodec{})
+		require.NoError(err)
+		require.ErrorIs(outbox.Migrate(ctx), cqs.ErrUnsupportedSQLSchema)
+		require.ErrorIs(cqs.NewSQLProcessedStore(db, dialect).Migrate(ctx), cqs.ErrUnsupportedSQLSchema)
+		require.ErrorIs(cqs.NewSQLCheckpointStore(db, dialect).Migrate(ctx), cqs.ErrUnsupportedSQLSchema)
+		require.ErrorIs(cqs.NewSQLSagaStore(db, dialect).Migrate(ctx), cqs.ErrUnsupportedSQLSchema)
+		require.Empty(rec.Statements())
+
+		_, err = store.Load(ctx, vo.NewID(), 1)
+		require.NoError(err)
+		require.Equal([]string{"SELECT payload FROM events WHERE aggregate_id = :1 AND version >= :2 ORDER BY version"},
+			rec.Statements())
+	})
+}
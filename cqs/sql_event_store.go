@@ -0,0 +1,256 @@
+package cqs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+const defaultEventStoreTable = "events"
+
+// SQLEventStoreOpt is the common type of functions that set options on SQLEventStore construction.
+type SQLEventStoreOpt func(s *SQLEventStore)
+
+// SQLEventStoreTableOpt sets the name of the events table. The default is "events".
+func SQLEventStoreTableOpt(table string) SQLEventStoreOpt {
+	return func(s *SQLEventStore) {
+		s.table = table
+	}
+}
+
+var _ EventStore = &SQLEventStore{}
+
+// SQLEventStore is an EventStore backed by a database/sql database.
+// The unique (aggregate_id, version) constraint guarantees the optimistic concurrency across processes.
+//
+// The positions are assigned by the database when inserting, so with several concurrent writers a
+// LoadAll could miss an event with a lower position committed after a greater one. The ProjectionRunner waits
+// for those gaps.
+type SQLEventStore struct {
+	db      *sql.DB
+	dialect SQLDialect
+	table   string
+	codec   EventCodec
+}
+
+// NewSQLEventStore is a constructor. The codec must decode the concrete event types, like an EventRegistry,
+// so the loaded events can be applied to the aggregates and projections.
+func NewSQLEventStore(db *sql.DB, dialect SQLDialect, codec EventCodec, opts ...SQLEventStoreOpt) (*SQLEventStore, error) {
+	if codec == nil {
+		return nil, ErrEmptyEventCodec
+	}
+
+	s := &SQLEventStore{
+		db:      db,
+		dialect: dialect,
+		table:   defaultEventStoreTable,
+		codec:   codec,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s, nil
+}
+
+// EventStoreSchemaDialect is implemented by the SQL dialects that provide the table of the SQLEventStore.
+type EventStoreSchemaDialect interface {
+	// EventStoreSchema returns the DDL statements that create the table of the SQLEventStore.
+	EventStoreSchema(table string) []string
+}
+
+// Migrate creates the events table if it doesn't exist. The dialect must implement EventStoreSchemaDialect.
+func (s *SQLEventStore) Migrate(ctx context.Context) error {
+	sd, ok := s.dialect.(EventStoreSchemaDialect)
+	if !ok {
+		return fmt.Errorf("migrate event store: %w", ErrUnsupportedSQLSchema)
+	}
+
+	for _, stmt := range sd.EventStoreSchema(s.table) {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migrate event store: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Append is the EventStore interface implementation.
+func (s *SQLEventStore) Append(ctx context.Context, aggregateID vo.ID, expectedVersion EventVersion, events ...Event) error {
+	if err := validateAppend(aggregateID, expectedVersion, events); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("append events: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	version, err := s.version(ctx, tx, aggregateID)
+	if err != nil {
+		return err
+	}
+
+	if version != expectedVersion {
+		return ConcurrencyConflictError{AggregateID: aggregateID, Expected: expectedVersion, Actual: version}
+	}
+
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf("INSERT INTO %s (event_id, aggregate_id, version, name, at, payload) VALUES (%s)",
+		s.table, placeholders(s.dialect, 1, 6))
+
+	for _, ev := range events {
+		payload, err := s.codec.Marshal(ev)
+		if err != nil {
+			return fmt.Errorf("marshal event %s: %w", ev.EventID(), err)
+		}
+
+		_, err = tx.ExecContext(ctx, query, ev.EventID(), aggregateID, int(ev.EventVersion()), string(ev.EventName()), ev.EventAt(), payload)
+		if s.dialect.IsUniqueViolation(err) {
+			_ = tx.Rollback()
+
+			return s.conflict(ctx, aggregateID, expectedVersion)
+		}
+
+		if err != nil {
+			return fmt.Errorf("insert event %s: %w", ev.EventID(), err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		if s.dialect.IsUniqueViolation(err) {
+			return s.conflict(ctx, aggregateID, expectedVersion)
+		}
+
+		return fmt.Errorf("append events: %w", err)
+	}
+
+	return nil
+}
+
+// Load is the EventStore interface implementation.
+func (s *SQLEventStore) Load(ctx context.Context, aggregateID vo.ID, fromVersion EventVersion) ([]Event, error) {
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf("SELECT payload FROM %s WHERE aggregate_id = %s AND version >= %s ORDER BY version",
+		s.table, s.dialect.Placeholder(1), s.dialect.Placeholder(2))
+
+	rows, err := s.db.QueryContext(ctx, query, aggregateID, int(fromVersion))
+	if err != nil {
+		return nil, fmt.Errorf("load events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []Event
+
+	for rows.Next() {
+		var payload []byte
+		if err := rows.Scan(&payload); err != nil {
+			return nil, fmt.Errorf("scan event: %w", err)
+		}
+
+		ev, err := s.codec.Unmarshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal event: %w", err)
+		}
+
+		events = append(events, ev)
+	}
+
+	return events, rows.Err()
+}
+
+// LoadAll is the EventStore interface implementation.
+func (s *SQLEventStore) LoadAll(ctx context.Context, fromPosition uint64) ([]StoredEvent, error) {
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf("SELECT position, payload FROM %s WHERE position >= %s ORDER BY position",
+		s.table, s.dialect.Placeholder(1))
+
+	return s.loadStored(ctx, query, int64(fromPosition))
+}
+
+// LoadBatch is the OrderedEventSource interface implementation.
+func (s *SQLEventStore) LoadBatch(ctx context.Context, fromPosition uint64, limit int) ([]StoredEvent, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidBatchLimit, limit)
+	}
+
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf("SELECT position, payload FROM %s WHERE position >= %s ORDER BY position LIMIT %s",
+		s.table, s.dialect.Placeholder(1), s.dialect.Placeholder(2))
+
+	return s.loadStored(ctx, query, int64(fromPosition), limit)
+}
+
+// loadStored returns the events read by the query, with their position.
+func (s *SQLEventStore) loadStored(ctx context.Context, query string, args ...any) ([]StoredEvent, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("load all events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []StoredEvent
+
+	for rows.Next() {
+		var (
+			position int64
+			payload  []byte
+		)
+
+		if err := rows.Scan(&position, &payload); err != nil {
+			return nil, fmt.Errorf("scan event: %w", err)
+		}
+
+		ev, err := s.codec.Unmarshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal event at position %d: %w", position, err)
+		}
+
+		events = append(events, StoredEvent{Position: uint64(position), Event: ev})
+	}
+
+	return events, rows.Err()
+}
+
+// LastPosition returns the position of the last event, or 0 if the store is empty.
+func (s *SQLEventStore) LastPosition(ctx context.Context) (uint64, error) {
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf("SELECT COALESCE(MAX(position), 0) FROM %s", s.table)
+
+	var position int64
+	if err := s.db.QueryRowContext(ctx, query).Scan(&position); err != nil {
+		return 0, fmt.Errorf("read last position: %w", err)
+	}
+
+	return uint64(position), nil
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (s *SQLEventStore) version(ctx context.Context, q queryRower, aggregateID vo.ID) (EventVersion, error) {
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf("SELECT COALESCE(MAX(version), 0) FROM %s WHERE aggregate_id = %s", s.table, s.dialect.Placeholder(1))
+
+	var version int
+	if err := q.QueryRowContext(ctx, query, aggregateID).Scan(&version); err != nil {
+		return 0, fmt.Errorf("read stream version: %w", err)
+	}
+
+	return EventVersion(version), nil
+}
+
+// conflict builds the error of a unique constraint violation, caused by a concurrent append.
+// It must be called once the transaction is finished.
+func (s *SQLEventStore) conflict(ctx context.Context, aggregateID vo.ID, expectedVersion EventVersion) error {
+	actual, err := s.version(ctx, s.db, aggregateID)
+	if err != nil {
+		actual = expectedVersion + 1
+	}
+
+	return ConcurrencyConflictError{AggregateID: aggregateID, Expected: expectedVersion, Actual: actual}
+}
@@ -0,0 +1,67 @@
+package cqs_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+
+	"github.com/lucianogarciaz/kit/cqs"
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+// newSQLiteDB returns an in-memory SQLite database shared by all its connections.
+func newSQLiteDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=memory&cache=shared", vo.NewID()))
+	require.NoError(t, err)
+
+	// SQLite allows a single writer, so the connections are serialized to avoid SQLITE_BUSY errors.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	return db
+}
+
+func TestSQLEventStore(t *testing.T) {
+	testEventStore(t, func() cqs.EventStore {
+		store, err := cqs.NewSQLEventStore(newSQLiteDB(t), cqs.SQLiteDialect{}, cqs.BasicEventCodec{},
+			cqs.SQLEventStoreTableOpt("domain_events"))
+		require.NoError(t, err)
+		require.NoError(t, store.Migrate(context.Background()))
+
+		return store
+	})
+}
+
+func TestSQLiteDialectIsUniqueViolation(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+
+	t.Run(`Given a SQLite event store with an event,
+	when the same aggregate version is inserted again,
+	then the error is a unique violation`, func(t *testing.T) {
+		db := newSQLiteDB(t)
+		dialect := cqs.SQLiteDialect{}
+		store, err := cqs.NewSQLEventStore(db, dialect, cqs.BasicEventCodec{})
+		require.NoError(err)
+		require.NoError(store.Migrate(ctx))
+		require.NoError(store.Migrate(ctx))
+
+		aggID := vo.NewID()
+		insert := "INSERT INTO events (event_id, aggregate_id, version, name, at, payload) VALUES (?, ?, 1, 'foo', ?, '{}')"
+
+		_, err = db.ExecContext(ctx, insert, vo.NewID(), aggID, vo.DateTimeNow())
+		require.NoError(err)
+		require.False(dialect.IsUniqueViolation(err))
+
+		_, err = db.ExecContext(ctx, insert, vo.NewID(), aggID, vo.DateTimeNow())
+		require.Error(err)
+		require.True(dialect.IsUniqueViolation(err))
+	})
+}
@@ -0,0 +1,374 @@
+package cqs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+const (
+	defaultOutboxTable             = "outbox"
+	defaultOutboxRelayBatchSize    = 100
+	defaultOutboxRelayPollInterval = time.Second
+)
+
+// SQLExecer executes statements. It's implemented by *sql.DB, *sql.Tx and *sql.Conn.
+type SQLExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// SQLOutboxOpt is the common type of functions that set options on SQLOutbox construction.
+type SQLOutboxOpt func(o *SQLOutbox)
+
+// SQLOutboxTableOpt sets the name of the outbox table. The default is "outbox".
+func SQLOutboxTableOpt(table string) SQLOutboxOpt {
+	return func(o *SQLOutbox) {
+		o.table = table
+	}
+}
+
+// SQLOutbox stores the events to publish in a database/sql table, in the same transaction that persists the
+// changes that produced them, so both are committed or discarded together. An OutboxRelay publishes them.
+type SQLOutbox struct {
+	db      *sql.DB
+	dialect SQLDialect
+	table   string
+	codec   EventCodec
+}
+
+// NewSQLOutbox is a constructor. The codec must decode the concrete event types, like an EventRegistry,
+// so the handlers receive the events that were written.
+func NewSQLOutbox(db *sql.DB, dialect SQLDialect, codec EventCodec, opts ...SQLOutboxOpt) (*SQLOutbox, error) {
+	if codec == nil {
+		return nil, ErrEmptyEventCodec
+	}
+
+	o := &SQLOutbox{
+		db:      db,
+		dialect: dialect,
+		table:   defaultOutboxTable,
+		codec:   codec,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o, nil
+}
+
+// OutboxSchemaDialect is implemented by the SQL dialects that provide the table of the SQLOutbox.
+type OutboxSchemaDialect interface {
+	// OutboxSchema returns the DDL statements that create the table of the SQLOutbox.
+	OutboxSchema(table string) []string
+}
+
+// Migrate creates the outbox table if it doesn't exist. The dialect must implement OutboxSchemaDialect.
+func (o *SQLOutbox) Migrate(ctx context.Context) error {
+	sd, ok := o.dialect.(OutboxSchemaDialect)
+	if !ok {
+		return fmt.Errorf("migrate outbox: %w", ErrUnsupportedSQLSchema)
+	}
+
+	for _, stmt := range sd.OutboxSchema(o.table) {
+		if _, err := o.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migrate outbox: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Write stores the events in the outbox using the given executor, usually the caller's *sql.Tx.
+func (o *SQLOutbox) Write(ctx context.Context, tx SQLExecer, events ...Event) error {
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf("INSERT INTO %s (event_id, name, payload, created_at) VALUES (%s)",
+		o.table, placeholders(o.dialect, 1, 4))
+
+	for _, ev := range events {
+		payload, err := o.codec.Marshal(ev)
+		if err != nil {
+			return fmt.Errorf("marshal event %s: %w", ev.EventID(), err)
+		}
+
+		_, err = tx.ExecContext(ctx, query, ev.EventID(), string(ev.EventName()), payload, vo.DateTimeNow())
+		if err != nil {
+			return fmt.Errorf("write event %s to outbox: %w", ev.EventID(), err)
+		}
+	}
+
+	return nil
+}
+
+// RequeueFailed makes the messages that the relay gave up on pending again, with their attempts reset,
+// and returns how many of them there were.
+func (o *SQLOutbox) RequeueFailed(ctx context.Context) (int, error) {
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf("UPDATE %s SET failed_at = NULL, attempts = 0 WHERE published_at IS NULL AND failed_at IS NOT NULL",
+		o.table)
+
+	res, err := o.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("requeue failed outbox messages: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("requeue failed outbox messages: %w", err)
+	}
+
+	return int(n), nil
+}
+
+type outboxMessage struct {
+	id        int64
+	eventID   vo.ID
+	eventName EventName
+	payload   []byte
+	attempts  int
+}
+
+// OutboxMessageError is the error of an outbox message whose event can't be decoded.
+// The error sink receives a BasicEvent with the ID and name of the event instead.
+type OutboxMessageError struct {
+	MessageID int64
+	EventID   vo.ID
+	EventName EventName
+	Err       error
+}
+
+// Error implements the Error interface.
+func (e OutboxMessageError) Error() string {
+	return fmt.Sprintf("outbox message %d with event %s %s: %s", e.MessageID, e.EventName, e.EventID, e.Err)
+}
+
+// Unwrap returns the decoding error.
+func (e OutboxMessageError) Unwrap() error {
+	return e.Err
+}
+
+// pending returns the oldest messages that are neither published nor failed.
+func (o *SQLOutbox) pending(ctx context.Context, limit int) ([]outboxMessage, error) {
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf("SELECT id, event_id, name, payload, attempts FROM %s WHERE published_at IS NULL AND failed_at IS NULL ORDER BY id LIMIT %s",
+		o.table, o.dialect.Placeholder(1))
+
+	rows, err := o.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("read outbox: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []outboxMessage
+
+	for rows.Next() {
+		var (
+			m    outboxMessage
+			name string
+		)
+
+		if err := rows.Scan(&m.id, &m.eventID, &name, &m.payload, &m.attempts); err != nil {
+			return nil, fmt.Errorf("scan outbox message: %w", err)
+		}
+
+		m.eventName = EventName(name)
+		messages = append(messages, m)
+	}
+
+	return messages, rows.Err()
+}
+
+func (o *SQLOutbox) markPublished(ctx context.Context, id int64) error {
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf("UPDATE %s SET published_at = %s, attempts = attempts + 1, last_error = NULL WHERE id = %s",
+		o.table, o.dialect.Placeholder(1), o.dialect.Placeholder(2))
+
+	if _, err := o.db.ExecContext(ctx, query, vo.DateTimeNow(), id); err != nil {
+		return fmt.Errorf("mark outbox message %d as published: %w", id, err)
+	}
+
+	return nil
+}
+
+// markFailed records the failed attempt. When the message is parked, it isn't pending anymore.
+func (o *SQLOutbox) markFailed(ctx context.Context, id int64, cause error, park bool) error {
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf("UPDATE %s SET attempts = attempts + 1, last_error = %s, failed_at = %s WHERE id = %s",
+		o.table, o.dialect.Placeholder(1), o.dialect.Placeholder(2), o.dialect.Placeholder(3))
+
+	var failedAt any
+	if park {
+		failedAt = vo.DateTimeNow()
+	}
+
+	if _, err := o.db.ExecContext(ctx, query, cause.Error(), failedAt, id); err != nil {
+		return fmt.Errorf("mark outbox message %d as failed: %w", id, err)
+	}
+
+	return nil
+}
+
+// OutboxRelayOpt is the common type of functions that set options on OutboxRelay construction.
+type OutboxRelayOpt func(r *OutboxRelay)
+
+// OutboxRelayBatchSizeOpt sets the maximum number of messages read on each poll. The default is 100.
+func OutboxRelayBatchSizeOpt(size int) OutboxRelayOpt {
+	return func(r *OutboxRelay) {
+		if size > 0 {
+			r.batchSize = size
+		}
+	}
+}
+
+// OutboxRelayPollIntervalOpt sets the time to wait for new messages once the outbox is empty. The default is 1s.
+func OutboxRelayPollIntervalOpt(interval time.Duration) OutboxRelayOpt {
+	return func(r *OutboxRelay) {
+		if interval > 0 {
+			r.pollInterval = interval
+		}
+	}
+}
+
+// OutboxRelayRetryOpt sets the policy of the failed messages. A message is retried while it has attempts left and
+// its error is retryable, waiting for the backoff after each consecutive failure with the policy's Sleeper.
+// The default is NewRetryPolicy().
+func OutboxRelayRetryOpt(policy RetryPolicy) OutboxRelayOpt {
+	return func(r *OutboxRelay) {
+		r.policy = policy
+	}
+}
+
+// OutboxRelayErrorSinkOpt sets the sink that receives the errors of Run and the messages the relay gives up on.
+// By default, they are discarded.
+func OutboxRelayErrorSinkOpt(sink ErrorSink) OutboxRelayOpt {
+	return func(r *OutboxRelay) {
+		if sink != nil {
+			r.sink = sink
+		}
+	}
+}
+
+// OutboxRelay publishes the events of an SQLOutbox to an EventsBus, in the order they were written.
+// A message is marked as published once it's dispatched, so the delivery is at-least-once: if the relay stops
+// before marking it, the event is dispatched again. A message that fails blocks the following ones while the retry
+// policy retries it, so the order is kept. Once its attempts run out, or its error isn't retryable, the message is
+// marked as failed and reported to the error sink as a RetryError, and the following ones are published.
+// SQLOutbox.RequeueFailed makes the failed messages pending again. Run a single relay per outbox table.
+type OutboxRelay struct {
+	outbox       *SQLOutbox
+	bus          EventsBus
+	batchSize    int
+	pollInterval time.Duration
+	policy       RetryPolicy
+	sink         ErrorSink
+}
+
+// NewOutboxRelay is a constructor.
+func NewOutboxRelay(outbox *SQLOutbox, bus EventsBus, opts ...OutboxRelayOpt) *OutboxRelay {
+	r := &OutboxRelay{
+		outbox:       outbox,
+		bus:          bus,
+		batchSize:    defaultOutboxRelayBatchSize,
+		pollInterval: defaultOutboxRelayPollInterval,
+		policy:       NewRetryPolicy(),
+		sink:         noopErrorSink{},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// RunOnce publishes a batch of pending messages and returns how many of them were published.
+// It stops at the first message that fails and is retried.
+func (r *OutboxRelay) RunOnce(ctx context.Context) (int, error) {
+	published, _, _, err := r.runOnce(ctx)
+
+	return published, err
+}
+
+// Run publishes the pending messages until the context is done, and returns its error.
+// After a failure it waits for the backoff of the retry policy, which grows with the consecutive failures.
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	failures := 0
+
+	for {
+		_, read, ev, err := r.runOnce(ctx)
+
+		wait := r.pollInterval
+
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			r.sink.HandleError(ctx, ev, err)
+
+			failures++
+			wait = r.policy.Backoff(failures)
+		case read == r.batchSize:
+			failures = 0
+			wait = 0
+		default:
+			failures = 0
+		}
+
+		if err := r.policy.sleeper.Sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// runOnce returns how many messages were published and read, and the event that failed along with the error.
+// When the event can't be decoded, it's a BasicEvent with its ID and name, and the error an OutboxMessageError.
+func (r *OutboxRelay) runOnce(ctx context.Context) (int, int, Event, error) {
+	messages, err := r.outbox.pending(ctx, r.batchSize)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	published := 0
+
+	for _, m := range messages {
+		ev, err := r.outbox.codec.Unmarshal(m.payload)
+		if err != nil {
+			ev = &BasicEvent{ID: m.eventID, Name: m.eventName}
+			err = OutboxMessageError{MessageID: m.id, EventID: m.eventID, EventName: m.eventName, Err: err}
+		} else {
+			err = r.bus.Dispatch(ctx, ev)
+		}
+
+		if err != nil && ctx.Err() != nil {
+			return published, len(messages), ev, fmt.Errorf("publish outbox message %d: %w", m.id, err)
+		}
+
+		if err != nil {
+			attempt := m.attempts + 1
+			park := !r.policy.shouldRetry(attempt, err)
+
+			if markErr := r.outbox.markFailed(ctx, m.id, err, park); markErr != nil {
+				return published, len(messages), ev, fmt.Errorf("publish outbox message %d: %w; %s", m.id, err, markErr)
+			}
+
+			if !park {
+				return published, len(messages), ev, fmt.Errorf("publish outbox message %d: %w", m.id, err)
+			}
+
+			r.sink.HandleError(ctx, ev, RetryError{Attempts: attempt, Err: fmt.Errorf("park outbox message %d: %w", m.id, err)})
+
+			continue
+		}
+
+		if err := r.outbox.markPublished(ctx, m.id); err != nil {
+			return published, len(messages), ev, err
+		}
+
+		published++
+	}
+
+	return published, len(messages), nil, nil
+}
@@ -0,0 +1,344 @@
+package cqs_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+func TestSQLOutbox(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+
+	newOutbox := func(t *testing.T) (*sql.DB, *cqs.SQLOutbox) {
+		t.Helper()
+
+		db := newSQLiteDB(t)
+		outbox, err := cqs.NewSQLOutbox(db, cqs.SQLiteDialect{}, newAccountEventRegistry(t))
+		require.NoError(err)
+		require.NoError(outbox.Migrate(ctx))
+
+		return db, outbox
+	}
+
+	newDeposited := func(amount int) *moneyDeposited {
+		ev := &moneyDeposited{Amount: amount}
+		ev.Hydrate(vo.NewID(), moneyDepositedName, vo.DateTimeNow(), vo.NewID(), 1)
+
+		return ev
+	}
+
+	t.Run(`Given events written to the outbox in a transaction,
+	when the transaction is committed or rolled back and the relay runs,
+	then only the committed events are dispatched, in order and once`, func(t *testing.T) {
+		db, outbox := newOutbox(t)
+
+		var amounts []int
+
+		bus := &cqs.ConcurrentEventsBus{}
+		require.NoError(bus.Subscribe(moneyDepositedName, &EventHandlerMock{
+			HandleFunc: func(_ context.Context, ev cqs.Event) error {
+				amounts = append(amounts, ev.(*moneyDeposited).Amount)
+				return nil
+			},
+		}))
+
+		tx, err := db.BeginTx(ctx, nil)
+		require.NoError(err)
+		require.NoError(outbox.Write(ctx, tx, newDeposited(1)))
+		require.NoError(tx.Rollback())
+
+		tx, err = db.BeginTx(ctx, nil)
+		require.NoError(err)
+		require.NoError(outbox.Write(ctx, tx, newDeposited(2), newDeposited(3)))
+		require.NoError(tx.Commit())
+
+		relay := cqs.NewOutboxRelay(outbox, bus)
+
+		published, err := relay.RunOnce(ctx)
+		require.NoError(err)
+		require.Equal(2, published)
+		require.Equal([]int{2, 3}, amounts)
+
+		published, err = relay.RunOnce(ctx)
+		require.NoError(err)
+		require.Zero(published)
+		require.Equal([]int{2, 3}, amounts)
+	})
+
+	t.Run(`Given an outbox with several messages and a bus that fails on the first one,
+	when the relay runs,
+	then it stops at the failed message and publishes it, and the following ones, on the next run`, func(t *testing.T) {
+		db, outbox := newOutbox(t)
+		require.NoError(outbox.Write(ctx, db, newDeposited(1), newDeposited(2)))
+
+		errDispatch := errors.New("dispatch failed")
+		fail := true
+		handler := &EventHandlerMock{
+			HandleFunc: func(context.Context, cqs.Event) error {
+				if fail {
+					return errDispatch
+				}
+
+				return nil
+			},
+		}
+
+		bus := &cqs.ConcurrentEventsBus{}
+		require.NoError(bus.Subscribe(moneyDepositedName, handler))
+
+		relay := cqs.NewOutboxRelay(outbox, bus, cqs.OutboxRelayBatchSizeOpt(10))
+
+		published, err := relay.RunOnce(ctx)
+		require.ErrorContains(err, errDispatch.Error())
+		require.Zero(published)
+		require.Len(handler.HandleCalls(), 1)
+
+		fail = false
+
+		published, err = relay.RunOnce(ctx)
+		require.NoError(err)
+		require.Equal(2, published)
+		require.Len(handler.HandleCalls(), 3)
+	})
+
+	t.Run(`Given a running relay with a bus that fails once,
+	when events are written to the outbox,
+	then the failure goes to the error sink and the events are dispatched after the backoff`, func(t *testing.T) {
+		db, outbox := newOutbox(t)
+		errDispatch := errors.New("dispatch failed")
+
+		var calls, sinkCalls int32
+
+		bus := &cqs.ConcurrentEventsBus{}
+		require.NoError(bus.Subscribe(moneyDepositedName, &EventHandlerMock{
+			HandleFunc: func(context.Context, cqs.Event) error {
+				if atomic.AddInt32(&calls, 1) == 1 {
+					return errDispatch
+				}
+
+				return nil
+			},
+		}))
+
+		relay := cqs.NewOutboxRelay(outbox, bus,
+			cqs.OutboxRelayPollIntervalOpt(time.Millisecond),
+			cqs.OutboxRelayRetryOpt(cqs.NewRetryPolicy(cqs.RetryBackoffOpt(time.Millisecond, time.Millisecond))),
+			cqs.OutboxRelayErrorSinkOpt(cqs.ErrorSinkFunc(func(_ context.Context, ev cqs.Event, err error) {
+				if ev == nil || !strings.Contains(err.Error(), errDispatch.Error()) {
+					t.Errorf("unexpected error sink call: %v, %v", ev, err)
+				}
+
+				atomic.AddInt32(&sinkCalls, 1)
+			})),
+		)
+
+		require.NoError(outbox.Write(ctx, db, newDeposited(1)))
+
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error)
+
+		go func() { done <- relay.Run(runCtx) }()
+
+		require.Eventually(func() bool { return atomic.LoadInt32(&calls) == 2 }, time.Second, time.Millisecond)
+		cancel()
+		require.ErrorIs(<-done, context.Canceled)
+		require.Equal(int32(1), atomic.LoadInt32(&sinkCalls))
+	})
+
+	t.Run(`Given no codec,
+	when an outbox is created,
+	then it returns an ErrEmptyEventCodec`, func(t *testing.T) {
+		_, err := cqs.NewSQLOutbox(newSQLiteDB(t), cqs.SQLiteDialect{}, nil)
+		require.ErrorIs(err, cqs.ErrEmptyEventCodec)
+	})
+
+	t.Run(`Given an outbox with a poison message followed by a good one,
+	when the relay runs until the attempts of the poison message run out,
+	then it's parked and reported, the good one is published, and it can be requeued`, func(t *testing.T) {
+		db, outbox := newOutbox(t)
+		require.NoError(outbox.Write(ctx, db, newDeposited(1), newDeposited(2)))
+
+		var (
+			amounts  []int
+			parked   []error
+			poisoned = true
+		)
+
+		bus := &cqs.ConcurrentEventsBus{}
+		require.NoError(bus.Subscribe(moneyDepositedName, &EventHandlerMock{
+			HandleFunc: func(_ context.Context, ev cqs.Event) error {
+				amount := ev.(*moneyDeposited).Amount
+				if amount == 1 && poisoned {
+					return errors.New("poison")
+				}
+
+				amounts = append(amounts, amount)
+
+				return nil
+			},
+		}))
+
+		relay := cqs.NewOutboxRelay(outbox, bus,
+			cqs.OutboxRelayRetryOpt(cqs.NewRetryPolicy(cqs.RetryMaxAttemptsOpt(2))),
+			cqs.OutboxRelayErrorSinkOpt(cqs.ErrorSinkFunc(func(_ context.Context, _ cqs.Event, err error) {
+				parked = append(parked, err)
+			})),
+		)
+
+		published, err := relay.RunOnce(ctx)
+		require.ErrorContains(err, "poison")
+		require.Zero(published)
+		require.Empty(amounts)
+
+		published, err = relay.RunOnce(ctx)
+		require.NoError(err)
+		require.Equal(1, published)
+		require.Equal([]int{2}, amounts)
+		require.Len(parked, 1)
+
+		var retryErr cqs.RetryError
+		require.ErrorAs(parked[0], &retryErr)
+		require.Equal(2, retryErr.Attempts)
+		require.ErrorContains(parked[0], "poison")
+
+		published, err = relay.RunOnce(ctx)
+		require.NoError(err)
+		require.Zero(published)
+
+		poisoned = false
+
+		requeued, err := outbox.RequeueFailed(ctx)
+		require.NoError(err)
+		require.Equal(1, requeued)
+
+		published, err = relay.RunOnce(ctx)
+		require.NoError(err)
+		require.Equal(1, published)
+		require.Equal([]int{2, 1}, amounts)
+	})
+
+	t.Run(`Given an outbox with a message whose error isn't retryable,
+	when the relay runs,
+	then it's parked at the first attempt and the following message is published`, func(t *testing.T) {
+		db, outbox := newOutbox(t)
+		require.NoError(outbox.Write(ctx, db, newDeposited(1), newDeposited(2)))
+
+		errInvalid := errors.New("invalid")
+
+		bus := &cqs.ConcurrentEventsBus{}
+		require.NoError(bus.Subscribe(moneyDepositedName, &EventHandlerMock{
+			HandleFunc: func(_ context.Context, ev cqs.Event) error {
+				if ev.(*moneyDeposited).Amount == 1 {
+					return errInvalid
+				}
+
+				return nil
+			},
+		}))
+
+		relay := cqs.NewOutboxRelay(outbox, bus, cqs.OutboxRelayRetryOpt(cqs.NewRetryPolicy(
+			cqs.RetryMaxAttemptsOpt(10),
+			cqs.RetryClassifierOpt(func(err error) bool { return !errors.Is(err, errInvalid) }),
+		)))
+
+		published, err := relay.RunOnce(ctx)
+		require.NoError(err)
+		require.Equal(1, published)
+	})
+
+	t.Run(`Given a running relay with a bus that fails twice,
+	when it runs,
+	then it waits for the growing backoff and then the poll interval with the sleeper of the policy`, func(t *testing.T) {
+		db, outbox := newOutbox(t)
+		require.NoError(outbox.Write(ctx, db, newDeposited(1)))
+
+		var (
+			calls  int
+			sleeps []time.Duration
+		)
+
+		bus := &cqs.ConcurrentEventsBus{}
+		require.NoError(bus.Subscribe(moneyDepositedName, &EventHandlerMock{
+			HandleFunc: func(context.Context, cqs.Event) error {
+				calls++
+				if calls <= 2 {
+					return errors.New("dispatch failed")
+				}
+
+				return nil
+			},
+		}))
+
+		runCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		relay := cqs.NewOutboxRelay(outbox, bus,
+			cqs.OutboxRelayPollIntervalOpt(time.Minute),
+			cqs.OutboxRelayRetryOpt(cqs.NewRetryPolicy(
+				cqs.RetryMaxAttemptsOpt(5),
+				cqs.RetryJitterOpt(0),
+				cqs.RetryBackoffOpt(time.Second, time.Hour),
+				cqs.RetrySleeperOpt(cqs.SleeperFunc(func(ctx context.Context, d time.Duration) error {
+					sleeps = append(sleeps, d)
+					if len(sleeps) == 3 {
+						cancel()
+					}
+
+					return ctx.Err()
+				})),
+			)),
+		)
+
+		require.ErrorIs(relay.Run(runCtx), context.Canceled)
+		require.Equal(3, calls)
+		require.Equal([]time.Duration{time.Second, 2 * time.Second, time.Minute}, sleeps)
+	})
+
+	t.Run(`Given an outbox message whose event can't be decoded,
+	when the relay gives up on it,
+	then the error sink receives an event with its ID and name and an OutboxMessageError`, func(t *testing.T) {
+		db, outbox := newOutbox(t)
+
+		writer, err := cqs.NewSQLOutbox(db, cqs.SQLiteDialect{}, cqs.BasicEventCodec{})
+		require.NoError(err)
+
+		unknown := newBasicEvent("unknown")
+		require.NoError(writer.Write(ctx, db, unknown))
+
+		var (
+			sinkEvents []cqs.Event
+			sinkErrs   []error
+		)
+
+		relay := cqs.NewOutboxRelay(outbox, &cqs.ConcurrentEventsBus{},
+			cqs.OutboxRelayRetryOpt(cqs.NewRetryPolicy(cqs.RetryMaxAttemptsOpt(1))),
+			cqs.OutboxRelayErrorSinkOpt(cqs.ErrorSinkFunc(func(_ context.Context, ev cqs.Event, err error) {
+				sinkEvents = append(sinkEvents, ev)
+				sinkErrs = append(sinkErrs, err)
+			})),
+		)
+
+		published, err := relay.RunOnce(ctx)
+		require.NoError(err)
+		require.Zero(published)
+		require.Len(sinkEvents, 1)
+		require.Equal(unknown.EventID(), sinkEvents[0].EventID())
+		require.Equal(cqs.EventName("unknown"), sinkEvents[0].EventName())
+
+		var msgErr cqs.OutboxMessageError
+		require.ErrorAs(sinkErrs[0], &msgErr)
+		require.Equal(unknown.EventID(), msgErr.EventID)
+		require.Equal(cqs.EventName("unknown"), msgErr.EventName)
+	})
+}
@@ -0,0 +1,174 @@
+package cqs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+const defaultProcessedStoreTable = "processed_events"
+
+// SQLProcessedStoreOpt is the common type of functions that set options on SQLProcessedStore construction.
+type SQLProcessedStoreOpt func(s *SQLProcessedStore)
+
+// SQLProcessedStoreTableOpt sets the name of the processed events table. The default is "processed_events".
+func SQLProcessedStoreTableOpt(table string) SQLProcessedStoreOpt {
+	return func(s *SQLProcessedStore) {
+		s.table = table
+	}
+}
+
+// SQLProcessedStoreNowOpt sets the clock used to record the processing time. It's useful for testing purposes.
+func SQLProcessedStoreNowOpt(now func() time.Time) SQLProcessedStoreOpt {
+	return func(s *SQLProcessedStore) {
+		if now != nil {
+			s.now = now
+		}
+	}
+}
+
+var _ TransactionalProcessedStore = &SQLProcessedStore{}
+
+// SQLProcessedStore is a TransactionalProcessedStore backed by a database/sql database.
+// It uses the transaction carried by the context, if any, so the handlers can get it with SQLTxFromContext
+// to make their changes atomically with the record.
+// The processing time is stored as unix nanoseconds, so Purge compares it the same way in every engine.
+type SQLProcessedStore struct {
+	db      *sql.DB
+	dialect SQLDialect
+	table   string
+	now     func() time.Time
+}
+
+// NewSQLProcessedStore is a constructor.
+func NewSQLProcessedStore(db *sql.DB, dialect SQLDialect, opts ...SQLProcessedStoreOpt) *SQLProcessedStore {
+	s := &SQLProcessedStore{
+		db:      db,
+		dialect: dialect,
+		table:   defaultProcessedStoreTable,
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// ProcessedStoreSchemaDialect is implemented by the SQL dialects that provide the table of the SQLProcessedStore.
+type ProcessedStoreSchemaDialect interface {
+	// ProcessedStoreSchema returns the DDL statements that create the table of the SQLProcessedStore.
+	ProcessedStoreSchema(table string) []string
+}
+
+// Migrate creates the processed events table if it doesn't exist. The dialect must implement ProcessedStoreSchemaDialect.
+func (s *SQLProcessedStore) Migrate(ctx context.Context) error {
+	sd, ok := s.dialect.(ProcessedStoreSchemaDialect)
+	if !ok {
+		return fmt.Errorf("migrate processed store: %w", ErrUnsupportedSQLSchema)
+	}
+
+	for _, stmt := range sd.ProcessedStoreSchema(s.table) {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migrate processed store: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// InTx is the TransactionalProcessedStore interface implementation.
+// If the context already carries a transaction, f joins it.
+func (s *SQLProcessedStore) InTx(ctx context.Context, f func(ctx context.Context) error) error {
+	if _, ok := SQLTxFromContext(ctx); ok {
+		return f(ctx)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := f(ContextWithSQLTx(ctx, tx)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if s.dialect.IsUniqueViolation(err) {
+			return fmt.Errorf("%w: %s", ErrEventAlreadyProcessed, err)
+		}
+
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// IsProcessed is the ProcessedStore interface implementation.
+func (s *SQLProcessedStore) IsProcessed(ctx context.Context, handlerName string, eventID vo.ID) (bool, error) {
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf("SELECT 1 FROM %s WHERE handler_name = %s AND event_id = %s",
+		s.table, s.dialect.Placeholder(1), s.dialect.Placeholder(2))
+
+	var one int
+
+	err := s.querier(ctx).QueryRowContext(ctx, query, handlerName, eventID).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("read processed event: %w", err)
+	}
+
+	return true, nil
+}
+
+// MarkProcessed is the ProcessedStore interface implementation.
+func (s *SQLProcessedStore) MarkProcessed(ctx context.Context, handlerName string, eventID vo.ID) error {
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf("INSERT INTO %s (handler_name, event_id, processed_at) VALUES (%s)",
+		s.table, placeholders(s.dialect, 1, 3))
+
+	_, err := s.querier(ctx).ExecContext(ctx, query, handlerName, eventID, s.now().UnixNano())
+	if s.dialect.IsUniqueViolation(err) {
+		return fmt.Errorf("%w: %s by %s", ErrEventAlreadyProcessed, eventID, handlerName)
+	}
+
+	if err != nil {
+		return fmt.Errorf("mark processed event: %w", err)
+	}
+
+	return nil
+}
+
+// Purge removes the records processed before the given time, and returns how many were removed.
+func (s *SQLProcessedStore) Purge(ctx context.Context, before time.Time) (int64, error) {
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf("DELETE FROM %s WHERE processed_at < %s", s.table, s.dialect.Placeholder(1))
+
+	res, err := s.querier(ctx).ExecContext(ctx, query, before.UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("purge processed events: %w", err)
+	}
+
+	return res.RowsAffected()
+}
+
+type sqlQuerier interface {
+	queryRower
+	SQLExecer
+}
+
+func (s *SQLProcessedStore) querier(ctx context.Context) sqlQuerier {
+	if tx, ok := SQLTxFromContext(ctx); ok {
+		return tx
+	}
+
+	return s.db
+}
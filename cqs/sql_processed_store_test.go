@@ -0,0 +1,130 @@
+package cqs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+)
+
+func TestSQLProcessedStore(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+
+	t.Run(`Given an idempotent event handler with an SQL processed store,
+	when the handler changes the database in the transaction of the context,
+	then its changes and the record are committed or rolled back together`, func(t *testing.T) {
+		db := newSQLiteDB(t)
+		store := cqs.NewSQLProcessedStore(db, cqs.SQLiteDialect{}, cqs.SQLProcessedStoreTableOpt("handled"))
+		require.NoError(store.Migrate(ctx))
+
+		_, err := db.ExecContext(ctx, "CREATE TABLE deposits (amount INTEGER NOT NULL)")
+		require.NoError(err)
+
+		errHandler := errors.New("handler failed")
+		fail := true
+		handler := &EventHandlerMock{HandleFunc: func(ctx context.Context, ev cqs.Event) error {
+			tx, ok := cqs.SQLTxFromContext(ctx)
+			if !ok {
+				return errors.New("no transaction")
+			}
+
+			if _, err := tx.ExecContext(ctx, "INSERT INTO deposits (amount) VALUES (1)"); err != nil {
+				return err
+			}
+
+			if fail {
+				return errHandler
+			}
+
+			return nil
+		}}
+		h := cqs.NewIdempotentEventHandler(handler, store)
+		ev := newBasicEvent("foo")
+
+		countDeposits := func() int {
+			var count int
+			require.NoError(db.QueryRowContext(ctx, "SELECT COUNT(*) FROM deposits").Scan(&count))
+
+			return count
+		}
+
+		require.ErrorIs(h.Handle(ctx, ev), errHandler)
+		require.Zero(countDeposits())
+
+		processed, err := store.IsProcessed(ctx, "*cqs_test.EventHandlerMock", ev.EventID())
+		require.NoError(err)
+		require.False(processed)
+
+		fail = false
+
+		require.NoError(h.Handle(ctx, ev))
+		require.NoError(h.Handle(ctx, ev))
+		require.Equal(1, countDeposits())
+		require.Len(handler.HandleCalls(), 2)
+
+		processed, err = store.IsProcessed(ctx, "*cqs_test.EventHandlerMock", ev.EventID())
+		require.NoError(err)
+		require.True(processed)
+	})
+
+	t.Run(`Given an SQL processed store with records,
+	when they are marked again or purged,
+	then it returns ErrEventAlreadyProcessed or removes the old ones`, func(t *testing.T) {
+		store := cqs.NewSQLProcessedStore(newSQLiteDB(t), cqs.SQLiteDialect{})
+		require.NoError(store.Migrate(ctx))
+
+		ev := newBasicEvent("foo")
+		require.NoError(store.MarkProcessed(ctx, "handler", ev.EventID()))
+		require.ErrorIs(store.MarkProcessed(ctx, "handler", ev.EventID()), cqs.ErrEventAlreadyProcessed)
+		require.NoError(store.MarkProcessed(ctx, "other", ev.EventID()))
+
+		purged, err := store.Purge(ctx, time.Now().Add(-time.Hour))
+		require.NoError(err)
+		require.Zero(purged)
+
+		purged, err = store.Purge(ctx, time.Now().Add(time.Second))
+		require.NoError(err)
+		require.Equal(int64(2), purged)
+
+		processed, err := store.IsProcessed(ctx, "handler", ev.EventID())
+		require.NoError(err)
+		require.False(processed)
+	})
+	t.Run(`Given an SQL processed store with records processed at times with different fractions of a second,
+	when they are purged with cutoffs in other time zones and around the boundary,
+	then only the records processed strictly before the cutoff are removed`, func(t *testing.T) {
+		processedAt := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+		store := cqs.NewSQLProcessedStore(newSQLiteDB(t), cqs.SQLiteDialect{},
+			cqs.SQLProcessedStoreNowOpt(func() time.Time { return processedAt }))
+		require.NoError(store.Migrate(ctx))
+
+		for _, offset := range []time.Duration{0, 500 * time.Millisecond, time.Second} {
+			processedAt = time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC).Add(offset)
+			require.NoError(store.MarkProcessed(ctx, "handler", newBasicEvent("foo").EventID()))
+		}
+
+		cutoff := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC).Add(500 * time.Millisecond)
+
+		purged, err := store.Purge(ctx, cutoff.In(time.FixedZone("UTC-3", -3*60*60)))
+		require.NoError(err)
+		require.Equal(int64(1), purged)
+
+		purged, err = store.Purge(ctx, cutoff.In(time.FixedZone("UTC+5", 5*60*60)).Add(time.Nanosecond))
+		require.NoError(err)
+		require.Equal(int64(1), purged)
+
+		purged, err = store.Purge(ctx, cutoff.Add(500*time.Millisecond))
+		require.NoError(err)
+		require.Zero(purged)
+
+		purged, err = store.Purge(ctx, cutoff.Add(500*time.Millisecond+time.Nanosecond))
+		require.NoError(err)
+		require.Equal(int64(1), purged)
+	})
+}
@@ -0,0 +1,216 @@
+package cqs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+const defaultSagaStoreTable = "sagas"
+
+// SQLSagaStoreOpt is the common type of functions that set options on SQLSagaStore construction.
+type SQLSagaStoreOpt func(s *SQLSagaStore)
+
+// SQLSagaStoreTableOpt sets the name of the sagas table. The default is "sagas".
+func SQLSagaStoreTableOpt(table string) SQLSagaStoreOpt {
+	return func(s *SQLSagaStore) {
+		s.table = table
+	}
+}
+
+var _ SagaStore = &SQLSagaStore{}
+
+// SQLSagaStore is a SagaStore backed by a database/sql database.
+// The timeouts are kept along with the instance, which is indexed by its next timeout.
+type SQLSagaStore struct {
+	db      *sql.DB
+	dialect SQLDialect
+	table   string
+}
+
+// NewSQLSagaStore is a constructor.
+func NewSQLSagaStore(db *sql.DB, dialect SQLDialect, opts ...SQLSagaStoreOpt) *SQLSagaStore {
+	s := &SQLSagaStore{
+		db:      db,
+		dialect: dialect,
+		table:   defaultSagaStoreTable,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// SagaStoreSchemaDialect is implemented by the SQL dialects that provide the table of the SQLSagaStore.
+type SagaStoreSchemaDialect interface {
+	// SagaStoreSchema returns the DDL statements that create the table of the SQLSagaStore.
+	SagaStoreSchema(table string) []string
+}
+
+// Migrate creates the sagas table if it doesn't exist. The dialect must implement SagaStoreSchemaDialect.
+func (s *SQLSagaStore) Migrate(ctx context.Context) error {
+	sd, ok := s.dialect.(SagaStoreSchemaDialect)
+	if !ok {
+		return fmt.Errorf("migrate saga store: %w", ErrUnsupportedSQLSchema)
+	}
+
+	for _, stmt := range sd.SagaStoreSchema(s.table) {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migrate saga store: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Load is the SagaStore interface implementation.
+func (s *SQLSagaStore) Load(ctx context.Context, saga, id string) (SagaRecord, error) {
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf("SELECT status, state, steps, timeouts, version FROM %s WHERE saga = %s AND saga_id = %s",
+		s.table, s.dialect.Placeholder(1), s.dialect.Placeholder(2))
+
+	var (
+		record          = SagaRecord{Saga: saga, ID: id}
+		steps, timeouts []byte
+	)
+
+	err := s.db.QueryRowContext(ctx, query, saga, id).
+		Scan(&record.Status, &record.State, &steps, &timeouts, &record.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return SagaRecord{}, fmt.Errorf("%w: %s %s", ErrSagaNotFound, saga, id)
+	}
+
+	if err != nil {
+		return SagaRecord{}, fmt.Errorf("load saga: %w", err)
+	}
+
+	if err := json.Unmarshal(steps, &record.Steps); err != nil {
+		return SagaRecord{}, fmt.Errorf("unmarshal saga steps: %w", err)
+	}
+
+	if err := json.Unmarshal(timeouts, &record.Timeouts); err != nil {
+		return SagaRecord{}, fmt.Errorf("unmarshal saga timeouts: %w", err)
+	}
+
+	return record, nil
+}
+
+// Save is the SagaStore interface implementation.
+func (s *SQLSagaStore) Save(ctx context.Context, record SagaRecord) error {
+	steps, err := json.Marshal(record.Steps)
+	if err != nil {
+		return fmt.Errorf("marshal saga steps: %w", err)
+	}
+
+	timeouts, err := json.Marshal(record.Timeouts)
+	if err != nil {
+		return fmt.Errorf("marshal saga timeouts: %w", err)
+	}
+
+	state := record.State
+	if state == nil {
+		state = json.RawMessage{}
+	}
+
+	var next sql.NullInt64
+
+	for _, t := range record.Timeouts {
+		if !next.Valid || t.At.UnixNano() < next.Int64 {
+			next = sql.NullInt64{Int64: t.At.UnixNano(), Valid: true}
+		}
+	}
+
+	if record.Version <= 1 {
+		return s.insert(ctx, record, []byte(state), steps, timeouts, next)
+	}
+
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf(`UPDATE %s SET status = %s, state = %s, steps = %s, timeouts = %s, next_timeout_at = %s,
+	version = %s, updated_at = %s
+WHERE saga = %s AND saga_id = %s AND version = %s`,
+		s.table, s.dialect.Placeholder(1), s.dialect.Placeholder(2), s.dialect.Placeholder(3),
+		s.dialect.Placeholder(4), s.dialect.Placeholder(5), s.dialect.Placeholder(6), s.dialect.Placeholder(7),
+		s.dialect.Placeholder(8), s.dialect.Placeholder(9), s.dialect.Placeholder(10))
+
+	res, err := s.db.ExecContext(ctx, query, string(record.Status), []byte(state), steps, timeouts, next,
+		record.Version, vo.DateTimeNow(), record.Saga, record.ID, record.Version-1)
+	if err != nil {
+		return fmt.Errorf("save saga: %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("save saga: %w", err)
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("%w: saga %s %s expected version %d", ErrConcurrencyConflict, record.Saga, record.ID,
+			record.Version-1)
+	}
+
+	return nil
+}
+
+func (s *SQLSagaStore) insert(ctx context.Context, record SagaRecord, state, steps, timeouts []byte, next sql.NullInt64) error {
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf(`INSERT INTO %s (saga, saga_id, status, state, steps, timeouts, next_timeout_at, version,
+	updated_at) VALUES (%s)`, s.table, placeholders(s.dialect, 1, 9))
+
+	_, err := s.db.ExecContext(ctx, query, record.Saga, record.ID, string(record.Status), state, steps, timeouts, next,
+		record.Version, vo.DateTimeNow())
+	if s.dialect.IsUniqueViolation(err) {
+		return fmt.Errorf("%w: saga %s %s already exists", ErrConcurrencyConflict, record.Saga, record.ID)
+	}
+
+	if err != nil {
+		return fmt.Errorf("save saga: %w", err)
+	}
+
+	return nil
+}
+
+// DueTimeouts is the SagaStore interface implementation.
+func (s *SQLSagaStore) DueTimeouts(ctx context.Context, saga string, now time.Time) ([]SagaTimeout, error) {
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf(`SELECT timeouts FROM %s
+WHERE saga = %s AND status = %s AND next_timeout_at <= %s ORDER BY next_timeout_at`,
+		s.table, s.dialect.Placeholder(1), s.dialect.Placeholder(2), s.dialect.Placeholder(3))
+
+	rows, err := s.db.QueryContext(ctx, query, saga, string(SagaRunning), now.UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("load due timeouts: %w", err)
+	}
+	defer rows.Close()
+
+	var due []SagaTimeout
+
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("load due timeouts: %w", err)
+		}
+
+		var timeouts []SagaTimeout
+		if err := json.Unmarshal(data, &timeouts); err != nil {
+			return nil, fmt.Errorf("unmarshal saga timeouts: %w", err)
+		}
+
+		for _, t := range timeouts {
+			if !t.At.After(now) {
+				due = append(due, t)
+			}
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("load due timeouts: %w", err)
+	}
+
+	return due, nil
+}
@@ -0,0 +1,110 @@
+package cqs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+const defaultSnapshotStoreTable = "snapshots"
+
+// SQLSnapshotStoreOpt is the common type of functions that set options on SQLSnapshotStore construction.
+type SQLSnapshotStoreOpt func(s *SQLSnapshotStore)
+
+// SQLSnapshotStoreTableOpt sets the name of the snapshots table. The default is "snapshots".
+func SQLSnapshotStoreTableOpt(table string) SQLSnapshotStoreOpt {
+	return func(s *SQLSnapshotStore) {
+		s.table = table
+	}
+}
+
+var _ SnapshotStore = &SQLSnapshotStore{}
+
+// SQLSnapshotStore is a SnapshotStore backed by a database/sql database. It keeps a row per aggregate.
+type SQLSnapshotStore struct {
+	db      *sql.DB
+	dialect SQLDialect
+	table   string
+}
+
+// NewSQLSnapshotStore is a constructor.
+func NewSQLSnapshotStore(db *sql.DB, dialect SQLDialect, opts ...SQLSnapshotStoreOpt) *SQLSnapshotStore {
+	s := &SQLSnapshotStore{
+		db:      db,
+		dialect: dialect,
+		table:   defaultSnapshotStoreTable,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// SnapshotStoreSchemaDialect is implemented by the SQL dialects that provide the table of the SQLSnapshotStore.
+type SnapshotStoreSchemaDialect interface {
+	// SnapshotStoreSchema returns the DDL statements that create the table of the SQLSnapshotStore.
+	SnapshotStoreSchema(table string) []string
+}
+
+// Migrate creates the snapshots table if it doesn't exist. The dialect must implement SnapshotStoreSchemaDialect.
+func (s *SQLSnapshotStore) Migrate(ctx context.Context) error {
+	sd, ok := s.dialect.(SnapshotStoreSchemaDialect)
+	if !ok {
+		return fmt.Errorf("migrate snapshot store: %w", ErrUnsupportedSQLSchema)
+	}
+
+	for _, stmt := range sd.SnapshotStoreSchema(s.table) {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migrate snapshot store: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Save is the SnapshotStore interface implementation.
+func (s *SQLSnapshotStore) Save(ctx context.Context, snapshot Snapshot) error {
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf(`INSERT INTO %[1]s (aggregate_id, version, schema_version, at, payload) VALUES (%[2]s)
+ON CONFLICT (aggregate_id) DO UPDATE SET version = excluded.version, schema_version = excluded.schema_version,
+	at = excluded.at, payload = excluded.payload
+WHERE %[1]s.version <= excluded.version`, s.table, placeholders(s.dialect, 1, 5))
+
+	_, err := s.db.ExecContext(ctx, query, snapshot.AggregateID, int(snapshot.Version), int(snapshot.SchemaVersion),
+		snapshot.At, snapshot.Payload)
+	if err != nil {
+		return fmt.Errorf("save snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// Load is the SnapshotStore interface implementation.
+func (s *SQLSnapshotStore) Load(ctx context.Context, aggregateID vo.ID) (Snapshot, error) {
+	//nolint:gosec // the table name is set by the developer.
+	query := fmt.Sprintf("SELECT version, schema_version, at, payload FROM %s WHERE aggregate_id = %s",
+		s.table, s.dialect.Placeholder(1))
+
+	var (
+		version, schema int
+		snapshot        = Snapshot{AggregateID: aggregateID}
+	)
+
+	err := s.db.QueryRowContext(ctx, query, aggregateID).Scan(&version, &schema, &snapshot.At, &snapshot.Payload)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Snapshot{}, fmt.Errorf("%w: aggregate %s", ErrSnapshotNotFound, aggregateID)
+	}
+
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("load snapshot: %w", err)
+	}
+
+	snapshot.Version = EventVersion(version)
+	snapshot.SchemaVersion = SchemaVersion(schema)
+
+	return snapshot, nil
+}
@@ -0,0 +1,19 @@
+package cqs_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+)
+
+func TestSQLSnapshotStore(t *testing.T) {
+	testSnapshotStore(t, func() cqs.SnapshotStore {
+		store := cqs.NewSQLSnapshotStore(newSQLiteDB(t), cqs.SQLiteDialect{}, cqs.SQLSnapshotStoreTableOpt("account_snapshots"))
+		require.NoError(t, store.Migrate(context.Background()))
+
+		return store
+	})
+}
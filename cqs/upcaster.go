@@ -0,0 +1,119 @@
+package cqs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrEmptyUpcaster             = errors.New("empty upcaster")
+	ErrUpcasterAlreadyRegistered = errors.New("upcaster already registered")
+	ErrUpcasterChainGap          = errors.New("upcaster chain gap")
+)
+
+// Upcaster transforms the envelope of an event from a schema version to the next one.
+// The name and the schema version of the returned envelope are set by the UpcasterChain.
+type Upcaster func(env EventEnvelope) (EventEnvelope, error)
+
+// UpcastPayload returns an Upcaster that decodes the payload as From and encodes the result of f as the new payload.
+func UpcastPayload[From, To any](f func(From) (To, error)) Upcaster {
+	return func(env EventEnvelope) (EventEnvelope, error) {
+		var from From
+		if err := json.Unmarshal(env.Payload, &from); err != nil {
+			return env, fmt.Errorf("unmarshal %s payload: %w", typeName[From](), err)
+		}
+
+		to, err := f(from)
+		if err != nil {
+			return env, err
+		}
+
+		payload, err := json.Marshal(to)
+		if err != nil {
+			return env, fmt.Errorf("marshal %s payload: %w", typeName[To](), err)
+		}
+
+		env.Payload = payload
+
+		return env, nil
+	}
+}
+
+// UpcasterChain migrates the envelopes of old schema versions step by step to the latest one, before they are
+// decoded. Use it with EventRegistryUpcastersOpt.
+type UpcasterChain struct {
+	mu        sync.RWMutex
+	upcasters map[eventType]Upcaster
+}
+
+// NewUpcasterChain is a constructor.
+func NewUpcasterChain() *UpcasterChain {
+	return &UpcasterChain{
+		upcasters: make(map[eventType]Upcaster),
+	}
+}
+
+// Register registers the upcaster of the events with the given name from the given schema version to the next one.
+// The steps are registered in order, from the schema version 1, so it returns an ErrUpcasterChainGap when the
+// upcaster from the previous schema version isn't registered.
+func (c *UpcasterChain) Register(name EventName, from SchemaVersion, up Upcaster) error {
+	if name == "" {
+		return ErrEmptyEventName
+	}
+
+	if from < 1 {
+		return fmt.Errorf("%w: %d", ErrInvalidSchemaVersion, from)
+	}
+
+	if up == nil {
+		return ErrEmptyUpcaster
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := eventType{name: name, schema: from}
+	if _, ok := c.upcasters[key]; ok {
+		return fmt.Errorf("%w: %s v%d", ErrUpcasterAlreadyRegistered, name, from)
+	}
+
+	if from > 1 {
+		if _, ok := c.upcasters[eventType{name: name, schema: from - 1}]; !ok {
+			return fmt.Errorf("%w: %s has no upcaster from v%d", ErrUpcasterChainGap, name, from-1)
+		}
+	}
+
+	c.upcasters[key] = up
+
+	return nil
+}
+
+// Upcast applies the upcasters of the envelope until there isn't any for its schema version.
+func (c *UpcasterChain) Upcast(env EventEnvelope) (EventEnvelope, error) {
+	if env.SchemaVersion == 0 {
+		env.SchemaVersion = defaultSchemaVersion
+	}
+
+	for {
+		c.mu.RLock()
+		up, ok := c.upcasters[eventType{name: env.Name, schema: env.SchemaVersion}]
+		c.mu.RUnlock()
+
+		if !ok {
+			return env, nil
+		}
+
+		name, from := env.Name, env.SchemaVersion
+
+		upcasted, err := up(env)
+		if err != nil {
+			return env, fmt.Errorf("upcast event %s from v%d: %w", name, from, err)
+		}
+
+		upcasted.Name = name
+		upcasted.SchemaVersion = from + 1
+		env = upcasted
+	}
+}
@@ -0,0 +1,163 @@
+package cqs_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lucianogarciaz/kit/cqs"
+	"github.com/lucianogarciaz/kit/vo"
+)
+
+type depositPayloadV1 struct {
+	Amount int `json:"amount"`
+}
+
+type depositPayloadV2 struct {
+	Amount   int    `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+type depositPayloadV3 struct {
+	Cents    int    `json:"cents"`
+	Currency string `json:"currency"`
+}
+
+type moneyDepositedV3 struct {
+	cqs.BasicEvent
+	Cents    int    `json:"cents"`
+	Currency string `json:"currency"`
+}
+
+func (moneyDepositedV3) EventSchemaVersion() cqs.SchemaVersion {
+	return 3
+}
+
+func upcastDepositV1(p depositPayloadV1) (depositPayloadV2, error) {
+	return depositPayloadV2{Amount: p.Amount, Currency: "EUR"}, nil
+}
+
+func upcastDepositV2(p depositPayloadV2) (depositPayloadV3, error) {
+	return depositPayloadV3{Cents: p.Amount * 100, Currency: p.Currency}, nil
+}
+
+func TestUpcasterChainRegister(t *testing.T) {
+	require := require.New(t)
+
+	up := cqs.UpcastPayload(upcastDepositV1)
+
+	t.Run(`Given an upcaster chain,
+	when an invalid upcaster is registered,
+	then it returns an error`, func(t *testing.T) {
+		c := cqs.NewUpcasterChain()
+
+		require.ErrorIs(c.Register("", 1, up), cqs.ErrEmptyEventName)
+		require.ErrorIs(c.Register(moneyDepositedName, 0, up), cqs.ErrInvalidSchemaVersion)
+		require.ErrorIs(c.Register(moneyDepositedName, 1, nil), cqs.ErrEmptyUpcaster)
+
+		require.NoError(c.Register(moneyDepositedName, 1, up))
+		require.ErrorIs(c.Register(moneyDepositedName, 1, up), cqs.ErrUpcasterAlreadyRegistered)
+	})
+
+	t.Run(`Given an upcaster chain,
+	when an upcaster that leaves a gap is registered,
+	then it returns an ErrUpcasterChainGap`, func(t *testing.T) {
+		c := cqs.NewUpcasterChain()
+
+		require.NoError(c.Register(moneyDepositedName, 1, up))
+		require.ErrorIs(c.Register(moneyDepositedName, 3, up), cqs.ErrUpcasterChainGap)
+		require.ErrorIs(c.Register(accountOpenedName, 2, up), cqs.ErrUpcasterChainGap)
+
+		require.NoError(c.Register(moneyDepositedName, 2, up))
+		require.NoError(c.Register(moneyDepositedName, 3, up))
+	})
+}
+
+func TestUpcasterChainUpcast(t *testing.T) {
+	require := require.New(t)
+
+	newChain := func(t *testing.T) *cqs.UpcasterChain {
+		t.Helper()
+
+		c := cqs.NewUpcasterChain()
+		require.NoError(c.Register(moneyDepositedName, 1, cqs.UpcastPayload(upcastDepositV1)))
+		require.NoError(c.Register(moneyDepositedName, 2, cqs.UpcastPayload(upcastDepositV2)))
+
+		return c
+	}
+
+	t.Run(`Given an upcaster chain,
+	when an envelope of an old schema version is upcasted,
+	then it's transformed step by step to the latest version`, func(t *testing.T) {
+		c := newChain(t)
+
+		env, err := c.Upcast(cqs.EventEnvelope{
+			Name:          moneyDepositedName,
+			SchemaVersion: 1,
+			Payload:       json.RawMessage(`{"amount":10}`),
+		})
+		require.NoError(err)
+		require.Equal(moneyDepositedName, env.Name)
+		require.Equal(cqs.SchemaVersion(3), env.SchemaVersion)
+		require.JSONEq(`{"cents":1000,"currency":"EUR"}`, string(env.Payload))
+
+		env, err = c.Upcast(cqs.EventEnvelope{
+			Name:          moneyDepositedName,
+			SchemaVersion: 3,
+			Payload:       json.RawMessage(`{"cents":5,"currency":"USD"}`),
+		})
+		require.NoError(err)
+		require.Equal(cqs.SchemaVersion(3), env.SchemaVersion)
+		require.JSONEq(`{"cents":5,"currency":"USD"}`, string(env.Payload))
+	})
+
+	t.Run(`Given an upcaster chain with a failing upcaster,
+	when an envelope is upcasted,
+	then it returns the upcaster error`, func(t *testing.T) {
+		errUpcast := errors.New("upcast failed")
+
+		c := cqs.NewUpcasterChain()
+		require.NoError(c.Register(moneyDepositedName, 1, func(env cqs.EventEnvelope) (cqs.EventEnvelope, error) {
+			return env, errUpcast
+		}))
+
+		_, err := c.Upcast(cqs.EventEnvelope{Name: moneyDepositedName, SchemaVersion: 1})
+		require.ErrorIs(err, errUpcast)
+
+		_, err = newChain(t).Upcast(cqs.EventEnvelope{
+			Name:          moneyDepositedName,
+			SchemaVersion: 1,
+			Payload:       json.RawMessage(`{"amount":"ten"}`),
+		})
+		require.Error(err)
+	})
+
+	t.Run(`Given an event registry with the latest schema version and an upcaster chain,
+	when an event stored with an old schema version is unmarshaled,
+	then it's decoded as the latest version`, func(t *testing.T) {
+		oldRegistry := cqs.NewEventRegistry()
+		require.NoError(cqs.RegisterEvent[moneyDeposited](oldRegistry, moneyDepositedName, 1))
+
+		var old moneyDeposited
+
+		old.Hydrate(vo.NewID(), moneyDepositedName, vo.DateTimeNow(), vo.NewID(), 1)
+		old.Amount = 10
+
+		data, err := oldRegistry.Marshal(&old)
+		require.NoError(err)
+
+		registry := cqs.NewEventRegistry(cqs.EventRegistryUpcastersOpt(newChain(t)))
+		require.NoError(cqs.RegisterEvent[moneyDepositedV3](registry, moneyDepositedName, 3))
+
+		ev, err := registry.Unmarshal(data)
+		require.NoError(err)
+		requireSameEvent(t, &old, ev)
+
+		deposited, ok := ev.(*moneyDepositedV3)
+		require.True(ok)
+		require.Equal(1000, deposited.Cents)
+		require.Equal("EUR", deposited.Currency)
+	})
+}